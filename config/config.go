@@ -61,6 +61,14 @@ func GetDBFolderPath() string {
 	return dbFolderPath
 }
 
+func GetLogFolderPath() string {
+	logFolderPath := os.Getenv("XUI_LOG_FOLDER")
+	if logFolderPath == "" {
+		logFolderPath = "/var/log/x-ui"
+	}
+	return logFolderPath
+}
+
 func GetDBPath() string {
 	return fmt.Sprintf("%s/%s.db", GetDBFolderPath(), GetName())
 }
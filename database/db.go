@@ -4,6 +4,7 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"strconv"
 	"x-ui/config"
 	"x-ui/database/model"
 	"x-ui/xray"
@@ -40,12 +41,151 @@ func initInbound() error {
 	return db.AutoMigrate(&model.Inbound{})
 }
 
+func initOutboundTraffics() error {
+	return db.AutoMigrate(&model.OutboundTraffics{})
+}
+
+func initGeoAsset() error {
+	err := db.AutoMigrate(&model.GeoAsset{})
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = db.Model(&model.GeoAsset{}).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		assets := []*model.GeoAsset{
+			{
+				Name:        "geoip.dat",
+				Type:        xray.GeoIP,
+				Url:         "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geoip.dat",
+				ChecksumUrl: "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geoip.dat.sha256sum",
+				Enable:      true,
+			},
+			{
+				Name:        "geosite.dat",
+				Type:        xray.GeoSite,
+				Url:         "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geosite.dat",
+				ChecksumUrl: "https://github.com/Loyalsoldier/v2ray-rules-dat/releases/latest/download/geosite.dat.sha256sum",
+				Enable:      true,
+			},
+			{
+				Name:   "iran.dat",
+				Type:   xray.GeoSite,
+				Url:    "https://github.com/bootmortis/iran-hosted-domains/releases/latest/download/iran.dat",
+				Enable: true,
+			},
+		}
+		return db.Create(assets).Error
+	}
+	return nil
+}
+
 func initSetting() error {
 	return db.AutoMigrate(&model.Setting{})
 }
 func initInboundClientIps() error {
 	return db.AutoMigrate(&model.InboundClientIps{})
 }
+func initClientIpHistory() error {
+	return db.AutoMigrate(&model.ClientIpHistory{})
+}
+func initIpLimitPolicy() error {
+	err := db.AutoMigrate(&model.IpLimitPolicy{}, &model.IpLimitBan{})
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = db.Model(&model.IpLimitPolicy{}).Where("inbound_id = ? and email = ?", 0, "").Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return db.Create(&model.IpLimitPolicy{
+		Window:   3,
+		SubnetV4: 32,
+		SubnetV6: 128,
+	}).Error
+}
+func initClientPlan() error {
+	return db.AutoMigrate(&model.ClientPlan{})
+}
+func initCustomer() error {
+	return db.AutoMigrate(&model.Customer{})
+}
+func initTrafficReset() error {
+	return db.AutoMigrate(&model.TrafficResetPolicy{}, &model.TrafficPeriod{})
+}
+func initPolicyLevel() error {
+	return db.AutoMigrate(&model.PolicyLevel{})
+}
+func initTgClient() error {
+	return db.AutoMigrate(&model.TgClient{}, &model.TgClientUid{}, &model.TgClientMsg{}, &model.TgSession{}, &model.TgAdminAction{},
+		&model.ReferralCode{}, &model.Referral{})
+}
+func initPayment() error {
+	return db.AutoMigrate(&model.Payment{})
+}
+func initNotifyRule() error {
+	// rules are seeded once, the admin may delete them afterwards
+	seed := !db.Migrator().HasTable(&model.NotifyRule{})
+	err := db.AutoMigrate(&model.NotifyRule{}, &model.NotifyState{})
+	if err != nil || !seed {
+		return err
+	}
+
+	// the alerts the bot used to send on its own, the traffic and expiry ones went to the client only and the cpu
+	// one takes over the threshold of the former tgCpu setting
+	cpuThreshold := 0
+	var tgCpu []string
+	err = db.Model(&model.Setting{}).Where("key = ?", "tgCpu").Pluck("value", &tgCpu).Error
+	if err != nil {
+		return err
+	}
+	if len(tgCpu) > 0 {
+		cpuThreshold, _ = strconv.Atoi(tgCpu[0])
+	}
+	cpuRule := &model.NotifyRule{
+		Name:      "CPU",
+		Enable:    cpuThreshold > 0,
+		Condition: model.NotifyCpu,
+		Threshold: float64(cpuThreshold),
+		Targets:   "admins",
+		Cooldown:  10,
+	}
+	if cpuThreshold <= 0 {
+		cpuRule.Threshold = 80
+	}
+	return db.Create([]*model.NotifyRule{
+		{
+			Name:      "Traffic 85%",
+			Enable:    true,
+			Condition: model.NotifyTraffic,
+			Threshold: 85,
+			Targets:   "client",
+			Cooldown:  24 * 60,
+		},
+		{
+			Name:      "Expiry 1 day",
+			Enable:    true,
+			Condition: model.NotifyExpiry,
+			Threshold: 1,
+			Targets:   "client",
+			Cooldown:  24 * 60,
+		},
+		cpuRule,
+	}).Error
+}
+func initBroadcast() error {
+	return db.AutoMigrate(&model.Broadcast{}, &model.BroadcastDelivery{})
+}
+func initAccessStat() error {
+	return db.AutoMigrate(&model.AccessStat{})
+}
 func initClientTraffic() error {
 	return db.AutoMigrate(&xray.ClientTraffic{})
 }
@@ -81,6 +221,14 @@ func InitDB(dbPath string) error {
 	if err != nil {
 		return err
 	}
+	err = initOutboundTraffics()
+	if err != nil {
+		return err
+	}
+	err = initGeoAsset()
+	if err != nil {
+		return err
+	}
 	err = initSetting()
 	if err != nil {
 		return err
@@ -89,6 +237,50 @@ func InitDB(dbPath string) error {
 	if err != nil {
 		return err
 	}
+	err = initAccessStat()
+	if err != nil {
+		return err
+	}
+	err = initIpLimitPolicy()
+	if err != nil {
+		return err
+	}
+	err = initClientIpHistory()
+	if err != nil {
+		return err
+	}
+	err = initClientPlan()
+	if err != nil {
+		return err
+	}
+	err = initCustomer()
+	if err != nil {
+		return err
+	}
+	err = initTrafficReset()
+	if err != nil {
+		return err
+	}
+	err = initPolicyLevel()
+	if err != nil {
+		return err
+	}
+	err = initTgClient()
+	if err != nil {
+		return err
+	}
+	err = initPayment()
+	if err != nil {
+		return err
+	}
+	err = initNotifyRule()
+	if err != nil {
+		return err
+	}
+	err = initBroadcast()
+	if err != nil {
+		return err
+	}
 	err = initClientTraffic()
 	if err != nil {
 		return err
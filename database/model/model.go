@@ -2,6 +2,8 @@ package model
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"x-ui/util/json_util"
 	"x-ui/xray"
 )
@@ -50,6 +52,374 @@ type InboundClientIps struct {
 	Ips         string `json:"ips" form:"ips"`
 }
 
+type OutboundTraffics struct {
+	Id    int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Tag   string `json:"tag" form:"tag" gorm:"unique"`
+	Up    int64  `json:"up" form:"up" gorm:"default:0"`
+	Down  int64  `json:"down" form:"down" gorm:"default:0"`
+	Total int64  `json:"total" form:"total" gorm:"default:0"`
+}
+
+type GeoAsset struct {
+	Id          int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name        string `json:"name" form:"name" gorm:"unique"`
+	Type        string `json:"type" form:"type"`
+	Url         string `json:"url" form:"url"`
+	ChecksumUrl string `json:"checksumUrl" form:"checksumUrl"`
+	Enable      bool   `json:"enable" form:"enable"`
+	Version     string `json:"version" form:"version"`
+	Checksum    string `json:"checksum" form:"checksum"`
+	Size        int64  `json:"size" form:"size"`
+	LastUpdate  int64  `json:"lastUpdate" form:"lastUpdate"`
+	LastCheck   int64  `json:"lastCheck" form:"lastCheck"`
+	LastError   string `json:"lastError" form:"lastError"`
+}
+
+// ClientIpHistory records every address a client connected from
+type ClientIpHistory struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email     string `json:"email" gorm:"uniqueIndex:idx_client_ip_history"`
+	Ip        string `json:"ip" gorm:"uniqueIndex:idx_client_ip_history;index"`
+	FirstSeen int64  `json:"firstSeen"`
+	LastSeen  int64  `json:"lastSeen"`
+	Hits      int64  `json:"hits" gorm:"default:0"`
+	Country   string `json:"country"`
+	Asn       string `json:"asn"`
+}
+
+// IpLimitPolicy tunes LimitIP enforcement, a policy with an email applies to that client,
+// one with only an inbound id to the clients of the inbound and the one with neither is the default
+type IpLimitPolicy struct {
+	Id        int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId int    `json:"inboundId" form:"inboundId" gorm:"uniqueIndex:idx_ip_limit_policy"`
+	Email     string `json:"email" form:"email" gorm:"uniqueIndex:idx_ip_limit_policy"`
+	Window    int    `json:"window" form:"window"`
+	SubnetV4  int    `json:"subnetV4" form:"subnetV4"`
+	SubnetV6  int    `json:"subnetV6" form:"subnetV6"`
+	BanTime   int    `json:"banTime" form:"banTime"`
+	Notify    bool   `json:"notify" form:"notify"`
+}
+
+// IpLimitBan keeps a subnet off a client until Until, Ips are the addresses of the subnet seen when it was banned.
+// The ips go to the blocked ips file of xray, so they are blocked for every client, not only for Email.
+type IpLimitBan struct {
+	Id     int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Email  string `json:"email" form:"email" gorm:"uniqueIndex:idx_ip_limit_ban"`
+	Subnet string `json:"subnet" form:"subnet" gorm:"uniqueIndex:idx_ip_limit_ban"`
+	Ips    string `json:"ips" form:"ips"`
+	Until  int64  `json:"until" form:"until"`
+}
+
+// ClientPlan is a named template for clients, InboundIds is a comma separated list of the inbounds they are created on
+type ClientPlan struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name       string `json:"name" form:"name" gorm:"unique"`
+	TotalGB    int64  `json:"totalGB" form:"totalGB"`
+	Days       int    `json:"days" form:"days"`
+	LimitIP    int    `json:"limitIp" form:"limitIp"`
+	Flow       string `json:"flow" form:"flow"`
+	InboundIds string `json:"inboundIds" form:"inboundIds"`
+	Price      int64  `json:"price" form:"price"`
+	Enable     bool   `json:"enable" form:"enable"`
+}
+
+// Customer owns clients on several inbounds, they share its subscription, quota and expiry
+type Customer struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name       string `json:"name" form:"name" gorm:"unique"`
+	SubId      string `json:"subId" form:"subId" gorm:"unique"`
+	TgId       string `json:"tgId" form:"tgId"`
+	TotalGB    int64  `json:"totalGB" form:"totalGB"`
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
+	Enable     bool   `json:"enable" form:"enable"`
+	Remark     string `json:"remark" form:"remark"`
+	Up         int64  `json:"up" gorm:"-"`
+	Down       int64  `json:"down" gorm:"-"`
+}
+
+// TrafficResetPolicy resets the traffic of clients every period, a policy with an email applies to that client
+// and one with only an inbound id to the clients of the inbound. Period is daily, weekly, monthly or cron,
+// Anchor is creation to count periods from the start of the client or calendar to align them to the calendar
+type TrafficResetPolicy struct {
+	Id        int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId int    `json:"inboundId" form:"inboundId" gorm:"uniqueIndex:idx_traffic_reset_policy"`
+	Email     string `json:"email" form:"email" gorm:"uniqueIndex:idx_traffic_reset_policy"`
+	Period    string `json:"period" form:"period"`
+	Cron      string `json:"cron" form:"cron"`
+	Anchor    string `json:"anchor" form:"anchor"`
+	Enable    bool   `json:"enable" form:"enable"`
+}
+
+// TrafficPeriod records the usage of a client in one reset period
+type TrafficPeriod struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId int    `json:"inboundId"`
+	Email     string `json:"email" gorm:"index"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	Up        int64  `json:"up"`
+	Down      int64  `json:"down"`
+	Total     int64  `json:"total"`
+}
+
+// PolicyLevel is an xray connection policy that clients are assigned to with their level field, zero values keep
+// the xray defaults. It sets the connection buffer (BufferSize, KB) and timeouts (seconds), it is not a speed
+// limit because xray has no per-user rate limiter
+type PolicyLevel struct {
+	Id           int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Level        int    `json:"level" form:"level" gorm:"unique"`
+	Name         string `json:"name" form:"name"`
+	BufferSize   int    `json:"bufferSize" form:"bufferSize"`
+	Handshake    int    `json:"handshake" form:"handshake"`
+	ConnIdle     int    `json:"connIdle" form:"connIdle"`
+	UplinkOnly   int    `json:"uplinkOnly" form:"uplinkOnly"`
+	DownlinkOnly int    `json:"downlinkOnly" form:"downlinkOnly"`
+}
+
+// AccessStat aggregates access log records per client, inbound and destination in hourly buckets
+type AccessStat struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Hour        int64  `json:"hour" gorm:"uniqueIndex:idx_access_stat"`
+	Email       string `json:"email" gorm:"uniqueIndex:idx_access_stat"`
+	Inbound     string `json:"inbound" gorm:"uniqueIndex:idx_access_stat"`
+	Outbound    string `json:"outbound" gorm:"uniqueIndex:idx_access_stat"`
+	Destination string `json:"destination" gorm:"uniqueIndex:idx_access_stat"`
+	Port        int    `json:"port" gorm:"uniqueIndex:idx_access_stat"`
+	Accepted    int64  `json:"accepted" gorm:"default:0"`
+	Rejected    int64  `json:"rejected" gorm:"default:0"`
+}
+
+type MsgType string
+
+const (
+	Registration MsgType = "registration"
+	Renewal      MsgType = "renewal"
+)
+
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestRejected = "rejected"
+)
+
+// TgClient is a telegram user of the bot, the xray clients it owns are linked by their uuid or password.
+// ClientUid is not stored, it carries a uid to link when the panel registers or renews the client
+type TgClient struct {
+	ChatID       int64         `json:"chatId" form:"chatId" gorm:"primaryKey;autoIncrement:false"`
+	Enabled      bool          `json:"enabled" form:"enabled"`
+	Name         string        `json:"clientName" form:"clientName"`
+	Email        string        `json:"clientEmail" form:"clientEmail"`
+	Language     string        `json:"language" form:"language"`
+	Uids         []TgClientUid `json:"uids" gorm:"foreignKey:ChatID"`
+	TgClientMsgs []TgClientMsg `json:"-" gorm:"foreignKey:ChatID"`
+	ClientUid    string        `json:"clientUid" form:"clientUid" gorm:"-"`
+}
+
+type TgClientUid struct {
+	Id     int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	ChatID int64  `json:"chatId" gorm:"uniqueIndex:idx_tg_client_uid"`
+	Uid    string `json:"uid" gorm:"uniqueIndex:idx_tg_client_uid"`
+}
+
+// TgClientMsg is a registration or renewal request waiting for the approval of an admin, Uid is the account
+// to renew and PhotoId the telegram file of the receipt. The admin who reviewed it is kept in ReviewedBy and Reviewer
+type TgClientMsg struct {
+	Id         int64   `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	ChatID     int64   `json:"chatId" form:"chatId" gorm:"index"`
+	Type       MsgType `json:"type" form:"type"`
+	Msg        string  `json:"msg" form:"msg"`
+	PlanId     int     `json:"planId" form:"planId"`
+	Uid        string  `json:"uid" form:"uid"`
+	PhotoId    string  `json:"photoId"`
+	Status     string  `json:"status" gorm:"default:pending"`
+	ReviewedBy int64   `json:"reviewedBy"`
+	Reviewer   string  `json:"reviewer"`
+	ReviewedAt int64   `json:"reviewedAt"`
+	CreatedAt  int64   `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
+
+// TgAdminAction records a change an admin made to a client from the telegram bot, Error is set when it failed
+type TgAdminAction struct {
+	Id        int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	AdminId   int64  `json:"adminId" gorm:"index"`
+	Admin     string `json:"admin"`
+	Action    string `json:"action"`
+	Email     string `json:"email" gorm:"index"`
+	Value     string `json:"value"`
+	Error     string `json:"error"`
+	CreatedAt int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
+
+const (
+	ReferralJoined    = "joined"
+	ReferralConverted = "converted"
+)
+
+// ReferralCode is the code of the referral link of a telegram client, t.me/<bot>?start=ref_<code>
+type ReferralCode struct {
+	ChatID int64  `json:"chatId" gorm:"primaryKey;autoIncrement:false"`
+	Code   string `json:"code" gorm:"unique"`
+}
+
+// Referral is a chat that joined the bot with the referral link of ReferrerId. It is converted by its first
+// applied registration, RewardDays and RewardTraffic (GB) are what the client of the referrer got for it and
+// Error keeps why the reward could not be applied
+type Referral struct {
+	Id            int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	ReferrerId    int64  `json:"referrerId" gorm:"index"`
+	ChatID        int64  `json:"chatId" gorm:"unique"`
+	Status        string `json:"status" gorm:"default:joined"`
+	RewardDays    int    `json:"rewardDays"`
+	RewardTraffic int    `json:"rewardTraffic"`
+	Error         string `json:"error"`
+	CreatedAt     int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+	ConvertedAt   int64  `json:"convertedAt"`
+}
+
+const (
+	PaymentPending   = "pending"
+	PaymentPaid      = "paid"
+	PaymentFailed    = "failed"
+	PaymentCancelled = "cancelled"
+)
+
+// Payment is an entry of the payments ledger, an invoice of a provider for the plan of a registration or a
+// renewal. IdempotencyKey identifies the invoice at the provider so a payment is applied only once, Error keeps
+// why a paid payment could not create or renew its client
+type Payment struct {
+	Id             int64   `json:"id" gorm:"primaryKey;autoIncrement"`
+	IdempotencyKey string  `json:"idempotencyKey" gorm:"unique"`
+	Provider       string  `json:"provider"`
+	ExternalId     string  `json:"externalId"`
+	ChatID         int64   `json:"chatId" gorm:"index"`
+	Type           MsgType `json:"type"`
+	PlanId         int     `json:"planId"`
+	Uid            string  `json:"uid"`
+	Amount         int64   `json:"amount"`
+	Currency       string  `json:"currency"`
+	Status         string  `json:"status" gorm:"default:pending"`
+	Error          string  `json:"error"`
+	CreatedAt      int64   `json:"createdAt" gorm:"autoCreateTime:milli"`
+	UpdatedAt      int64   `json:"updatedAt" gorm:"autoUpdateTime:milli"`
+}
+
+type NotifyCondition string
+
+const (
+	NotifyTraffic   NotifyCondition = "traffic"
+	NotifyExpiry    NotifyCondition = "expiry"
+	NotifyCpu       NotifyCondition = "cpu"
+	NotifyMem       NotifyCondition = "mem"
+	NotifyDisk      NotifyCondition = "disk"
+	NotifyXrayDown  NotifyCondition = "xrayDown"
+	NotifyLoginFail NotifyCondition = "loginFail"
+)
+
+// NotifyRule sends Template to its targets when Condition reaches Threshold: the used percent of the quota of
+// a client, the days left before a client expires, the used percent of cpu, memory or disk, or the failed logins
+// of the last minutes. Targets is a comma separated list of admins, client (the tgId or the telegram client of
+// the client) and webhook. Cooldown is in minutes, an alert that still holds is sent again after it, 0 sends it
+// once until the condition clears
+type NotifyRule struct {
+	Id        int             `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string          `json:"name" form:"name"`
+	Enable    bool            `json:"enable" form:"enable"`
+	Condition NotifyCondition `json:"condition" form:"condition"`
+	Threshold float64         `json:"threshold" form:"threshold"`
+	Targets   string          `json:"targets" form:"targets"`
+	Webhook   string          `json:"webhook" form:"webhook"`
+	Template  string          `json:"template" form:"template"`
+	Cooldown  int             `json:"cooldown" form:"cooldown"`
+}
+
+func (r *NotifyRule) HasTarget(target string) bool {
+	for _, t := range strings.Split(r.Targets, ",") {
+		if strings.TrimSpace(t) == target {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifyState is an alert of a rule that was sent, Key is the client email or the name of the condition for
+// alerts about the server
+type NotifyState struct {
+	Id       int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	RuleId   int    `json:"ruleId" gorm:"uniqueIndex:idx_notify_state"`
+	Key      string `json:"key" gorm:"uniqueIndex:idx_notify_state"`
+	LastSent int64  `json:"lastSent"`
+}
+
+type BroadcastSegment string
+
+const (
+	BroadcastAll      BroadcastSegment = "all"
+	BroadcastActive   BroadcastSegment = "active"
+	BroadcastExpiring BroadcastSegment = "expiring"
+	BroadcastDepleted BroadcastSegment = "depleted"
+	BroadcastInbound  BroadcastSegment = "inbound"
+	BroadcastPlan     BroadcastSegment = "plan"
+)
+
+const (
+	BroadcastScheduled = "scheduled"
+	BroadcastSending   = "sending"
+	BroadcastDone      = "done"
+	BroadcastCancelled = "cancelled"
+)
+
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+)
+
+// Broadcast sends Msg to the telegram clients of Segment at SendAt (unix milli, 0 sends it at once). SegmentValue
+// is the days for expiring, the inbound id for inbound and the plan id for plan. MediaType is empty, photo, video or
+// document and Media is the url or the telegram file id of the attachment, Msg is its caption then. The recipients
+// are resolved when the broadcast starts, Total, Sent and Failed count their deliveries
+type Broadcast struct {
+	Id           int64            `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name         string           `json:"name" form:"name"`
+	Segment      BroadcastSegment `json:"segment" form:"segment"`
+	SegmentValue int              `json:"segmentValue" form:"segmentValue"`
+	Msg          string           `json:"msg" form:"msg"`
+	MediaType    string           `json:"mediaType" form:"mediaType"`
+	Media        string           `json:"media" form:"media"`
+	SendAt       int64            `json:"sendAt" form:"sendAt"`
+	Status       string           `json:"status" gorm:"default:scheduled"`
+	Total        int              `json:"total"`
+	Sent         int              `json:"sent"`
+	Failed       int              `json:"failed"`
+	CreatedAt    int64            `json:"createdAt" gorm:"autoCreateTime:milli"`
+	FinishedAt   int64            `json:"finishedAt"`
+}
+
+// BroadcastDelivery is a recipient of a broadcast, Uid is the account of the chat that matched the segment and
+// fills the placeholders of the message
+type BroadcastDelivery struct {
+	Id          int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	BroadcastId int64  `json:"broadcastId" gorm:"uniqueIndex:idx_broadcast_delivery"`
+	ChatID      int64  `json:"chatId" gorm:"uniqueIndex:idx_broadcast_delivery"`
+	Uid         string `json:"uid"`
+	Status      string `json:"status" gorm:"default:pending"`
+	Error       string `json:"error"`
+	SentAt      int64  `json:"sentAt"`
+}
+
+// TgSession is the stored conversation of a chat with the bot, Client and Request hold the json
+// of the registration in progress
+type TgSession struct {
+	ChatID         int64 `gorm:"primaryKey;autoIncrement:false"`
+	State          string
+	Lang           string
+	CanAcceptPhoto bool
+	PlanId         int
+	Client         string
+	Request        string
+	UpdatedAt      int64 `gorm:"autoUpdateTime:milli"`
+}
+
 func (i *Inbound) GenXrayInboundConfig() *xray.InboundConfig {
 	listen := i.Listen
 	if listen != "" {
@@ -66,6 +436,25 @@ func (i *Inbound) GenXrayInboundConfig() *xray.InboundConfig {
 	}
 }
 
+func (p *ClientPlan) GetInboundIds() []int {
+	ids := make([]int, 0)
+	for _, field := range strings.Split(p.InboundIds, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (c *TgClient) GetUids() []string {
+	uids := make([]string, 0, len(c.Uids))
+	for _, uid := range c.Uids {
+		uids = append(uids, uid.Uid)
+	}
+	return uids
+}
+
 type Setting struct {
 	Id    int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
 	Key   string `json:"key" form:"key"`
@@ -80,6 +469,9 @@ type Client struct {
 	Email      string `json:"email"`
 	LimitIP    int    `json:"limitIp"`
 	TotalGB    int64  `json:"totalGB" form:"totalGB"`
+	UpLimit    int64  `json:"upLimit" form:"upLimit"`
+	DownLimit  int64  `json:"downLimit" form:"downLimit"`
+	Level      int    `json:"level" form:"level"`
 	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
 	Enable     bool   `json:"enable" form:"enable"`
 	TgID       string `json:"tgId" form:"tgId"`
@@ -19,7 +19,7 @@ func InitLogger(level logging.Level) {
 	newLogger := logging.MustGetLogger("x-ui")
 	backend := logging.NewLogBackend(os.Stderr, "", 0)
 	backendFormatter := logging.NewBackendFormatter(backend, format)
-	backendLeveled := logging.AddModuleLevel(backendFormatter)
+	backendLeveled := logging.AddModuleLevel(logging.MultiLogger(backendFormatter, &storeBackend{}))
 	backendLeveled.SetLevel(level, "")
 	newLogger.SetBackend(backendLeveled)
 
@@ -0,0 +1,329 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	SourcePanel = "panel"
+	SourceXray  = "xray"
+)
+
+const (
+	memoryEntries = 1000
+	maxFileSize   = 5 * 1024 * 1024
+	maxFiles      = 5
+)
+
+var levelOrder = map[string]int{
+	"debug":   0,
+	"info":    1,
+	"warning": 2,
+	"error":   3,
+}
+
+type Entry struct {
+	Time    int64             `json:"time"`
+	Level   string            `json:"level"`
+	Source  string            `json:"source"`
+	Email   string            `json:"email,omitempty"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (e *Entry) String() string {
+	return fmt.Sprintf("%v %v [%v] %v", time.UnixMilli(e.Time).Format("2006/01/02 15:04:05"), strings.ToUpper(e.Level), e.Source, e.Message)
+}
+
+type Filter struct {
+	Level  string `json:"level" form:"level"`
+	Source string `json:"source" form:"source"`
+	Text   string `json:"text" form:"text"`
+	Email  string `json:"email" form:"email"`
+	From   int64  `json:"from" form:"from"`
+	To     int64  `json:"to" form:"to"`
+	Limit  int    `json:"limit" form:"limit"`
+}
+
+func (f *Filter) Match(e *Entry) bool {
+	if f.Level != "" && levelOrder[e.Level] < levelOrder[NormalizeLevel(f.Level)] {
+		return false
+	}
+	if f.Source != "" && f.Source != e.Source {
+		return false
+	}
+	if f.Email != "" && !strings.EqualFold(f.Email, e.Email) {
+		return false
+	}
+	if f.From > 0 && e.Time < f.From {
+		return false
+	}
+	if f.To > 0 && e.Time > f.To {
+		return false
+	}
+	if f.Text != "" && !strings.Contains(strings.ToLower(e.Message), strings.ToLower(f.Text)) {
+		return false
+	}
+	return true
+}
+
+// store keeps the latest entries in memory and all entries in a size bounded set of rotated files
+type store struct {
+	lock        sync.RWMutex
+	entries     []*Entry
+	next        int
+	folder      string
+	file        *os.File
+	size        int64
+	subscribers map[chan *Entry]struct{}
+}
+
+var logStore = &store{
+	entries:     make([]*Entry, 0, memoryEntries),
+	subscribers: make(map[chan *Entry]struct{}),
+}
+
+func NormalizeLevel(level string) string {
+	switch strings.ToLower(level) {
+	case "debug":
+		return "debug"
+	case "warn", "warning":
+		return "warning"
+	case "error", "critical":
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// InitLogStore enables persisting log entries into folder, it can be called again to move the folder
+func InitLogStore(folder string) error {
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		return err
+	}
+	logStore.lock.Lock()
+	defer logStore.lock.Unlock()
+
+	if logStore.file != nil {
+		logStore.file.Close()
+		logStore.file = nil
+	}
+	logStore.folder = folder
+	return logStore.openFile()
+}
+
+func (s *store) filePath(index int) string {
+	if index == 0 {
+		return filepath.Join(s.folder, "x-ui.log")
+	}
+	return filepath.Join(s.folder, fmt.Sprintf("x-ui.log.%d", index))
+}
+
+func (s *store) openFile() error {
+	file, err := os.OpenFile(s.filePath(0), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = stat.Size()
+	return nil
+}
+
+func (s *store) rotate() error {
+	s.file.Close()
+	s.file = nil
+	os.Remove(s.filePath(maxFiles))
+	for i := maxFiles - 1; i >= 0; i-- {
+		os.Rename(s.filePath(i), s.filePath(i+1))
+	}
+	return s.openFile()
+}
+
+func (s *store) add(entry *Entry) {
+	s.lock.Lock()
+	if len(s.entries) < memoryEntries {
+		s.entries = append(s.entries, entry)
+	} else {
+		s.entries[s.next] = entry
+		s.next = (s.next + 1) % memoryEntries
+	}
+
+	if s.file != nil {
+		data, err := json.Marshal(entry)
+		if err == nil {
+			data = append(data, '\n')
+			n, _ := s.file.Write(data)
+			s.size += int64(n)
+			if s.size >= maxFileSize {
+				err = s.rotate()
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "rotate log file failed:", err)
+				}
+			}
+		}
+	}
+
+	for ch := range s.subscribers {
+		// slow subscribers lose entries instead of blocking the logger
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+	s.lock.Unlock()
+}
+
+func (s *store) memoryEntries() []*Entry {
+	entries := make([]*Entry, 0, len(s.entries))
+	entries = append(entries, s.entries[s.next:]...)
+	entries = append(entries, s.entries[:s.next]...)
+	return entries
+}
+
+// search returns the newest entries matching filter, oldest first
+func (s *store) search(filter *Filter) ([]*Entry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	result := make([]*Entry, 0, limit)
+	collect := func(entry *Entry) {
+		if !filter.Match(entry) {
+			return
+		}
+		if len(result) == limit {
+			result = append(result[1:], entry)
+		} else {
+			result = append(result, entry)
+		}
+	}
+
+	files, entries, err := s.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		for _, entry := range entries {
+			collect(entry)
+		}
+		return result, nil
+	}
+
+	for i, file := range files {
+		// the open files outlive a rotation, reading stops at the size they had in the snapshot
+		scanner := bufio.NewScanner(io.LimitReader(file.File, file.size))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			entry := &Entry{}
+			if json.Unmarshal(scanner.Bytes(), entry) != nil {
+				continue
+			}
+			collect(entry)
+		}
+		err = scanner.Err()
+		if err != nil {
+			for _, file := range files[i:] {
+				file.Close()
+			}
+			return nil, err
+		}
+		file.Close()
+	}
+	return result, nil
+}
+
+type snapshotFile struct {
+	*os.File
+	size int64
+}
+
+// snapshot opens the log files oldest first under the lock so that search can scan them without blocking the
+// logger, without a folder it copies the memory entries instead
+func (s *store) snapshot() ([]*snapshotFile, []*Entry, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.folder == "" {
+		return nil, s.memoryEntries(), nil
+	}
+	files := make([]*snapshotFile, 0, maxFiles+1)
+	for i := maxFiles; i >= 0; i-- {
+		file, err := os.Open(s.filePath(i))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			var stat os.FileInfo
+			stat, err = file.Stat()
+			if err == nil {
+				files = append(files, &snapshotFile{File: file, size: stat.Size()})
+				continue
+			}
+			file.Close()
+		}
+		for _, file := range files {
+			file.Close()
+		}
+		return nil, nil, err
+	}
+	return files, nil, nil
+}
+
+func AddEntry(entry *Entry) {
+	if entry.Time == 0 {
+		entry.Time = time.Now().UnixMilli()
+	}
+	entry.Level = NormalizeLevel(entry.Level)
+	logStore.add(entry)
+}
+
+func Search(filter *Filter) ([]*Entry, error) {
+	return logStore.search(filter)
+}
+
+// Subscribe streams new entries until the returned cancel function is called
+func Subscribe() (<-chan *Entry, func()) {
+	ch := make(chan *Entry, 100)
+	logStore.lock.Lock()
+	logStore.subscribers[ch] = struct{}{}
+	logStore.lock.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			logStore.lock.Lock()
+			delete(logStore.subscribers, ch)
+			logStore.lock.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// storeBackend feeds panel logs into the store next to the console output
+type storeBackend struct{}
+
+func (b *storeBackend) Log(level logging.Level, calldepth int, record *logging.Record) error {
+	AddEntry(&Entry{
+		Time:    record.Time.UnixMilli(),
+		Level:   level.String(),
+		Source:  SourcePanel,
+		Message: record.Message(),
+	})
+	return nil
+}
@@ -35,7 +35,12 @@ func runWebServer() {
 		log.Fatal("unknown log level:", config.GetLogLevel())
 	}
 
-	err := database.InitDB(config.GetDBPath())
+	err := logger.InitLogStore(config.GetLogFolderPath())
+	if err != nil {
+		log.Println("init log store failed, logs are kept in memory only:", err)
+	}
+
+	err = database.InitDB(config.GetDBPath())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// NormalizeIP returns the canonical text of an IPv4 or IPv6 address, IPv4-mapped IPv6 addresses become IPv4,
+// brackets and zones are dropped, an empty string is returned for invalid input
+func NormalizeIP(ip string) string {
+	addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+	if err != nil {
+		return ""
+	}
+	return addr.Unmap().WithZone("").String()
+}
+
+func IsLoopbackIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// SortIPs orders addresses numerically with IPv4 before IPv6, invalid entries go last in text order
+func SortIPs(ips []string) {
+	sort.SliceStable(ips, func(i, j int) bool {
+		a, errA := netip.ParseAddr(ips[i])
+		b, errB := netip.ParseAddr(ips[j])
+		if errA != nil || errB != nil {
+			if errA == nil {
+				return true
+			}
+			if errB == nil {
+				return false
+			}
+			return ips[i] < ips[j]
+		}
+		return a.Unmap().Less(b.Unmap())
+	})
+}
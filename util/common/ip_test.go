@@ -0,0 +1,61 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"1.2.3.4", "1.2.3.4"},
+		{"2001:DB8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"::ffff:1.2.3.4", "1.2.3.4"},
+		{"[::ffff:1.2.3.4]", "1.2.3.4"},
+		{"::1", "::1"},
+		{"", ""},
+		{"example.com", ""},
+		{"1.2.3.4:443", ""},
+	}
+	for _, test := range tests {
+		if got := NormalizeIP(test.ip); got != test.want {
+			t.Errorf("NormalizeIP(%q) = %q, want %q", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestSortIPs(t *testing.T) {
+	tests := []struct {
+		ips  []string
+		want []string
+	}{
+		{
+			[]string{"10.0.0.2", "9.0.0.1", "10.0.0.10"},
+			[]string{"9.0.0.1", "10.0.0.2", "10.0.0.10"},
+		},
+		{
+			[]string{"2001:db8::2", "1.2.3.4", "2001:db8::10", "::1"},
+			[]string{"1.2.3.4", "::1", "2001:db8::2", "2001:db8::10"},
+		},
+		{
+			[]string{"::ffff:10.0.0.3", "10.0.0.1", "10.0.0.2"},
+			[]string{"10.0.0.1", "10.0.0.2", "::ffff:10.0.0.3"},
+		},
+		{
+			[]string{"b", "2001:db8::1", "a", "1.1.1.1"},
+			[]string{"1.1.1.1", "2001:db8::1", "a", "b"},
+		},
+	}
+	for _, test := range tests {
+		ips := append([]string(nil), test.ips...)
+		SortIPs(ips)
+		if !reflect.DeepEqual(ips, test.want) {
+			t.Errorf("SortIPs(%v) = %v, want %v", test.ips, ips, test.want)
+		}
+	}
+}
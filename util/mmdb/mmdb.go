@@ -0,0 +1,289 @@
+// Package mmdb reads MaxMind DB files such as GeoLite2-ASN.mmdb without external dependencies.
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const dataSectionSeparator = 16
+
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBoolean  = 14
+	typeFloat    = 15
+)
+
+var errInvalid = errors.New("invalid mmdb file")
+
+type Reader struct {
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint
+}
+
+func Open(path string) (*Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(data)
+}
+
+func FromBytes(data []byte) (*Reader, error) {
+	index := bytes.LastIndex(data, metadataMarker)
+	if index < 0 {
+		return nil, errInvalid
+	}
+	r := &Reader{data: data}
+	metadata, _, err := r.decode(data[index+len(metadataMarker):], 0)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := metadata.(map[string]interface{})
+	if !ok {
+		return nil, errInvalid
+	}
+	r.nodeCount = toUint(meta["node_count"])
+	r.recordSize = toUint(meta["record_size"])
+	r.ipVersion = toUint(meta["ip_version"])
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, errInvalid
+	}
+	r.dataStart = r.nodeCount*r.recordSize/4 + dataSectionSeparator
+	if r.dataStart > uint(index) {
+		return nil, errInvalid
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 trees
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func toUint(v interface{}) uint {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n)
+	case uint32:
+		return uint(n)
+	case uint16:
+		return uint(n)
+	}
+	return 0
+}
+
+func (r *Reader) readRecord(node uint, bit uint) uint {
+	base := node * r.recordSize / 4
+	b := r.data[base : base+r.recordSize/4]
+	switch r.recordSize {
+	case 24:
+		off := bit * 3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		if bit == 0 {
+			return (uint(b[3])&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return (uint(b[3])&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := bit * 4
+		return uint(binary.BigEndian.Uint32(b[off : off+4]))
+	}
+}
+
+// Lookup returns the decoded record of ip, nil when the database has none
+func (r *Reader) Lookup(ip string) (interface{}, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	addr = addr.Unmap()
+
+	node := uint(0)
+	var ipBytes []byte
+	if addr.Is4() {
+		b := addr.As4()
+		ipBytes = b[:]
+		node = r.ipv4Start
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		b := addr.As16()
+		ipBytes = b[:]
+	}
+
+	for i := 0; i < len(ipBytes)*8 && node < r.nodeCount; i++ {
+		bit := uint(ipBytes[i/8]>>(7-uint(i%8))) & 1
+		node = r.readRecord(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errInvalid
+	}
+	value, _, err := r.decode(r.dataSection(), node-r.nodeCount-dataSectionSeparator)
+	return value, err
+}
+
+func (r *Reader) dataSection() []byte {
+	return r.data[r.dataStart:]
+}
+
+// decode reads one value of section at offset and returns it with the offset after it
+func (r *Reader) decode(section []byte, offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(section)) {
+		return nil, 0, errInvalid
+	}
+	ctrl := section[offset]
+	offset++
+	dataType := uint(ctrl >> 5)
+
+	if dataType == typePointer {
+		size := uint(ctrl>>3) & 0x3
+		if offset+size+1 > uint(len(section)) {
+			return nil, 0, errInvalid
+		}
+		var pointer uint
+		vvv := uint(ctrl & 0x7)
+		b := section[offset : offset+size+1]
+		switch size {
+		case 0:
+			pointer = vvv<<8 | uint(b[0])
+		case 1:
+			pointer = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			pointer = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			pointer = uint(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := r.decode(r.dataSection(), pointer)
+		return value, offset + size + 1, err
+	}
+
+	if dataType == typeExtended {
+		if offset >= uint(len(section)) {
+			return nil, 0, errInvalid
+		}
+		dataType = 7 + uint(section[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(section)) {
+			return nil, 0, errInvalid
+		}
+		b := section[offset : offset+n]
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + uint(b[0])
+		case 2:
+			size = 285 + (uint(b[0])<<8 | uint(b[1]))
+		default:
+			size = 65821 + (uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]))
+		}
+	}
+
+	switch dataType {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := r.decode(section, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := r.decode(section, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, 0, errInvalid
+			}
+			m[keyStr] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := r.decode(section, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBoolean:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(section)) {
+		return nil, 0, errInvalid
+	}
+	b := section[offset : offset+size]
+	offset += size
+	switch dataType {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errInvalid
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errInvalid
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		switch dataType {
+		case typeUint16:
+			return uint16(n), offset, nil
+		case typeUint32:
+			return uint32(n), offset, nil
+		case typeInt32:
+			return int32(uint32(n)), offset, nil
+		}
+		return n, offset, nil
+	case typeUint128:
+		return append([]byte(nil), b...), offset, nil
+	}
+	return nil, 0, errInvalid
+}
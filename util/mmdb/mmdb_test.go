@@ -0,0 +1,77 @@
+package mmdb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the fixtures are written by testdata/gen_fixture.go
+func TestLookup(t *testing.T) {
+	first := map[string]interface{}{
+		"autonomous_system_number":       uint32(13335),
+		"autonomous_system_organization": "Example Org",
+		"uint16":                         uint16(443),
+		"uint64":                         uint64(1 << 40),
+		"uint128":                        []byte(strings.Repeat("\xff", 16)),
+		"int32":                          int32(-5),
+		"bool":                           true,
+		"double":                         0.5,
+		"float":                          float32(1.5),
+		"bytes":                          []byte{1, 2, 3},
+		"array":                          []interface{}{uint16(1), "two"},
+	}
+	second := map[string]interface{}{
+		"autonomous_system_number":       uint32(64512),
+		"autonomous_system_organization": "Far Org",
+		"nested":                         map[string]interface{}{"name": strings.Repeat("n", 40)},
+	}
+	tests := []struct {
+		ip   string
+		want interface{}
+	}{
+		{"1.2.3.4", first},
+		{"::ffff:1.2.3.255", first},
+		{"3.3.3.3", second},
+		{"2001:db8::1", second},
+		{"2001:db8:ffff::1", second},
+		{"1.2.4.1", nil},
+		{"3.3.3.4", nil},
+		{"2001:db9::1", nil},
+	}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		reader, err := Open(fmt.Sprintf("testdata/test-%d.mmdb", recordSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			record, err := reader.Lookup(test.ip)
+			if err != nil {
+				t.Errorf("record size %v: Lookup(%v) failed: %v", recordSize, test.ip, err)
+				continue
+			}
+			if !reflect.DeepEqual(record, test.want) {
+				t.Errorf("record size %v: Lookup(%v) = %#v, want %#v", recordSize, test.ip, record, test.want)
+			}
+		}
+		_, err = reader.Lookup("not an ip")
+		if err == nil {
+			t.Errorf("record size %v: Lookup of an invalid ip succeeded", recordSize)
+		}
+	}
+}
+
+func TestFromBytesInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("not a database"),
+		[]byte("\xab\xcd\xefMaxMind.com\xe0"),
+	} {
+		_, err := FromBytes(data)
+		if err == nil {
+			t.Errorf("FromBytes(%q) succeeded", data)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+//go:build ignore
+
+// gen_fixture writes the mmdb fixtures of the tests, run it from util/mmdb with go run testdata/gen_fixture.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+const (
+	typePointer = 1
+	typeString  = 2
+	typeDouble  = 3
+	typeBytes   = 4
+	typeUint16  = 5
+	typeUint32  = 6
+	typeMap     = 7
+	typeInt32   = 8
+	typeUint64  = 9
+	typeUint128 = 10
+	typeArray   = 11
+	typeBoolean = 14
+	typeFloat   = 15
+)
+
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) ctrl(dataType int, size int) {
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		sizeBytes = []byte{byte(size - 29)}
+		size = 29
+	case size < 65821:
+		size -= 285
+		sizeBytes = []byte{byte(size >> 8), byte(size)}
+		size = 30
+	default:
+		size -= 65821
+		sizeBytes = []byte{byte(size >> 16), byte(size >> 8), byte(size)}
+		size = 31
+	}
+	if dataType > 7 {
+		e.WriteByte(byte(size))
+		e.WriteByte(byte(dataType - 7))
+	} else {
+		e.WriteByte(byte(dataType<<5 | size))
+	}
+	e.Write(sizeBytes)
+}
+
+func (e *encoder) pointer(offset int) {
+	switch {
+	case offset < 2048:
+		e.WriteByte(byte(typePointer<<5 | offset>>8))
+		e.WriteByte(byte(offset))
+	case offset < 526336:
+		offset -= 2048
+		e.WriteByte(byte(typePointer<<5 | 1<<3 | offset>>16))
+		e.WriteByte(byte(offset >> 8))
+		e.WriteByte(byte(offset))
+	default:
+		e.WriteByte(byte(typePointer<<5 | 3<<3))
+		binary.Write(e, binary.BigEndian, uint32(offset))
+	}
+}
+
+func (e *encoder) str(s string) {
+	e.ctrl(typeString, len(s))
+	e.WriteString(s)
+}
+
+func (e *encoder) uint(dataType int, n uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	b = bytes.TrimLeft(b, "\x00")
+	e.ctrl(dataType, len(b))
+	e.Write(b)
+}
+
+// node is a search tree node, a leaf has data set to the offset of its record plus one
+type node struct {
+	children [2]*node
+	data     int
+}
+
+func insert(root *node, prefix netip.Prefix, data int) {
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	if addr.Is4() {
+		// IPv4 addresses live under ::/96, not under the mapped ::ffff:0:0/96
+		v4 := addr.As4()
+		addr = netip.AddrFrom16([16]byte{12: v4[0], 13: v4[1], 14: v4[2], 15: v4[3]})
+		bits += 96
+	}
+	ip := addr.As16()
+	n := root
+	for i := 0; i < bits; i++ {
+		bit := ip[i/8] >> (7 - i%8) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &node{}
+		}
+		n = n.children[bit]
+	}
+	n.data = data + 1
+}
+
+func write(path string, recordSize int, root *node, data []byte) {
+	// number the inner nodes breadth first, the root is node 0
+	nodes := []*node{root}
+	index := map[*node]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, child := range nodes[i].children {
+			if child != nil && child.data == 0 {
+				index[child] = len(nodes)
+				nodes = append(nodes, child)
+			}
+		}
+	}
+	nodeCount := len(nodes)
+	record := func(child *node) uint32 {
+		switch {
+		case child == nil:
+			return uint32(nodeCount)
+		case child.data > 0:
+			return uint32(nodeCount + 16 + child.data - 1)
+		}
+		return uint32(index[child])
+	}
+
+	var out bytes.Buffer
+	for _, n := range nodes {
+		left, right := record(n.children[0]), record(n.children[1])
+		switch recordSize {
+		case 24:
+			out.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left)})
+			out.Write([]byte{byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			out.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left)})
+			out.WriteByte(byte(left>>24)<<4 | byte(right>>24)&0x0f)
+			out.Write([]byte{byte(right >> 16), byte(right >> 8), byte(right)})
+		default:
+			binary.Write(&out, binary.BigEndian, left)
+			binary.Write(&out, binary.BigEndian, right)
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data)
+
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	meta := &encoder{}
+	meta.ctrl(typeMap, 6)
+	meta.str("node_count")
+	meta.uint(typeUint32, uint64(nodeCount))
+	meta.str("record_size")
+	meta.uint(typeUint16, uint64(recordSize))
+	meta.str("ip_version")
+	meta.uint(typeUint16, 6)
+	meta.str("database_type")
+	meta.str("x-ui-test")
+	meta.str("binary_format_major_version")
+	meta.uint(typeUint16, 2)
+	meta.str("languages")
+	meta.ctrl(typeArray, 1)
+	meta.str("en")
+	out.Write(meta.Bytes())
+
+	err := os.WriteFile(path, out.Bytes(), 0644)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	data := &encoder{}
+
+	// strings shared through pointers, the second one is past 2048 so it needs a two byte pointer
+	orgOffset := data.Len()
+	data.str("Example Org")
+	data.str(strings.Repeat("x", 2100))
+	farOrgOffset := data.Len()
+	data.str("Far Org")
+	asnKeyOffset := data.Len()
+	data.str("autonomous_system_number")
+
+	first := data.Len()
+	data.ctrl(typeMap, 11)
+	data.str("autonomous_system_number")
+	data.uint(typeUint32, 13335)
+	data.str("autonomous_system_organization")
+	data.pointer(orgOffset)
+	data.str("uint16")
+	data.uint(typeUint16, 443)
+	data.str("uint64")
+	data.uint(typeUint64, 1<<40)
+	data.str("uint128")
+	data.ctrl(typeUint128, 16)
+	data.Write(bytes.Repeat([]byte{0xff}, 16))
+	data.str("int32")
+	data.ctrl(typeInt32, 4)
+	binary.Write(data, binary.BigEndian, int32(-5))
+	data.str("bool")
+	data.ctrl(typeBoolean, 1)
+	data.str("double")
+	data.ctrl(typeDouble, 8)
+	binary.Write(data, binary.BigEndian, math.Float64bits(0.5))
+	data.str("float")
+	data.ctrl(typeFloat, 4)
+	binary.Write(data, binary.BigEndian, math.Float32bits(1.5))
+	data.str("bytes")
+	data.ctrl(typeBytes, 3)
+	data.Write([]byte{1, 2, 3})
+	data.str("array")
+	data.ctrl(typeArray, 2)
+	data.uint(typeUint16, 1)
+	data.str("two")
+
+	second := data.Len()
+	data.ctrl(typeMap, 3)
+	data.pointer(asnKeyOffset)
+	data.uint(typeUint32, 64512)
+	data.str("autonomous_system_organization")
+	data.pointer(farOrgOffset)
+	data.str("nested")
+	data.ctrl(typeMap, 1)
+	data.str("name")
+	data.str(strings.Repeat("n", 40))
+
+	root := &node{}
+	insert(root, netip.MustParsePrefix("1.2.3.0/24"), first)
+	insert(root, netip.MustParsePrefix("3.3.3.3/32"), second)
+	insert(root, netip.MustParsePrefix("2001:db8::/32"), second)
+	for _, recordSize := range []int{24, 28, 32} {
+		write(fmt.Sprintf("testdata/test-%d.mmdb", recordSize), recordSize, root, data.Bytes())
+	}
+}
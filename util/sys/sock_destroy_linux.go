@@ -0,0 +1,198 @@
+//go:build linux
+// +build linux
+
+package sys
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	sockDiagByFamily = 20
+	sockDestroy      = 21
+	tcpEstablished   = 1
+)
+
+type inetDiagSockId struct {
+	SPort  [2]byte
+	DPort  [2]byte
+	Src    [16]byte
+	Dst    [16]byte
+	If     uint32
+	Cookie [2]uint32
+}
+
+type inetDiagReqV2 struct {
+	Family   uint8
+	Protocol uint8
+	Ext      uint8
+	Pad      uint8
+	States   uint32
+	Id       inetDiagSockId
+}
+
+type inetDiagMsg struct {
+	Family  uint8
+	State   uint8
+	Timer   uint8
+	Retrans uint8
+	Id      inetDiagSockId
+	Expires uint32
+	RQueue  uint32
+	WQueue  uint32
+	UID     uint32
+	Inode   uint32
+}
+
+const sizeofInetDiagReqV2 = int(unsafe.Sizeof(inetDiagReqV2{}))
+const sizeofInetDiagMsg = int(unsafe.Sizeof(inetDiagMsg{}))
+
+type diagSocket struct {
+	fd  int
+	seq uint32
+}
+
+func newDiagSocket() (*diagSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &diagSocket{fd: fd}, nil
+}
+
+func (s *diagSocket) close() {
+	syscall.Close(s.fd)
+}
+
+func (s *diagSocket) send(msgType uint16, flags uint16, req *inetDiagReqV2) error {
+	s.seq++
+	buf := make([]byte, syscall.NLMSG_HDRLEN+sizeofInetDiagReqV2)
+	hdr := (*syscall.NlMsghdr)(unsafe.Pointer(&buf[0]))
+	hdr.Len = uint32(len(buf))
+	hdr.Type = msgType
+	hdr.Flags = flags
+	hdr.Seq = s.seq
+	copy(buf[syscall.NLMSG_HDRLEN:], (*(*[1 << 10]byte)(unsafe.Pointer(req)))[:sizeofInetDiagReqV2])
+	return syscall.Sendto(s.fd, buf, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+// receive reads replies of the last request until it is done, handle is called for every data message
+func (s *diagSocket) receive(handle func(data []byte)) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, _, err := syscall.Recvfrom(s.fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if msg.Header.Seq != s.seq {
+				continue
+			}
+			switch msg.Header.Type {
+			case syscall.NLMSG_DONE:
+				return nil
+			case syscall.NLMSG_ERROR:
+				// nlmsgerr starts with the negative errno in host byte order
+				errno := *(*int32)(unsafe.Pointer(&msg.Data[0]))
+				if errno != 0 {
+					return syscall.Errno(-errno)
+				}
+				return nil
+			default:
+				handle(msg.Data)
+			}
+		}
+	}
+}
+
+func remoteIP(msg *inetDiagMsg) net.IP {
+	if msg.Family == syscall.AF_INET {
+		return net.IP(msg.Id.Dst[:4])
+	}
+	return net.IP(msg.Id.Dst[:])
+}
+
+// DestroySockets closes the established tcp connections from remoteIps to localPorts with netlink SOCK_DESTROY,
+// it needs CAP_NET_ADMIN and a kernel built with CONFIG_INET_DIAG_DESTROY
+func DestroySockets(localPorts []int, remoteIps []string) (int, error) {
+	ports := make(map[uint16]bool, len(localPorts))
+	for _, port := range localPorts {
+		ports[uint16(port)] = true
+	}
+	ips := make(map[string]bool, len(remoteIps))
+	for _, ip := range remoteIps {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ips[parsed.String()] = true
+		}
+	}
+	if len(ports) == 0 || len(ips) == 0 {
+		return 0, nil
+	}
+
+	s, err := newDiagSocket()
+	if err != nil {
+		return 0, err
+	}
+	defer s.close()
+
+	targets := make([]inetDiagMsg, 0)
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		req := &inetDiagReqV2{
+			Family:   family,
+			Protocol: syscall.IPPROTO_TCP,
+			States:   1 << tcpEstablished,
+		}
+		err = s.send(sockDiagByFamily, syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP, req)
+		if err != nil {
+			return 0, err
+		}
+		err = s.receive(func(data []byte) {
+			if len(data) < sizeofInetDiagMsg {
+				return
+			}
+			msg := *(*inetDiagMsg)(unsafe.Pointer(&data[0]))
+			// IPv4 clients of dual stack listeners show up as IPv4-mapped IPv6 addresses, String() unifies them
+			if ports[binary.BigEndian.Uint16(msg.Id.SPort[:])] && ips[remoteIP(&msg).String()] {
+				targets = append(targets, msg)
+			}
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	destroyed := 0
+	for _, target := range targets {
+		req := &inetDiagReqV2{
+			Family:   target.Family,
+			Protocol: syscall.IPPROTO_TCP,
+			States:   ^uint32(0),
+			Id:       target.Id,
+		}
+		err = s.send(sockDestroy, syscall.NLM_F_REQUEST|syscall.NLM_F_ACK, req)
+		if err == nil {
+			err = s.receive(func([]byte) {})
+		}
+		if err == syscall.ENOENT {
+			// already closed
+			continue
+		}
+		if err != nil {
+			return destroyed, err
+		}
+		destroyed++
+	}
+	return destroyed, nil
+}
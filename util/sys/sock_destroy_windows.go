@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package sys
+
+import "errors"
+
+func DestroySockets(localPorts []int, remoteIps []string) (int, error) {
+	return 0, errors.New("destroying sockets is not supported on windows")
+}
@@ -180,11 +180,23 @@ class AllSetting {
         this.tgBotChatId = "";
         this.tgRunTime = "@daily";
         this.tgBotBackup = false;
-        this.tgCpu = "";
         this.xrayTemplateConfig = "";
         this.secretEnable = false;
 
         this.timeLocation = "Asia/Tehran";
+        this.geoUpdateRuntime = "@daily";
+        this.xrayMirrorUrl = "https://github.com/mhsanaei/Xray-core/releases/download";
+        this.xrayKeepVersions = 3;
+        this.ipLimitWebhook = "";
+        this.subURI = "";
+        this.paymentCurrency = "USD";
+        this.paymentPriceScale = 100;
+        this.tgPaymentToken = "";
+        this.invoiceApiUrl = "";
+        this.invoiceApiKey = "";
+        this.invoiceWebhookSecret = "";
+        this.tgReferralRewardDays = 0;
+        this.tgReferralRewardTraffic = 0;
 
         if (data == null) {
             return
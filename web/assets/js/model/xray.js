@@ -1708,7 +1708,7 @@ Inbound.VmessSettings = class extends Inbound.Settings {
     }
 };
 Inbound.VmessSettings.Vmess = class extends XrayCommonClass {
-    constructor(id=RandomUtil.randomUUID(), alterId=0, email=RandomUtil.randomText(),limitIp=0, totalGB=0, expiryTime=0, enable=true, tgId='', subId='') {
+    constructor(id=RandomUtil.randomUUID(), alterId=0, email=RandomUtil.randomText(),limitIp=0, totalGB=0, expiryTime=0, enable=true, tgId='', subId='', level=0, upLimit=0, downLimit=0) {
         super();
         this.id = id;
         this.alterId = alterId;
@@ -1719,6 +1719,9 @@ Inbound.VmessSettings.Vmess = class extends XrayCommonClass {
         this.enable = enable;
         this.tgId = tgId;
         this.subId = subId;
+        this.level = level;
+        this.upLimit = upLimit;
+        this.downLimit = downLimit;
     }
 
     static fromJson(json={}) {
@@ -1732,6 +1735,9 @@ Inbound.VmessSettings.Vmess = class extends XrayCommonClass {
             json.enable,
             json.tgId,
             json.subId,
+            json.level,
+            json.upLimit,
+            json.downLimit,
         );
     }
     get _expiryTime() {
@@ -1759,6 +1765,22 @@ Inbound.VmessSettings.Vmess = class extends XrayCommonClass {
         this.totalGB = toFixed(gb * ONE_GB, 0);
     }
 
+    get _upLimitGB() {
+        return toFixed(this.upLimit / ONE_GB, 2);
+    }
+
+    set _upLimitGB(gb) {
+        this.upLimit = toFixed(gb * ONE_GB, 0);
+    }
+
+    get _downLimitGB() {
+        return toFixed(this.downLimit / ONE_GB, 2);
+    }
+
+    set _downLimitGB(gb) {
+        this.downLimit = toFixed(gb * ONE_GB, 0);
+    }
+
 };
 
 Inbound.VLESSSettings = class extends Inbound.Settings {
@@ -1800,7 +1822,7 @@ Inbound.VLESSSettings = class extends Inbound.Settings {
 
 };
 Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
-    constructor(id=RandomUtil.randomUUID(), flow='', email=RandomUtil.randomText(),limitIp=0, totalGB=0, expiryTime=0, enable=true, tgId='', subId='') {
+    constructor(id=RandomUtil.randomUUID(), flow='', email=RandomUtil.randomText(),limitIp=0, totalGB=0, expiryTime=0, enable=true, tgId='', subId='', level=0, upLimit=0, downLimit=0) {
         super();
         this.id = id;
         this.flow = flow;
@@ -1811,6 +1833,9 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
         this.enable = enable;
         this.tgId = tgId;
         this.subId = subId;
+        this.level = level;
+        this.upLimit = upLimit;
+        this.downLimit = downLimit;
     }
 
     static fromJson(json={}) {
@@ -1824,6 +1849,9 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
             json.enable,
             json.tgId,
             json.subId,
+            json.level,
+            json.upLimit,
+            json.downLimit,
         );
       }
 
@@ -1851,6 +1879,22 @@ Inbound.VLESSSettings.VLESS = class extends XrayCommonClass {
     set _totalGB(gb) {
         this.totalGB = toFixed(gb * ONE_GB, 0);
     }
+
+    get _upLimitGB() {
+        return toFixed(this.upLimit / ONE_GB, 2);
+    }
+
+    set _upLimitGB(gb) {
+        this.upLimit = toFixed(gb * ONE_GB, 0);
+    }
+
+    get _downLimitGB() {
+        return toFixed(this.downLimit / ONE_GB, 2);
+    }
+
+    set _downLimitGB(gb) {
+        this.downLimit = toFixed(gb * ONE_GB, 0);
+    }
 };
 Inbound.VLESSSettings.Fallback = class extends XrayCommonClass {
     constructor(name="", alpn='', path='', dest='', xver=0) {
@@ -1923,7 +1967,7 @@ Inbound.TrojanSettings = class extends Inbound.Settings {
     }
 };
 Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
-    constructor(password=RandomUtil.randomSeq(10), flow='', email=RandomUtil.randomText(),limitIp=0, totalGB=0, expiryTime=0, enable=true, tgId='', subId='') {
+    constructor(password=RandomUtil.randomSeq(10), flow='', email=RandomUtil.randomText(),limitIp=0, totalGB=0, expiryTime=0, enable=true, tgId='', subId='', level=0, upLimit=0, downLimit=0) {
         super();
         this.password = password;
         this.flow = flow;
@@ -1934,6 +1978,9 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         this.enable = enable;
         this.tgId = tgId;
         this.subId = subId;
+        this.level = level;
+        this.upLimit = upLimit;
+        this.downLimit = downLimit;
     }
 
     toJson() {
@@ -1947,6 +1994,9 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             enable: this.enable,
             tgId: this.tgId,
             subId: this.subId,
+            level: this.level,
+            upLimit: this.upLimit,
+            downLimit: this.downLimit,
         };
     }
 
@@ -1961,6 +2011,9 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
             json.enable,
             json.tgId,
             json.subId,
+            json.level,
+            json.upLimit,
+            json.downLimit,
         );
     }
 
@@ -1989,6 +2042,22 @@ Inbound.TrojanSettings.Trojan = class extends XrayCommonClass {
         this.totalGB = toFixed(gb * ONE_GB, 0);
     }
 
+    get _upLimitGB() {
+        return toFixed(this.upLimit / ONE_GB, 2);
+    }
+
+    set _upLimitGB(gb) {
+        this.upLimit = toFixed(gb * ONE_GB, 0);
+    }
+
+    get _downLimitGB() {
+        return toFixed(this.downLimit / ONE_GB, 2);
+    }
+
+    set _downLimitGB(gb) {
+        this.downLimit = toFixed(gb * ONE_GB, 0);
+    }
+
 };
 
 Inbound.TrojanSettings.Fallback = class extends XrayCommonClass {
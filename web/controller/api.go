@@ -25,6 +25,41 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	g.POST("/update/:id", a.updateInbound)
 	g.POST("/clientIps/:email", a.getClientIps)
 	g.POST("/clearClientIps/:email", a.clearClientIps)
+	g.POST("/clientIpHistory/:email", a.getClientIpHistory)
+	g.POST("/ipHistory", a.getIpHistory)
+	g.POST("/sharedIps", a.getSharedIps)
+	g.POST("/destinations", a.getDestinations)
+	g.POST("/ipLimitViolations", a.getIpLimitViolations)
+	g.POST("/ipLimitPolicies", a.getIpLimitPolicies)
+	g.POST("/addIpLimitPolicy", a.addIpLimitPolicy)
+	g.POST("/updateIpLimitPolicy/:id", a.updateIpLimitPolicy)
+	g.POST("/delIpLimitPolicy/:id", a.delIpLimitPolicy)
+	g.POST("/ipLimitBans", a.getIpLimitBans)
+	g.POST("/delIpLimitBan", a.delIpLimitBan)
+	g.POST("/plans", a.getPlans)
+	g.POST("/addPlan", a.addPlan)
+	g.POST("/updatePlan/:id", a.updatePlan)
+	g.POST("/delPlan/:id", a.delPlan)
+	g.POST("/addClientFromPlan", a.addClientFromPlan)
+	g.POST("/renewClientFromPlan", a.renewClientFromPlan)
+	g.POST("/customers", a.getCustomers)
+	g.POST("/addCustomer", a.addCustomer)
+	g.POST("/updateCustomer/:id", a.updateCustomer)
+	g.POST("/delCustomer/:id", a.delCustomer)
+	g.POST("/customerClients/:id", a.getCustomerClients)
+	g.POST("/addCustomerClients/:id", a.addCustomerClients)
+	g.POST("/attachCustomerClients/:id", a.attachCustomerClients)
+	g.POST("/detachCustomerClients", a.detachCustomerClients)
+	g.POST("/resetCustomerTraffic/:id", a.resetCustomerTraffic)
+	g.POST("/trafficResetPolicies", a.getTrafficResetPolicies)
+	g.POST("/addTrafficResetPolicy", a.addTrafficResetPolicy)
+	g.POST("/updateTrafficResetPolicy/:id", a.updateTrafficResetPolicy)
+	g.POST("/delTrafficResetPolicy/:id", a.delTrafficResetPolicy)
+	g.POST("/trafficPeriods/:email", a.getTrafficPeriods)
+	g.POST("/policyLevels", a.getPolicyLevels)
+	g.POST("/addPolicyLevel", a.addPolicyLevel)
+	g.POST("/updatePolicyLevel/:id", a.updatePolicyLevel)
+	g.POST("/delPolicyLevel/:id", a.delPolicyLevel)
 	g.POST("/addClient", a.addInboundClient)
 	g.POST("/:id/delClient/:clientId", a.delInboundClient)
 	g.POST("/updateClient/:clientId", a.updateInboundClient)
@@ -32,6 +67,12 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	g.POST("/resetAllTraffics", a.resetAllTraffics)
 	g.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
 	g.POST("/delDepletedClients/:id", a.delDepletedClients)
+	g.POST("/bulkAddClients", a.bulkAddClients)
+	g.POST("/bulkExtendClients", a.bulkExtendClients)
+	g.POST("/bulkEnableClients", a.bulkEnableClients)
+	g.POST("/bulkDelClients", a.bulkDelClients)
+	g.POST("/bulkMoveClients", a.bulkMoveClients)
+	g.POST("/bulkSetClientsLevel", a.bulkSetClientsLevel)
 
 	a.inboundController = NewInboundController(g)
 }
@@ -61,6 +102,57 @@ func (a *APIController) getClientIps(c *gin.Context) {
 func (a *APIController) clearClientIps(c *gin.Context) {
 	a.inboundController.clearClientIps(c)
 }
+func (a *APIController) getClientIpHistory(c *gin.Context) {
+	a.inboundController.getClientIpHistory(c)
+}
+func (a *APIController) getIpHistory(c *gin.Context) {
+	a.inboundController.getIpHistory(c)
+}
+func (a *APIController) getSharedIps(c *gin.Context) {
+	a.inboundController.getSharedIps(c)
+}
+func (a *APIController) getDestinations(c *gin.Context) {
+	a.inboundController.getDestinations(c)
+}
+func (a *APIController) getIpLimitViolations(c *gin.Context) {
+	a.inboundController.getIpLimitViolations(c)
+}
+func (a *APIController) getIpLimitPolicies(c *gin.Context) {
+	a.inboundController.getIpLimitPolicies(c)
+}
+func (a *APIController) addIpLimitPolicy(c *gin.Context) {
+	a.inboundController.addIpLimitPolicy(c)
+}
+func (a *APIController) updateIpLimitPolicy(c *gin.Context) {
+	a.inboundController.updateIpLimitPolicy(c)
+}
+func (a *APIController) delIpLimitPolicy(c *gin.Context) {
+	a.inboundController.delIpLimitPolicy(c)
+}
+func (a *APIController) getIpLimitBans(c *gin.Context) {
+	a.inboundController.getIpLimitBans(c)
+}
+func (a *APIController) delIpLimitBan(c *gin.Context) {
+	a.inboundController.delIpLimitBan(c)
+}
+func (a *APIController) getPlans(c *gin.Context) {
+	a.inboundController.getPlans(c)
+}
+func (a *APIController) addPlan(c *gin.Context) {
+	a.inboundController.addPlan(c)
+}
+func (a *APIController) updatePlan(c *gin.Context) {
+	a.inboundController.updatePlan(c)
+}
+func (a *APIController) delPlan(c *gin.Context) {
+	a.inboundController.delPlan(c)
+}
+func (a *APIController) addClientFromPlan(c *gin.Context) {
+	a.inboundController.addClientFromPlan(c)
+}
+func (a *APIController) renewClientFromPlan(c *gin.Context) {
+	a.inboundController.renewClientFromPlan(c)
+}
 func (a *APIController) addInboundClient(c *gin.Context) {
 	a.inboundController.addInboundClient(c)
 }
@@ -82,3 +174,75 @@ func (a *APIController) resetAllClientTraffics(c *gin.Context) {
 func (a *APIController) delDepletedClients(c *gin.Context) {
 	a.inboundController.delDepletedClients(c)
 }
+func (a *APIController) bulkAddClients(c *gin.Context) {
+	a.inboundController.bulkAddClients(c)
+}
+func (a *APIController) bulkExtendClients(c *gin.Context) {
+	a.inboundController.bulkExtendClients(c)
+}
+func (a *APIController) bulkEnableClients(c *gin.Context) {
+	a.inboundController.bulkEnableClients(c)
+}
+func (a *APIController) bulkDelClients(c *gin.Context) {
+	a.inboundController.bulkDelClients(c)
+}
+func (a *APIController) bulkMoveClients(c *gin.Context) {
+	a.inboundController.bulkMoveClients(c)
+}
+func (a *APIController) bulkSetClientsLevel(c *gin.Context) {
+	a.inboundController.bulkSetClientsLevel(c)
+}
+func (a *APIController) getCustomers(c *gin.Context) {
+	a.inboundController.getCustomers(c)
+}
+func (a *APIController) addCustomer(c *gin.Context) {
+	a.inboundController.addCustomer(c)
+}
+func (a *APIController) updateCustomer(c *gin.Context) {
+	a.inboundController.updateCustomer(c)
+}
+func (a *APIController) delCustomer(c *gin.Context) {
+	a.inboundController.delCustomer(c)
+}
+func (a *APIController) getCustomerClients(c *gin.Context) {
+	a.inboundController.getCustomerClients(c)
+}
+func (a *APIController) addCustomerClients(c *gin.Context) {
+	a.inboundController.addCustomerClients(c)
+}
+func (a *APIController) attachCustomerClients(c *gin.Context) {
+	a.inboundController.attachCustomerClients(c)
+}
+func (a *APIController) detachCustomerClients(c *gin.Context) {
+	a.inboundController.detachCustomerClients(c)
+}
+func (a *APIController) resetCustomerTraffic(c *gin.Context) {
+	a.inboundController.resetCustomerTraffic(c)
+}
+func (a *APIController) getTrafficResetPolicies(c *gin.Context) {
+	a.inboundController.getTrafficResetPolicies(c)
+}
+func (a *APIController) addTrafficResetPolicy(c *gin.Context) {
+	a.inboundController.addTrafficResetPolicy(c)
+}
+func (a *APIController) updateTrafficResetPolicy(c *gin.Context) {
+	a.inboundController.updateTrafficResetPolicy(c)
+}
+func (a *APIController) delTrafficResetPolicy(c *gin.Context) {
+	a.inboundController.delTrafficResetPolicy(c)
+}
+func (a *APIController) getTrafficPeriods(c *gin.Context) {
+	a.inboundController.getTrafficPeriods(c)
+}
+func (a *APIController) getPolicyLevels(c *gin.Context) {
+	a.inboundController.getPolicyLevels(c)
+}
+func (a *APIController) addPolicyLevel(c *gin.Context) {
+	a.inboundController.addPolicyLevel(c)
+}
+func (a *APIController) updatePolicyLevel(c *gin.Context) {
+	a.inboundController.updatePolicyLevel(c)
+}
+func (a *APIController) delPolicyLevel(c *gin.Context) {
+	a.inboundController.delPolicyLevel(c)
+}
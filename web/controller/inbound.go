@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/util/common"
 	"x-ui/web/global"
 	"x-ui/web/service"
 	"x-ui/web/session"
@@ -13,8 +14,16 @@ import (
 )
 
 type InboundController struct {
-	inboundService service.InboundService
-	xrayService    service.XrayService
+	inboundService      service.InboundService
+	xrayService         service.XrayService
+	accessLogService    service.AccessLogService
+	ipLimitService      service.IpLimitService
+	clientIpService     service.ClientIpService
+	clientPlanService   service.ClientPlanService
+	clientBulkService   service.ClientBulkService
+	customerService     service.CustomerService
+	trafficResetService service.TrafficResetService
+	policyLevelService  service.PolicyLevelService
 }
 
 func NewInboundController(g *gin.RouterGroup) *InboundController {
@@ -33,6 +42,41 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g.POST("/update/:id", a.updateInbound)
 	g.POST("/clientIps/:email", a.getClientIps)
 	g.POST("/clearClientIps/:email", a.clearClientIps)
+	g.POST("/clientIpHistory/:email", a.getClientIpHistory)
+	g.POST("/ipHistory", a.getIpHistory)
+	g.POST("/sharedIps", a.getSharedIps)
+	g.POST("/destinations", a.getDestinations)
+	g.POST("/ipLimitViolations", a.getIpLimitViolations)
+	g.POST("/ipLimitPolicies", a.getIpLimitPolicies)
+	g.POST("/addIpLimitPolicy", a.addIpLimitPolicy)
+	g.POST("/updateIpLimitPolicy/:id", a.updateIpLimitPolicy)
+	g.POST("/delIpLimitPolicy/:id", a.delIpLimitPolicy)
+	g.POST("/ipLimitBans", a.getIpLimitBans)
+	g.POST("/delIpLimitBan", a.delIpLimitBan)
+	g.POST("/plans", a.getPlans)
+	g.POST("/addPlan", a.addPlan)
+	g.POST("/updatePlan/:id", a.updatePlan)
+	g.POST("/delPlan/:id", a.delPlan)
+	g.POST("/addClientFromPlan", a.addClientFromPlan)
+	g.POST("/renewClientFromPlan", a.renewClientFromPlan)
+	g.POST("/customers", a.getCustomers)
+	g.POST("/addCustomer", a.addCustomer)
+	g.POST("/updateCustomer/:id", a.updateCustomer)
+	g.POST("/delCustomer/:id", a.delCustomer)
+	g.POST("/customerClients/:id", a.getCustomerClients)
+	g.POST("/addCustomerClients/:id", a.addCustomerClients)
+	g.POST("/attachCustomerClients/:id", a.attachCustomerClients)
+	g.POST("/detachCustomerClients", a.detachCustomerClients)
+	g.POST("/resetCustomerTraffic/:id", a.resetCustomerTraffic)
+	g.POST("/trafficResetPolicies", a.getTrafficResetPolicies)
+	g.POST("/addTrafficResetPolicy", a.addTrafficResetPolicy)
+	g.POST("/updateTrafficResetPolicy/:id", a.updateTrafficResetPolicy)
+	g.POST("/delTrafficResetPolicy/:id", a.delTrafficResetPolicy)
+	g.POST("/trafficPeriods/:email", a.getTrafficPeriods)
+	g.POST("/policyLevels", a.getPolicyLevels)
+	g.POST("/addPolicyLevel", a.addPolicyLevel)
+	g.POST("/updatePolicyLevel/:id", a.updatePolicyLevel)
+	g.POST("/delPolicyLevel/:id", a.delPolicyLevel)
 	g.POST("/addClient", a.addInboundClient)
 	g.POST("/:id/delClient/:clientId", a.delInboundClient)
 	g.POST("/updateClient/:clientId", a.updateInboundClient)
@@ -40,6 +84,12 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g.POST("/resetAllTraffics", a.resetAllTraffics)
 	g.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
 	g.POST("/delDepletedClients/:id", a.delDepletedClients)
+	g.POST("/bulkAddClients", a.bulkAddClients)
+	g.POST("/bulkExtendClients", a.bulkExtendClients)
+	g.POST("/bulkEnableClients", a.bulkEnableClients)
+	g.POST("/bulkDelClients", a.bulkDelClients)
+	g.POST("/bulkMoveClients", a.bulkMoveClients)
+	g.POST("/bulkSetClientsLevel", a.bulkSetClientsLevel)
 
 }
 
@@ -151,6 +201,180 @@ func (a *InboundController) getClientIps(c *gin.Context) {
 	}
 	jsonObj(c, ips, nil)
 }
+func (a *InboundController) getClientIpHistory(c *gin.Context) {
+	email := c.Param("email")
+	histories, err := a.clientIpService.GetClientIpHistory(email)
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, histories, nil)
+}
+func (a *InboundController) getIpHistory(c *gin.Context) {
+	ip := common.NormalizeIP(c.PostForm("ip"))
+	if ip == "" {
+		jsonMsg(c, I18n(c, "get"), common.NewError("invalid ip:", c.PostForm("ip")))
+		return
+	}
+	histories, err := a.clientIpService.GetIpHistory(ip)
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, histories, nil)
+}
+func (a *InboundController) getSharedIps(c *gin.Context) {
+	since, _ := strconv.ParseInt(c.PostForm("since"), 10, 64)
+	shared, err := a.clientIpService.GetSharedIps(since)
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, shared, nil)
+}
+func (a *InboundController) getDestinations(c *gin.Context) {
+	query := &service.DestinationQuery{}
+	err := c.ShouldBind(query)
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	stats, err := a.accessLogService.GetTopDestinations(query)
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, stats, nil)
+}
+func (a *InboundController) getIpLimitViolations(c *gin.Context) {
+	jsonObj(c, a.ipLimitService.GetViolations(), nil)
+}
+func (a *InboundController) getIpLimitPolicies(c *gin.Context) {
+	policies, err := a.ipLimitService.GetPolicies()
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, policies, nil)
+}
+func (a *InboundController) addIpLimitPolicy(c *gin.Context) {
+	policy := &model.IpLimitPolicy{}
+	err := c.ShouldBind(policy)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	err = a.ipLimitService.AddPolicy(policy)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), policy, err)
+}
+func (a *InboundController) updateIpLimitPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	policy := &model.IpLimitPolicy{}
+	err = c.ShouldBind(policy)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	policy.Id = id
+	err = a.ipLimitService.UpdatePolicy(policy)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), policy, err)
+}
+func (a *InboundController) delIpLimitPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.ipLimitService.DelPolicy(id)
+	jsonMsg(c, I18n(c, "delete"), err)
+}
+func (a *InboundController) getIpLimitBans(c *gin.Context) {
+	bans, err := a.ipLimitService.GetBans()
+	jsonObj(c, bans, err)
+}
+func (a *InboundController) delIpLimitBan(c *gin.Context) {
+	ban := &model.IpLimitBan{}
+	err := c.ShouldBind(ban)
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.ipLimitService.DelBan(ban.Email, ban.Subnet)
+	jsonMsg(c, I18n(c, "delete"), err)
+}
+func (a *InboundController) getPlans(c *gin.Context) {
+	plans, err := a.clientPlanService.GetPlans()
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, plans, nil)
+}
+func (a *InboundController) addPlan(c *gin.Context) {
+	plan := &model.ClientPlan{}
+	err := c.ShouldBind(plan)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	err = a.clientPlanService.AddPlan(plan)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), plan, err)
+}
+func (a *InboundController) updatePlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	plan := &model.ClientPlan{}
+	err = c.ShouldBind(plan)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	plan.Id = id
+	err = a.clientPlanService.UpdatePlan(plan)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), plan, err)
+}
+func (a *InboundController) delPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.clientPlanService.DelPlan(id)
+	jsonMsg(c, I18n(c, "delete"), err)
+}
+func (a *InboundController) addClientFromPlan(c *gin.Context) {
+	req := &service.PlanClientRequest{}
+	err := c.ShouldBind(req)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	clients, err := a.clientPlanService.CreateClientFromPlan(req.PlanId, req.Email, req.TgId)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), clients, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+func (a *InboundController) renewClientFromPlan(c *gin.Context) {
+	req := &service.PlanClientRequest{}
+	err := c.ShouldBind(req)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	clients, err := a.clientPlanService.RenewClientFromPlan(req.PlanId, req.Email)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), clients, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
 func (a *InboundController) clearClientIps(c *gin.Context) {
 	email := c.Param("email")
 
@@ -276,3 +500,290 @@ func (a *InboundController) delDepletedClients(c *gin.Context) {
 	}
 	jsonMsg(c, "All delpeted clients are deleted", nil)
 }
+
+func (a *InboundController) bulkAddClients(c *gin.Context) {
+	req := &service.BulkAddRequest{}
+	err := c.ShouldBind(req)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	clients, err := a.clientBulkService.AddClients(req)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), clients, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// bulkClients binds a filtered bulk request, runs it and replies with the number of affected clients
+func (a *InboundController) bulkClients(c *gin.Context, msg string, run func(req *service.BulkClientRequest) (int, error)) {
+	req := &service.BulkClientRequest{}
+	err := c.ShouldBind(req)
+	if err != nil {
+		jsonMsg(c, msg, err)
+		return
+	}
+	count, err := run(req)
+	jsonMsgObj(c, msg, count, err)
+	if err == nil && count > 0 {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) bulkExtendClients(c *gin.Context) {
+	a.bulkClients(c, I18n(c, "pages.inbounds.update"), a.clientBulkService.ExtendClients)
+}
+
+func (a *InboundController) bulkEnableClients(c *gin.Context) {
+	a.bulkClients(c, I18n(c, "pages.inbounds.update"), a.clientBulkService.SetClientsEnable)
+}
+
+func (a *InboundController) bulkDelClients(c *gin.Context) {
+	a.bulkClients(c, I18n(c, "delete"), a.clientBulkService.DelClients)
+}
+
+func (a *InboundController) bulkMoveClients(c *gin.Context) {
+	a.bulkClients(c, I18n(c, "pages.inbounds.update"), a.clientBulkService.MoveClients)
+}
+
+func (a *InboundController) bulkSetClientsLevel(c *gin.Context) {
+	a.bulkClients(c, I18n(c, "pages.inbounds.update"), a.clientBulkService.SetClientsLevel)
+}
+
+func (a *InboundController) getCustomers(c *gin.Context) {
+	customers, err := a.customerService.GetCustomers()
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, customers, nil)
+}
+
+func (a *InboundController) addCustomer(c *gin.Context) {
+	customer := &model.Customer{}
+	err := c.ShouldBind(customer)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	err = a.customerService.AddCustomer(customer)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), customer, err)
+}
+
+func (a *InboundController) updateCustomer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	customer := &model.Customer{}
+	err = c.ShouldBind(customer)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	customer.Id = id
+	err = a.customerService.UpdateCustomer(customer)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), customer, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) delCustomer(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.customerService.DelCustomer(id)
+	jsonMsg(c, I18n(c, "delete"), err)
+}
+
+func (a *InboundController) getCustomerClients(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	traffics, err := a.customerService.GetCustomerClients(id)
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, traffics, nil)
+}
+
+func (a *InboundController) addCustomerClients(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	req := &service.CustomerClientsRequest{}
+	err = c.ShouldBind(req)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	clients, err := a.customerService.AddCustomerClients(id, req.InboundIds)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), clients, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) attachCustomerClients(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	req := &service.CustomerClientsRequest{}
+	err = c.ShouldBind(req)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	count, err := a.customerService.AttachClients(id, req.Emails)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), count, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) detachCustomerClients(c *gin.Context) {
+	req := &service.CustomerClientsRequest{}
+	err := c.ShouldBind(req)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	count, err := a.customerService.DetachClients(req.Emails)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), count, err)
+}
+
+func (a *InboundController) resetCustomerTraffic(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	err = a.customerService.ResetCustomerTraffic(id)
+	jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) getTrafficResetPolicies(c *gin.Context) {
+	policies, err := a.trafficResetService.GetPolicies()
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, policies, nil)
+}
+
+func (a *InboundController) addTrafficResetPolicy(c *gin.Context) {
+	policy := &model.TrafficResetPolicy{}
+	err := c.ShouldBind(policy)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	err = a.trafficResetService.AddPolicy(policy)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), policy, err)
+}
+
+func (a *InboundController) updateTrafficResetPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	policy := &model.TrafficResetPolicy{}
+	err = c.ShouldBind(policy)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	policy.Id = id
+	err = a.trafficResetService.UpdatePolicy(policy)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), policy, err)
+}
+
+func (a *InboundController) delTrafficResetPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.trafficResetService.DelPolicy(id)
+	jsonMsg(c, I18n(c, "delete"), err)
+}
+
+func (a *InboundController) getTrafficPeriods(c *gin.Context) {
+	periods, err := a.trafficResetService.GetTrafficPeriods(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, periods, nil)
+}
+
+func (a *InboundController) getPolicyLevels(c *gin.Context) {
+	levels, err := a.policyLevelService.GetLevels()
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, levels, nil)
+}
+
+func (a *InboundController) addPolicyLevel(c *gin.Context) {
+	level := &model.PolicyLevel{}
+	err := c.ShouldBind(level)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	err = a.policyLevelService.AddLevel(level)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), level, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) updatePolicyLevel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	level := &model.PolicyLevel{}
+	err = c.ShouldBind(level)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	level.Id = id
+	err = a.policyLevelService.UpdateLevel(level)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), level, err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+func (a *InboundController) delPolicyLevel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.policyLevelService.DelLevel(id)
+	jsonMsg(c, I18n(c, "delete"), err)
+	if err == nil {
+		a.xrayService.SetToNeedRestart()
+	}
+}
@@ -22,6 +22,7 @@ type IndexController struct {
 	settingService service.SettingService
 	userService    service.UserService
 	tgbot          service.Tgbot
+	notifyService  service.NotifyService
 }
 
 func NewIndexController(g *gin.RouterGroup) *IndexController {
@@ -64,6 +65,7 @@ func (a *IndexController) login(c *gin.Context) {
 	timeStr := time.Now().Format("2006-01-02 15:04:05")
 	if user == nil {
 		a.tgbot.UserLoginNotify(form.Username, getRemoteIp(c), timeStr, 0)
+		a.notifyService.LoginFailed(getRemoteIp(c))
 		logger.Infof("wrong username or password: \"%s\" \"%s\"", form.Username, form.Password)
 		pureJsonMsg(c, false, I18n(c, "pages.login.toasts.wrongUsernameOrPassword"))
 		return
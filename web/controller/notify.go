@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"strconv"
+	"x-ui/database/model"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotifyController struct {
+	notifyService service.NotifyService
+}
+
+func NewNotifyController(g *gin.RouterGroup) *NotifyController {
+	a := &NotifyController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *NotifyController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/notify")
+
+	g.POST("/rules", a.getRules)
+	g.POST("/addRule", a.addRule)
+	g.POST("/updateRule/:id", a.updateRule)
+	g.POST("/delRule/:id", a.delRule)
+}
+
+func (a *NotifyController) getRules(c *gin.Context) {
+	rules, err := a.notifyService.GetRules()
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	jsonObj(c, rules, nil)
+}
+
+func (a *NotifyController) addRule(c *gin.Context) {
+	rule := &model.NotifyRule{}
+	err := c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	err = a.notifyService.AddRule(rule)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), rule, err)
+}
+
+func (a *NotifyController) updateRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	rule := &model.NotifyRule{}
+	err = c.ShouldBind(rule)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.update"), err)
+		return
+	}
+	rule.Id = id
+	err = a.notifyService.UpdateRule(rule)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.update"), rule, err)
+}
+
+func (a *NotifyController) delRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.notifyService.DelRule(id)
+	jsonMsg(c, I18n(c, "delete"), err)
+}
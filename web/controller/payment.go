@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"io"
+	"x-ui/logger"
+	"x-ui/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PaymentController receives the notifications of payment providers, it is public and every provider
+// verifies its own notifications
+type PaymentController struct {
+	BaseController
+
+	paymentService service.PaymentService
+}
+
+func NewPaymentController(g *gin.RouterGroup) *PaymentController {
+	a := &PaymentController{}
+	a.initRouter(g)
+	return a
+}
+
+func (a *PaymentController) initRouter(g *gin.RouterGroup) {
+	g = g.Group("/payment")
+
+	g.POST("/webhook/:provider", a.webhook)
+}
+
+func (a *PaymentController) webhook(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.String(400, "Error!")
+		return
+	}
+	err = a.paymentService.HandleWebhook(c.Param("provider"), c.Request.Header, body)
+	if err != nil {
+		logger.Warning("payment webhook failed:", err)
+		c.String(400, "Error!")
+		return
+	}
+	c.String(200, "OK")
+}
@@ -1,7 +1,12 @@
 package controller
 
 import (
+	"io"
+	"os"
+	"strconv"
 	"time"
+	"x-ui/database/model"
+	"x-ui/logger"
 	"x-ui/web/global"
 	"x-ui/web/service"
 
@@ -11,7 +16,9 @@ import (
 type ServerController struct {
 	BaseController
 
-	serverService service.ServerService
+	serverService   service.ServerService
+	outboundService service.OutboundService
+	geoService      service.GeoService
 
 	lastStatus        *service.Status
 	lastGetStatusTime time.Time
@@ -38,10 +45,23 @@ func (a *ServerController) initRouter(g *gin.RouterGroup) {
 	g.POST("/stopXrayService", a.stopXrayService)
 	g.POST("/restartXrayService", a.restartXrayService)
 	g.POST("/installXray/:version", a.installXray)
+	g.POST("/installXrayFile", a.installXrayFile)
+	g.POST("/getInstalledXrayVersions", a.getInstalledXrayVersions)
+	g.POST("/rollbackXray/:version", a.rollbackXray)
 	g.POST("/logs/:count", a.getLogs)
+	g.POST("/searchLogs", a.searchLogs)
+	g.GET("/logStream", a.logStream)
 	g.POST("/getConfigJson", a.getConfigJson)
 	g.GET("/getDb", a.getDb)
 	g.POST("/getNewX25519Cert", a.getNewX25519Cert)
+	g.POST("/getOutboundsTraffic", a.getOutboundsTraffic)
+	g.POST("/resetOutboundTraffic/:tag", a.resetOutboundTraffic)
+	g.POST("/getGeoAssets", a.getGeoAssets)
+	g.POST("/addGeoAsset", a.addGeoAsset)
+	g.POST("/updateGeoAsset/:id", a.updateGeoAsset)
+	g.POST("/delGeoAsset/:id", a.delGeoAsset)
+	g.POST("/refreshGeoAssets", a.refreshGeoAssets)
+	g.POST("/refreshGeoAsset/:id", a.refreshGeoAsset)
 }
 
 func (a *ServerController) refreshStatus() {
@@ -91,6 +111,45 @@ func (a *ServerController) installXray(c *gin.Context) {
 	jsonMsg(c, I18n(c, "install")+" xray", err)
 }
 
+func (a *ServerController) installXrayFile(c *gin.Context) {
+	file, err := c.FormFile("zip")
+	if err != nil {
+		jsonMsg(c, I18n(c, "install")+" xray", err)
+		return
+	}
+	zipFile, err := os.CreateTemp("", "xray-*.zip")
+	if err != nil {
+		jsonMsg(c, I18n(c, "install")+" xray", err)
+		return
+	}
+	zipFile.Close()
+	defer os.Remove(zipFile.Name())
+
+	err = c.SaveUploadedFile(file, zipFile.Name())
+	if err != nil {
+		jsonMsg(c, I18n(c, "install")+" xray", err)
+		return
+	}
+	// dgst is the content of the release .dgst file or a bare sha256 hex
+	err = a.serverService.InstallXrayFromFile(zipFile.Name(), c.PostForm("dgst"))
+	jsonMsg(c, I18n(c, "install")+" xray", err)
+}
+
+func (a *ServerController) getInstalledXrayVersions(c *gin.Context) {
+	versions, err := a.serverService.GetInstalledXrayVersions()
+	if err != nil {
+		jsonMsg(c, "get xray versions", err)
+		return
+	}
+	jsonObj(c, versions, nil)
+}
+
+func (a *ServerController) rollbackXray(c *gin.Context) {
+	version := c.Param("version")
+	err := a.serverService.RollbackXray(version)
+	jsonMsg(c, "rollback xray", err)
+}
+
 func (a *ServerController) stopXrayService(c *gin.Context) {
 	a.lastGetStatusTime = time.Now()
 	err := a.serverService.StopXrayService()
@@ -121,6 +180,48 @@ func (a *ServerController) getLogs(c *gin.Context) {
 	jsonObj(c, logs, nil)
 }
 
+func (a *ServerController) searchLogs(c *gin.Context) {
+	filter := &logger.Filter{}
+	err := c.ShouldBind(filter)
+	if err != nil {
+		jsonMsg(c, "searchLogs", err)
+		return
+	}
+	entries, err := a.serverService.SearchLogs(filter)
+	if err != nil {
+		jsonMsg(c, "searchLogs", err)
+		return
+	}
+	jsonObj(c, entries, nil)
+}
+
+// logStream pushes new log entries matching the query filter as server-sent events
+func (a *ServerController) logStream(c *gin.Context) {
+	filter := &logger.Filter{}
+	err := c.ShouldBindQuery(filter)
+	if err != nil {
+		jsonMsg(c, "logStream", err)
+		return
+	}
+	entries, cancel := logger.Subscribe()
+	defer cancel()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case entry, ok := <-entries:
+			if !ok {
+				return false
+			}
+			if filter.Match(entry) {
+				c.SSEvent("log", entry)
+			}
+			return true
+		}
+	})
+}
+
 func (a *ServerController) getConfigJson(c *gin.Context) {
 	configJson, err := a.serverService.GetConfigJson()
 	if err != nil {
@@ -152,3 +253,86 @@ func (a *ServerController) getNewX25519Cert(c *gin.Context) {
 	}
 	jsonObj(c, cert, nil)
 }
+
+func (a *ServerController) getOutboundsTraffic(c *gin.Context) {
+	outboundsTraffic, err := a.outboundService.GetOutboundsTraffic()
+	if err != nil {
+		jsonMsg(c, "Error getting traffics", err)
+		return
+	}
+	jsonObj(c, outboundsTraffic, nil)
+}
+
+func (a *ServerController) resetOutboundTraffic(c *gin.Context) {
+	tag := c.Param("tag")
+	err := a.outboundService.ResetOutboundTraffic(tag)
+	if err != nil {
+		jsonMsg(c, "Something went wrong!", err)
+		return
+	}
+	jsonMsg(c, "Outbound traffic reseted", nil)
+}
+
+func (a *ServerController) getGeoAssets(c *gin.Context) {
+	assets, err := a.geoService.GetGeoAssets()
+	if err != nil {
+		jsonMsg(c, "get geo files", err)
+		return
+	}
+	jsonObj(c, assets, nil)
+}
+
+func (a *ServerController) addGeoAsset(c *gin.Context) {
+	asset := &model.GeoAsset{}
+	err := c.ShouldBind(asset)
+	if err != nil {
+		jsonMsg(c, "add geo file", err)
+		return
+	}
+	err = a.geoService.AddGeoAsset(asset)
+	jsonMsgObj(c, "add geo file", asset, err)
+}
+
+func (a *ServerController) updateGeoAsset(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "update geo file", err)
+		return
+	}
+	asset := &model.GeoAsset{
+		Id: id,
+	}
+	err = c.ShouldBind(asset)
+	if err != nil {
+		jsonMsg(c, "update geo file", err)
+		return
+	}
+	asset.Id = id
+	err = a.geoService.UpdateGeoAsset(asset)
+	jsonMsgObj(c, "update geo file", asset, err)
+}
+
+func (a *ServerController) delGeoAsset(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.geoService.DelGeoAsset(id)
+	jsonMsgObj(c, I18n(c, "delete"), id, err)
+}
+
+func (a *ServerController) refreshGeoAssets(c *gin.Context) {
+	err := a.geoService.RefreshGeoAssets()
+	jsonMsg(c, "update geo files", err)
+}
+
+func (a *ServerController) refreshGeoAsset(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, "update geo file", err)
+		return
+	}
+	err = a.geoService.RefreshGeoAsset(id)
+	jsonMsg(c, "update geo file", err)
+}
@@ -9,8 +9,10 @@ import (
 )
 
 type TelegramController struct {
-	telegramService service.TelegramService
-	xrayService     service.XrayService
+	telegramService  service.TelegramService
+	xrayService      service.XrayService
+	paymentService   service.PaymentService
+	broadcastService service.BroadcastService
 }
 
 func NewTelegramController(g *gin.RouterGroup) *TelegramController {
@@ -33,6 +35,17 @@ func (a *TelegramController) initRouter(g *gin.RouterGroup) {
 
 	g.POST("/listMsgs", a.getClientMsgs)
 	g.POST("/msg/del/:id", a.delMsg)
+
+	g.POST("/payments", a.getPayments)
+	g.POST("/adminActions", a.getAdminActions)
+	g.POST("/referrals", a.getReferrals)
+	g.POST("/referralStats", a.getReferralStats)
+
+	g.POST("/broadcasts", a.getBroadcasts)
+	g.POST("/broadcast/add", a.addBroadcast)
+	g.POST("/broadcast/cancel/:id", a.cancelBroadcast)
+	g.POST("/broadcast/del/:id", a.delBroadcast)
+	g.POST("/broadcast/deliveries/:id", a.getDeliveries)
 }
 
 func (a *TelegramController) getClients(c *gin.Context) {
@@ -55,6 +68,96 @@ func (a *TelegramController) getClientMsgs(c *gin.Context) {
 	jsonObj(c, msgs, nil)
 }
 
+func (a *TelegramController) getPayments(c *gin.Context) {
+	payments, err := a.paymentService.GetPayments()
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, payments, nil)
+}
+
+func (a *TelegramController) getAdminActions(c *gin.Context) {
+	actions, err := a.telegramService.GetAdminActions()
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, actions, nil)
+}
+
+func (a *TelegramController) getReferrals(c *gin.Context) {
+	referrals, err := a.telegramService.GetReferrals()
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, referrals, nil)
+}
+
+func (a *TelegramController) getReferralStats(c *gin.Context) {
+	stats, err := a.telegramService.GetReferralStats()
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, stats, nil)
+}
+
+func (a *TelegramController) getBroadcasts(c *gin.Context) {
+	broadcasts, err := a.broadcastService.GetBroadcasts()
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, broadcasts, nil)
+}
+
+func (a *TelegramController) addBroadcast(c *gin.Context) {
+	broadcast := &model.Broadcast{}
+	err := c.ShouldBind(broadcast)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.create"), err)
+		return
+	}
+	err = a.broadcastService.AddBroadcast(broadcast)
+	jsonMsgObj(c, I18n(c, "pages.inbounds.create"), broadcast, err)
+}
+
+func (a *TelegramController) cancelBroadcast(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		jsonMsg(c, I18n(c, "cancel"), err)
+		return
+	}
+	err = a.broadcastService.CancelBroadcast(id)
+	jsonMsg(c, I18n(c, "cancel"), err)
+}
+
+func (a *TelegramController) delBroadcast(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		jsonMsg(c, I18n(c, "delete"), err)
+		return
+	}
+	err = a.broadcastService.DelBroadcast(id)
+	jsonMsg(c, I18n(c, "delete"), err)
+}
+
+func (a *TelegramController) getDeliveries(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		jsonMsg(c, I18n(c, "get"), err)
+		return
+	}
+	deliveries, err := a.broadcastService.GetDeliveries(id)
+	if err != nil {
+		jsonMsg(c, I18n(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, deliveries, nil)
+}
+
 func (a *TelegramController) sendMsg(c *gin.Context) {
 	// user := session.GetLoginUser(c)
 	clientMsg := &model.TgClientMsg{}
@@ -67,7 +170,11 @@ func (a *TelegramController) sendMsg(c *gin.Context) {
 	if clientMsg.ChatID > 0 {
 		err = a.telegramService.SendMsgToTgBot(clientMsg.ChatID, clientMsg.Msg)
 	} else {
-		err = a.telegramService.BroadcastMsgToBot(clientMsg.Msg)
+		err = a.broadcastService.AddBroadcast(&model.Broadcast{
+			Name:    I18n(c, "sendMsg"),
+			Segment: model.BroadcastAll,
+			Msg:     clientMsg.Msg,
+		})
 	}
 	jsonMsgObj(c, I18n(c, "sendMsg"), clientMsg.ChatID, err)
 	if err != nil {
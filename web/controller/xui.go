@@ -10,6 +10,7 @@ type XUIController struct {
 	inboundController  *InboundController
 	telegramController *TelegramController
 	settingController  *SettingController
+	notifyController   *NotifyController
 }
 
 func NewXUIController(g *gin.RouterGroup) *XUIController {
@@ -29,6 +30,7 @@ func (a *XUIController) initRouter(g *gin.RouterGroup) {
 	a.inboundController = NewInboundController(g)
 	a.telegramController = NewTelegramController(g)
 	a.settingController = NewSettingController(g)
+	a.notifyController = NewNotifyController(g)
 }
 
 func (a *XUIController) index(c *gin.Context) {
@@ -34,18 +34,31 @@ type AllSetting struct {
 	WebBasePath              string `json:"webBasePath" form:"webBasePath"`
 	TgBotEnable              bool   `json:"tgBotEnable" form:"tgBotEnable"`
 	TgBotToken               string `json:"tgBotToken" form:"tgBotToken"`
-	TgBotChatId              int    `json:"tgBotChatId" form:"tgBotChatId"`
+	TgBotChatId              string `json:"tgBotChatId" form:"tgBotChatId"`
 	TgRunTime                string `json:"tgRunTime" form:"tgRunTime"`
 	XrayTemplateConfig       string `json:"xrayTemplateConfig" form:"xrayTemplateConfig"`
 	TgCrmEnabled             bool   `json:"tgCrmEnabled" form:"tgCrmEnabled"`
-	TgClientRegAccList       string `json:"tgClientRegAccList" form:"tgClientRegAccList"`
 	TgClientRegFinalMsg      string `json:"tgClientRegFinalMsg" form:"tgClientRegFinalMsg"`
 	TgMoneyTransferMsg       string `json:"tgMoneyTransferMsg" form:"tgMoneyTransferMsg"`
 	TelegramCrmTargetInbound int    `json:"telegramCrmTargetInbound" form:"telegramCrmTargetInbound"`
 	TgReferToFriendsMsg      string `json:"tgReferToFriendsMsg" form:"tgReferToFriendsMsg"`
 	TgContactSupportMsg      string `json:"tgContactSupportMsg" form:"tgContactSupportMsg"`
+	TgReferralRewardDays     int    `json:"tgReferralRewardDays" form:"tgReferralRewardDays"`
+	TgReferralRewardTraffic  int    `json:"tgReferralRewardTraffic" form:"tgReferralRewardTraffic"`
 
-	TimeLocation string `json:"timeLocation" form:"timeLocation"`
+	TimeLocation     string `json:"timeLocation" form:"timeLocation"`
+	GeoUpdateRuntime string `json:"geoUpdateRuntime" form:"geoUpdateRuntime"`
+	XrayMirrorUrl    string `json:"xrayMirrorUrl" form:"xrayMirrorUrl"`
+	XrayKeepVersions int    `json:"xrayKeepVersions" form:"xrayKeepVersions"`
+	IpLimitWebhook   string `json:"ipLimitWebhook" form:"ipLimitWebhook"`
+	SubURI           string `json:"subURI" form:"subURI"`
+
+	PaymentCurrency      string `json:"paymentCurrency" form:"paymentCurrency"`
+	PaymentPriceScale    int    `json:"paymentPriceScale" form:"paymentPriceScale"`
+	TgPaymentToken       string `json:"tgPaymentToken" form:"tgPaymentToken"`
+	InvoiceApiUrl        string `json:"invoiceApiUrl" form:"invoiceApiUrl"`
+	InvoiceApiKey        string `json:"invoiceApiKey" form:"invoiceApiKey"`
+	InvoiceWebhookSecret string `json:"invoiceWebhookSecret" form:"invoiceWebhookSecret"`
 }
 
 func (s *AllSetting) CheckValid() error {
@@ -85,5 +98,9 @@ func (s *AllSetting) CheckValid() error {
 		return common.NewError("time location not exist:", s.TimeLocation)
 	}
 
+	if s.PaymentPriceScale < 1 {
+		return common.NewError("payment price scale must be at least 1:", s.PaymentPriceScale)
+	}
+
 	return nil
 }
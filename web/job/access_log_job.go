@@ -0,0 +1,30 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+	"x-ui/xray"
+)
+
+// AccessLogJob tails the xray access log and feeds the parsed records to the subscribed consumers
+type AccessLogJob struct {
+	xrayService service.XrayService
+	tailer      *xray.AccessLogTailer
+}
+
+func NewAccessLogJob() *AccessLogJob {
+	return &AccessLogJob{
+		tailer: xray.NewAccessLogTailer(),
+	}
+}
+
+func (j *AccessLogJob) Subscribe(consumer xray.AccessLogConsumer) {
+	j.tailer.Subscribe(consumer)
+}
+
+func (j *AccessLogJob) Run() {
+	err := j.tailer.Poll(j.xrayService.GetAccessLogPath())
+	if err != nil {
+		logger.Warning("read xray access log failed:", err)
+	}
+}
@@ -0,0 +1,16 @@
+package job
+
+import "x-ui/web/service"
+
+type BroadcastJob struct {
+	broadcastService service.BroadcastService
+}
+
+func NewBroadcastJob() *BroadcastJob {
+	return new(BroadcastJob)
+}
+
+// Run sends the broadcasts that are due, a broadcast still being sent by an earlier run is left to it
+func (j *BroadcastJob) Run() {
+	j.broadcastService.RunDue()
+}
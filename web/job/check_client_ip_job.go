@@ -3,95 +3,99 @@ package job
 import (
 	"encoding/json"
 	"os"
-	"regexp"
 	"x-ui/database"
 	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/util/common"
 	"x-ui/web/service"
 	"x-ui/xray"
 
-	"net"
-	"sort"
 	"strings"
-	"time"
-
-	"github.com/go-cmd/cmd"
+	"sync"
 )
 
 type CheckClientIpJob struct {
-	xrayService service.XrayService
+	ipLimitService  service.IpLimitService
+	clientIpService service.ClientIpService
+
+	lock      sync.Mutex
+	clientIps map[string]map[string]*model.ClientIpHistory
 }
 
-var job *CheckClientIpJob
 var disAllowedIps []string
 
 func NewCheckClientIpJob() *CheckClientIpJob {
-	job = new(CheckClientIpJob)
-	return job
+	return &CheckClientIpJob{
+		clientIps: make(map[string]map[string]*model.ClientIpHistory),
+	}
 }
 
-func (j *CheckClientIpJob) Run() {
-	logger.Debug("Check Client IP Job...")
-	processLogFile()
-
-	// disAllowedIps = []string{"192.168.1.183","192.168.1.197"}
-	blockedIps := []byte(strings.Join(disAllowedIps, ","))
-	err := os.WriteFile(xray.GetBlockedIPsPath(), blockedIps, 0755)
-	checkError(err)
+// ConsumeAccessLogs collects client source ips from the access log tailer until the next Run
+func (j *CheckClientIpJob) ConsumeAccessLogs(accessLogs []*xray.AccessLog) {
+	j.ipLimitService.TrackAccessLogs(accessLogs)
 
-}
+	j.lock.Lock()
+	defer j.lock.Unlock()
 
-func processLogFile() {
-	accessLogPath := GetAccessLogPath()
-	if accessLogPath == "" {
-		logger.Warning("xray log not init in config.json")
-		return
+	for _, accessLog := range accessLogs {
+		ip := accessLog.SourceIP
+		if common.IsLoopbackIP(ip) || ip == "1.1.1.1" || accessLog.Email == "" {
+			continue
+		}
+		ips, ok := j.clientIps[accessLog.Email]
+		if !ok {
+			ips = make(map[string]*model.ClientIpHistory)
+			j.clientIps[accessLog.Email] = ips
+		}
+		history, ok := ips[ip]
+		if !ok {
+			history = &model.ClientIpHistory{
+				Email:     accessLog.Email,
+				Ip:        ip,
+				FirstSeen: accessLog.Time,
+			}
+			ips[ip] = history
+		}
+		if accessLog.Time > history.LastSeen {
+			history.LastSeen = accessLog.Time
+		}
+		history.Hits++
 	}
+}
 
-	data, err := os.ReadFile(accessLogPath)
-	InboundClientIps := make(map[string][]string)
-	checkError(err)
+func (j *CheckClientIpJob) Run() {
+	logger.Debug("Check Client IP Job...")
+	j.processClientIps()
 
-	// clean log
-	if err := os.Truncate(GetAccessLogPath(), 0); err != nil {
-		checkError(err)
+	// check if client connections come from more ips than limited and drop them
+	blockedIps, err := j.ipLimitService.Enforce()
+	if err != nil {
+		logger.Warning("enforce ip limit failed:", err)
+	} else {
+		disAllowedIps = blockedIps
 	}
 
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		ipRegx, _ := regexp.Compile(`[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+`)
-		emailRegx, _ := regexp.Compile(`email:.+`)
-
-		matchesIp := ipRegx.FindString(line)
-		if len(matchesIp) > 0 {
-			ip := string(matchesIp)
-			if ip == "127.0.0.1" || ip == "1.1.1.1" {
-				continue
-			}
+	// disAllowedIps = []string{"192.168.1.183","192.168.1.197"}
+	err = os.WriteFile(xray.GetBlockedIPsPath(), []byte(strings.Join(disAllowedIps, ",")), 0755)
+	checkError(err)
 
-			matchesEmail := emailRegx.FindString(line)
-			if matchesEmail == "" {
-				continue
-			}
-			matchesEmail = strings.Split(matchesEmail, "email: ")[1]
+}
 
-			if InboundClientIps[matchesEmail] != nil {
-				if contains(InboundClientIps[matchesEmail], ip) {
-					continue
-				}
-				InboundClientIps[matchesEmail] = append(InboundClientIps[matchesEmail], ip)
+func (j *CheckClientIpJob) processClientIps() {
+	j.lock.Lock()
+	InboundClientIps := j.clientIps
+	j.clientIps = make(map[string]map[string]*model.ClientIpHistory)
+	j.lock.Unlock()
 
-			} else {
-				InboundClientIps[matchesEmail] = append(InboundClientIps[matchesEmail], ip)
-			}
+	histories := make([]*model.ClientIpHistory, 0)
+	for clientEmail, seenIps := range InboundClientIps {
+		ips := make([]string, 0, len(seenIps))
+		for ip, history := range seenIps {
+			ips = append(ips, ip)
+			histories = append(histories, history)
 		}
-
-	}
-	disAllowedIps = []string{}
-
-	for clientEmail, ips := range InboundClientIps {
 		inboundClientIps, err := GetInboundClientIps(clientEmail)
-		sort.Strings(ips)
+		common.SortIPs(ips)
 		if err != nil {
 			addInboundClientIps(clientEmail, ips)
 		} else {
@@ -99,32 +103,8 @@ func processLogFile() {
 		}
 	}
 
-	// check if inbound connection is more than limited ip and drop connection
-	LimitDevice := func() { LimitDevice() }
-
-	stop := schedule(LimitDevice, 1000*time.Millisecond)
-	time.Sleep(10 * time.Second)
-	stop <- true
-
-}
-func GetAccessLogPath() string {
-
-	config, err := os.ReadFile(xray.GetConfigPath())
-	checkError(err)
-
-	jsonConfig := map[string]interface{}{}
-	err = json.Unmarshal([]byte(config), &jsonConfig)
+	err := j.clientIpService.AddHistory(histories)
 	checkError(err)
-	if jsonConfig["log"] != nil {
-		jsonLog := jsonConfig["log"].(map[string]interface{})
-		if jsonLog["access"] != nil {
-
-			accessLogPath := jsonLog["access"].(string)
-
-			return accessLogPath
-		}
-	}
-	return ""
 
 }
 func checkError(e error) {
@@ -179,42 +159,12 @@ func addInboundClientIps(clientEmail string, ips []string) error {
 	return nil
 }
 func updateInboundClientIps(inboundClientIps *model.InboundClientIps, clientEmail string, ips []string) error {
-
 	jsonIps, err := json.Marshal(ips)
 	checkError(err)
 
 	inboundClientIps.ClientEmail = clientEmail
 	inboundClientIps.Ips = string(jsonIps)
 
-	// check inbound limitation
-	inbound, err := GetInboundByEmail(clientEmail)
-	checkError(err)
-
-	if inbound.Settings == "" {
-		logger.Debug("wrong data ", inbound)
-		return nil
-	}
-
-	settings := map[string][]model.Client{}
-	json.Unmarshal([]byte(inbound.Settings), &settings)
-	clients := settings["clients"]
-
-	var disAllowedIps []string // initialize the slice
-
-	for _, client := range clients {
-		if client.Email == clientEmail {
-
-			limitIp := client.LimitIP
-
-			if limitIp < len(ips) && limitIp != 0 && inbound.Enable {
-
-				disAllowedIps = append(disAllowedIps, ips[limitIp:]...)
-			}
-		}
-	}
-	logger.Debug("disAllowedIps ", disAllowedIps)
-	sort.Strings(disAllowedIps)
-
 	db := database.GetDB()
 	err = db.Save(inboundClientIps).Error
 	if err != nil {
@@ -222,128 +172,3 @@ func updateInboundClientIps(inboundClientIps *model.InboundClientIps, clientEmai
 	}
 	return nil
 }
-
-func DisableInbound(id int) error {
-	db := database.GetDB()
-	result := db.Model(model.Inbound{}).
-		Where("id = ? and enable = ?", id, true).
-		Update("enable", false)
-	err := result.Error
-	logger.Warning("disable inbound with id:", id)
-
-	if err == nil {
-		job.xrayService.SetToNeedRestart()
-	}
-
-	return err
-}
-
-func GetInboundByEmail(clientEmail string) (*model.Inbound, error) {
-	db := database.GetDB()
-	var inbounds *model.Inbound
-	err := db.Model(model.Inbound{}).Where("settings LIKE ?", "%"+clientEmail+"%").Find(&inbounds).Error
-	if err != nil {
-		return nil, err
-	}
-	return inbounds, nil
-}
-
-func LimitDevice() {
-	var destIp, destPort, srcIp, srcPort string
-
-	localIp, err := LocalIP()
-	checkError(err)
-
-	c := cmd.NewCmd("bash", "-c", "ss --tcp | grep -E '"+IPsToRegex(localIp)+"'| awk '{if($1==\"ESTAB\") print $4,$5;}'", "| sort | uniq -c | sort -nr | head")
-
-	<-c.Start()
-	if len(c.Status().Stdout) > 0 {
-		ipRegx, _ := regexp.Compile(`[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+`)
-		portRegx, _ := regexp.Compile(`(?:(:))([0-9]..[^.][0-9]+)`)
-
-		for _, row := range c.Status().Stdout {
-
-			data := strings.Split(row, " ")
-
-			if len(data) < 2 {
-				continue // Skip this row if it doesn't have at least two elements
-			}
-
-			destIp = string(ipRegx.FindString(data[0]))
-			destPort = portRegx.FindString(data[0])
-			destPort = strings.Replace(destPort, ":", "", -1)
-
-			srcIp = string(ipRegx.FindString(data[1]))
-			srcPort = portRegx.FindString(data[1])
-			srcPort = strings.Replace(srcPort, ":", "", -1)
-
-			if contains(disAllowedIps, srcIp) {
-				dropCmd := cmd.NewCmd("bash", "-c", "ss -K dport = "+srcPort)
-				dropCmd.Start()
-
-				logger.Debug("request droped : ", srcIp, srcPort, "to", destIp, destPort)
-			}
-		}
-	}
-}
-
-func LocalIP() ([]string, error) {
-	// get machine ips
-
-	ifaces, err := net.Interfaces()
-	ips := []string{}
-	if err != nil {
-		return ips, err
-	}
-	for _, i := range ifaces {
-		addrs, err := i.Addrs()
-		if err != nil {
-			return ips, err
-		}
-
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-
-			ips = append(ips, ip.String())
-
-		}
-	}
-	logger.Debug("System IPs : ", ips)
-
-	return ips, nil
-}
-
-func IPsToRegex(ips []string) string {
-
-	regx := ""
-	for _, ip := range ips {
-		regx += "(" + strings.Replace(ip, ".", "\\.", -1) + ")"
-
-	}
-	regx = "(" + strings.Replace(regx, ")(", ")|(.", -1) + ")"
-
-	return regx
-}
-
-func schedule(LimitDevice func(), delay time.Duration) chan bool {
-	stop := make(chan bool)
-
-	go func() {
-		for {
-			LimitDevice()
-			select {
-			case <-time.After(delay):
-			case <-stop:
-				return
-			}
-		}
-	}()
-
-	return stop
-}
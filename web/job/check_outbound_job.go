@@ -0,0 +1,55 @@
+package job
+
+import (
+	"fmt"
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type CheckOutboundJob struct {
+	xrayService  service.XrayService
+	tgbotService service.Tgbot
+
+	lastAlive map[string]bool
+}
+
+func NewCheckOutboundJob() *CheckOutboundJob {
+	return &CheckOutboundJob{
+		lastAlive: make(map[string]bool),
+	}
+}
+
+// Here run is a interface method of Job interface
+func (j *CheckOutboundJob) Run() {
+	if !j.xrayService.IsXrayRunning() {
+		return
+	}
+	statuses, err := j.xrayService.GetOutboundStatus()
+	if err != nil {
+		logger.Warning("get outbound status failed:", err)
+		return
+	}
+
+	for _, status := range statuses {
+		lastAlive, ok := j.lastAlive[status.Tag]
+		j.lastAlive[status.Tag] = status.Alive
+		// first probe after start only records the state
+		if !ok || lastAlive == status.Alive {
+			continue
+		}
+		if status.Alive {
+			logger.Info("outbound", status.Tag, "is up again")
+		} else {
+			logger.Warning("outbound", status.Tag, "is down:", status.LastError)
+		}
+		if !j.tgbotService.IsRunnging() {
+			continue
+		}
+		j.tgbotService.SendTrToTgbotAdmins(func(lang string) string {
+			if status.Alive {
+				return service.Tr("msgOutboundUp", lang, "Tag=="+status.Tag, "Delay=="+fmt.Sprint(status.Delay))
+			}
+			return service.Tr("msgOutboundDown", lang, "Tag=="+status.Tag, "Error=="+status.LastError)
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type ClearAccessStatJob struct {
+	accessLogService service.AccessLogService
+}
+
+func NewClearAccessStatJob() *ClearAccessStatJob {
+	return new(ClearAccessStatJob)
+}
+
+func (j *ClearAccessStatJob) Run() {
+	err := j.accessLogService.DelOldAccessStats()
+	if err != nil {
+		logger.Warning("clear old access stats failed:", err)
+	}
+}
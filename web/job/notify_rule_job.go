@@ -0,0 +1,15 @@
+package job
+
+import "x-ui/web/service"
+
+type NotifyRuleJob struct {
+	notifyService service.NotifyService
+}
+
+func NewNotifyRuleJob() *NotifyRuleJob {
+	return new(NotifyRuleJob)
+}
+
+func (j *NotifyRuleJob) Run() {
+	j.notifyService.CheckRules()
+}
@@ -0,0 +1,25 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type TrafficResetJob struct {
+	xrayService         service.XrayService
+	trafficResetService service.TrafficResetService
+}
+
+func NewTrafficResetJob() *TrafficResetJob {
+	return new(TrafficResetJob)
+}
+
+func (j *TrafficResetJob) Run() {
+	count, err := j.trafficResetService.ResetTraffics()
+	if err != nil {
+		logger.Warning("reset client traffics failed:", err)
+	} else if count > 0 {
+		logger.Infof("reset traffic of %v clients", count)
+		j.xrayService.SetToNeedRestart()
+	}
+}
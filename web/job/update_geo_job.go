@@ -0,0 +1,21 @@
+package job
+
+import (
+	"x-ui/logger"
+	"x-ui/web/service"
+)
+
+type UpdateGeoJob struct {
+	geoService service.GeoService
+}
+
+func NewUpdateGeoJob() *UpdateGeoJob {
+	return new(UpdateGeoJob)
+}
+
+func (j *UpdateGeoJob) Run() {
+	err := j.geoService.RefreshGeoAssets()
+	if err != nil {
+		logger.Warning("update geo files failed:", err)
+	}
+}
@@ -6,8 +6,9 @@ import (
 )
 
 type XrayTrafficJob struct {
-	xrayService    service.XrayService
-	inboundService service.InboundService
+	xrayService     service.XrayService
+	inboundService  service.InboundService
+	outboundService service.OutboundService
 }
 
 func NewXrayTrafficJob() *XrayTrafficJob {
@@ -34,4 +35,9 @@ func (j *XrayTrafficJob) Run() {
 		logger.Warning("add client traffic failed:", err)
 	}
 
+	err = j.outboundService.AddTraffic(traffics)
+	if err != nil {
+		logger.Warning("add outbound traffic failed:", err)
+	}
+
 }
@@ -0,0 +1,145 @@
+package locale
+
+import (
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"x-ui/logger"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/text/language"
+)
+
+// messages without a translation in the language of a chat fall back to English
+var fallbackLang = language.English.String()
+
+var (
+	bundle         *i18n.Bundle
+	languages      []string
+	localizers     = map[string]*i18n.Localizer{}
+	localizersLock sync.Mutex
+)
+
+// InitLocalizer loads the translation files under dir of fsys into the bundle shared by the panel and the
+// telegram bot, keys missing from a translation are reported
+func InitLocalizer(fsys fs.FS, dir string) error {
+	b := i18n.NewBundle(language.SimplifiedChinese)
+	b.RegisterUnmarshalFunc("toml", toml.Unmarshal)
+	keys := map[string]map[string]bool{}
+	err := fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		file, err := b.ParseMessageFileBytes(data, path)
+		if err != nil {
+			return err
+		}
+		lang := file.Tag.String()
+		if keys[lang] == nil {
+			keys[lang] = map[string]bool{}
+		}
+		for _, message := range file.Messages {
+			keys[lang][message.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for lang, missing := range MissingKeys(keys) {
+		logger.Warningf("translation %s misses %d keys: %s", lang, len(missing), strings.Join(missing, ", "))
+	}
+
+	langs := make([]string, 0, len(keys))
+	for lang := range keys {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	localizersLock.Lock()
+	defer localizersLock.Unlock()
+	bundle = b
+	languages = langs
+	localizers = map[string]*i18n.Localizer{}
+	return nil
+}
+
+// MissingKeys returns the keys each language misses from the union of the keys of all languages
+func MissingKeys(keys map[string]map[string]bool) map[string][]string {
+	all := map[string]bool{}
+	for _, langKeys := range keys {
+		for key := range langKeys {
+			all[key] = true
+		}
+	}
+	missing := map[string][]string{}
+	for lang, langKeys := range keys {
+		for key := range all {
+			if !langKeys[key] {
+				missing[lang] = append(missing[lang], key)
+			}
+		}
+		sort.Strings(missing[lang])
+	}
+	for lang, langMissing := range missing {
+		if len(langMissing) == 0 {
+			delete(missing, lang)
+		}
+	}
+	return missing
+}
+
+func GetBundle() *i18n.Bundle {
+	return bundle
+}
+
+// GetLanguages returns the tags of the loaded translations
+func GetLanguages() []string {
+	return languages
+}
+
+func getLocalizer(lang string) *i18n.Localizer {
+	localizersLock.Lock()
+	defer localizersLock.Unlock()
+	if bundle == nil {
+		return nil
+	}
+	localizer, ok := localizers[lang]
+	if !ok {
+		localizer = i18n.NewLocalizer(bundle, lang, fallbackLang)
+		localizers[lang] = localizer
+	}
+	return localizer
+}
+
+// Localize translates key to lang, params are name==value pairs for the {{ .name }} of the message.
+// The key itself is returned when no translation has it
+func Localize(lang string, key string, params ...string) string {
+	localizer := getLocalizer(lang)
+	if localizer == nil {
+		return key
+	}
+	data := map[string]interface{}{}
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "==")
+		data[name] = value
+	}
+	message, err := localizer.Localize(&i18n.LocalizeConfig{
+		MessageID:    key,
+		TemplateData: data,
+	})
+	if err != nil {
+		logger.Warning("localize", key, "failed:", err)
+		return key
+	}
+	return message
+}
@@ -0,0 +1,100 @@
+package locale
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/text/language"
+)
+
+const translationDir = "translation"
+
+// literal keys passed to Tr or SendTrToAdmins, keys built at runtime like "action"+name are not matched
+var trKeyRegex = regexp.MustCompile(`\b(?:Tr|SendTrToAdmins)\("([A-Za-z0-9_]+)"\s*[,)]`)
+
+// loadKeys returns the message ids of every translation by language
+func loadKeys(t *testing.T) map[string]map[string]bool {
+	fsys := os.DirFS("..")
+	b := i18n.NewBundle(language.SimplifiedChinese)
+	b.RegisterUnmarshalFunc("toml", toml.Unmarshal)
+	keys := map[string]map[string]bool{}
+	err := fs.WalkDir(fsys, translationDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		file, err := b.ParseMessageFileBytes(data, path)
+		if err != nil {
+			return err
+		}
+		lang := file.Tag.String()
+		if keys[lang] == nil {
+			keys[lang] = map[string]bool{}
+		}
+		for _, message := range file.Messages {
+			keys[lang][message.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestTranslationsComplete(t *testing.T) {
+	err := InitLocalizer(os.DirFS(".."), translationDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(GetLanguages()) < 2 {
+		t.Fatalf("loaded translations %v", GetLanguages())
+	}
+
+	for lang, missing := range MissingKeys(loadKeys(t)) {
+		t.Errorf("translation %v misses %v keys: %v", lang, len(missing), strings.Join(missing, ", "))
+	}
+}
+
+func TestTgbotKeys(t *testing.T) {
+	keys := loadKeys(t)
+	used := map[string]string{}
+	for _, dir := range []string{"../service", "../job"} {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			for _, match := range trKeyRegex.FindAllStringSubmatch(string(data), -1) {
+				used[match[1]] = path
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(used) == 0 {
+		t.Fatal("no translated telegram messages found")
+	}
+
+	for key, path := range used {
+		for lang, langKeys := range keys {
+			if !langKeys["tgbot."+key] {
+				t.Errorf("%v uses %q, missing from [tgbot] of %v", path, key, lang)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const accessStatRetention = 30 * 24 * time.Hour
+
+type DestinationStat struct {
+	Destination string `json:"destination"`
+	Port        int    `json:"port"`
+	Outbound    string `json:"outbound"`
+	Accepted    int64  `json:"accepted"`
+	Rejected    int64  `json:"rejected"`
+	Total       int64  `json:"total"`
+}
+
+type DestinationQuery struct {
+	Email   string `json:"email" form:"email"`
+	Inbound string `json:"inbound" form:"inbound"`
+	From    int64  `json:"from" form:"from"`
+	To      int64  `json:"to" form:"to"`
+	Limit   int    `json:"limit" form:"limit"`
+}
+
+type AccessLogService struct {
+}
+
+func (s *AccessLogService) AddAccessLogs(accessLogs []*xray.AccessLog) (err error) {
+	if len(accessLogs) == 0 {
+		return nil
+	}
+
+	type statKey struct {
+		hour        int64
+		email       string
+		inbound     string
+		outbound    string
+		destination string
+		port        int
+	}
+	stats := make(map[statKey]*model.AccessStat)
+	for _, accessLog := range accessLogs {
+		key := statKey{
+			hour:        accessLog.Time - accessLog.Time%time.Hour.Milliseconds(),
+			email:       accessLog.Email,
+			inbound:     accessLog.Inbound,
+			outbound:    accessLog.Outbound,
+			destination: accessLog.Destination,
+			port:        accessLog.Port,
+		}
+		stat, ok := stats[key]
+		if !ok {
+			stat = &model.AccessStat{
+				Hour:        key.hour,
+				Email:       key.email,
+				Inbound:     key.inbound,
+				Outbound:    key.outbound,
+				Destination: key.destination,
+				Port:        key.port,
+			}
+			stats[key] = stat
+		}
+		if accessLog.Accepted {
+			stat.Accepted++
+		} else {
+			stat.Rejected++
+		}
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for _, stat := range stats {
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "hour"}, {Name: "email"}, {Name: "inbound"}, {Name: "outbound"}, {Name: "destination"}, {Name: "port"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"accepted": gorm.Expr("accepted + ?", stat.Accepted),
+				"rejected": gorm.Expr("rejected + ?", stat.Rejected),
+			}),
+		}).Create(stat).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AccessLogService) ConsumeAccessLogs(accessLogs []*xray.AccessLog) {
+	err := s.AddAccessLogs(accessLogs)
+	if err != nil {
+		logger.Warning("save access stats failed:", err)
+	}
+}
+
+// GetTopDestinations sums hourly buckets of the given client and/or inbound, most used destinations first
+func (s *AccessLogService) GetTopDestinations(query *DestinationQuery) ([]*DestinationStat, error) {
+	db := database.GetDB()
+	tx := db.Model(model.AccessStat{}).
+		Select("destination, port, outbound, sum(accepted) as accepted, sum(rejected) as rejected, sum(accepted) + sum(rejected) as total")
+	if query.Email != "" {
+		tx = tx.Where("email = ?", query.Email)
+	}
+	if query.Inbound != "" {
+		tx = tx.Where("inbound = ?", query.Inbound)
+	}
+	if query.From > 0 {
+		tx = tx.Where("hour >= ?", query.From-query.From%time.Hour.Milliseconds())
+	}
+	if query.To > 0 {
+		tx = tx.Where("hour <= ?", query.To)
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	var stats []*DestinationStat
+	err := tx.Group("destination, port, outbound").Order("total desc").Limit(limit).Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s *AccessLogService) DelOldAccessStats() error {
+	before := time.Now().Add(-accessStatRetention).UnixMilli()
+	db := database.GetDB()
+	return db.Where("hour < ?", before).Delete(model.AccessStat{}).Error
+}
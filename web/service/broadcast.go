@@ -0,0 +1,432 @@
+package service
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// telegram limits of the text of a message and of the caption of a media
+const (
+	tgMaxMsgLength     = 4096
+	tgMaxCaptionLength = 1024
+)
+
+// one broadcast is sent at a time, next to the replies of the bot in the send queue
+var broadcastLock sync.Mutex
+
+type BroadcastService struct {
+	inboundService    InboundService
+	clientPlanService ClientPlanService
+	telegramService   TelegramService
+}
+
+func (s *BroadcastService) GetBroadcasts() ([]*model.Broadcast, error) {
+	db := database.GetDB()
+	var broadcasts []*model.Broadcast
+	err := db.Model(model.Broadcast{}).Order("id desc").Find(&broadcasts).Error
+	if err != nil {
+		return nil, err
+	}
+	return broadcasts, nil
+}
+
+func (s *BroadcastService) GetDeliveries(id int64) ([]*model.BroadcastDelivery, error) {
+	db := database.GetDB()
+	var deliveries []*model.BroadcastDelivery
+	err := db.Model(model.BroadcastDelivery{}).Where("broadcast_id = ?", id).Order("id").Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (s *BroadcastService) checkBroadcast(broadcast *model.Broadcast) error {
+	broadcast.Name = strings.TrimSpace(broadcast.Name)
+	broadcast.Msg = strings.TrimSpace(broadcast.Msg)
+	broadcast.Media = strings.TrimSpace(broadcast.Media)
+	switch broadcast.Segment {
+	case model.BroadcastAll, model.BroadcastActive, model.BroadcastDepleted:
+	case model.BroadcastExpiring:
+		if broadcast.SegmentValue <= 0 {
+			return common.NewError("days of the expiring segment must be positive:", broadcast.SegmentValue)
+		}
+	case model.BroadcastInbound:
+		if _, err := s.inboundService.GetInbound(broadcast.SegmentValue); err != nil {
+			return common.NewError("inbound of the segment not found:", broadcast.SegmentValue)
+		}
+	case model.BroadcastPlan:
+		if _, err := s.clientPlanService.GetPlan(broadcast.SegmentValue); err != nil {
+			return common.NewError("plan of the segment not found:", broadcast.SegmentValue)
+		}
+	default:
+		return common.NewError("unknown broadcast segment:", broadcast.Segment)
+	}
+	switch broadcast.MediaType {
+	case "":
+		if broadcast.Msg == "" {
+			return common.NewError("broadcast has no message:", broadcast.Name)
+		}
+		if utf8.RuneCountInString(broadcast.Msg) > tgMaxMsgLength {
+			return common.NewError("broadcast message is longer than", tgMaxMsgLength)
+		}
+	case "photo", "video", "document":
+		if broadcast.Media == "" {
+			return common.NewError("broadcast has no media:", broadcast.Name)
+		}
+		if utf8.RuneCountInString(broadcast.Msg) > tgMaxCaptionLength {
+			return common.NewError("broadcast caption is longer than", tgMaxCaptionLength)
+		}
+	default:
+		return common.NewError("unknown broadcast media type:", broadcast.MediaType)
+	}
+	return nil
+}
+
+// AddBroadcast schedules the broadcast, it starts at once when it is due
+func (s *BroadcastService) AddBroadcast(broadcast *model.Broadcast) error {
+	err := s.checkBroadcast(broadcast)
+	if err != nil {
+		return err
+	}
+	broadcast.Id = 0
+	broadcast.Status = model.BroadcastScheduled
+	broadcast.Total = 0
+	broadcast.Sent = 0
+	broadcast.Failed = 0
+	broadcast.FinishedAt = 0
+	db := database.GetDB()
+	err = db.Create(broadcast).Error
+	if err != nil {
+		return err
+	}
+	if broadcast.SendAt <= time.Now().UnixMilli() {
+		go s.RunDue()
+	}
+	return nil
+}
+
+// CancelBroadcast stops a broadcast that is scheduled or being sent, its deliveries left stay pending
+func (s *BroadcastService) CancelBroadcast(id int64) error {
+	db := database.GetDB()
+	result := db.Model(model.Broadcast{}).
+		Where("id = ? AND status IN ?", id, []string{model.BroadcastScheduled, model.BroadcastSending}).
+		Updates(map[string]interface{}{"status": model.BroadcastCancelled, "finished_at": time.Now().UnixMilli()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("broadcast is not scheduled or sending:", id)
+	}
+	return nil
+}
+
+func (s *BroadcastService) DelBroadcast(id int64) error {
+	db := database.GetDB()
+	broadcast := &model.Broadcast{}
+	err := db.Model(model.Broadcast{}).First(broadcast, id).Error
+	if err != nil {
+		return err
+	}
+	if broadcast.Status == model.BroadcastSending {
+		return common.NewError("cancel the broadcast before deleting it:", id)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("broadcast_id = ?", id).Delete(model.BroadcastDelivery{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(model.Broadcast{}, id).Error
+	})
+}
+
+// RunDue sends the broadcast being sent, which was stopped by a restart, and then the scheduled ones that are
+// due, one after the other. It returns at once while another call is sending
+func (s *BroadcastService) RunDue() {
+	if !broadcastLock.TryLock() {
+		return
+	}
+	defer broadcastLock.Unlock()
+
+	db := database.GetDB()
+	for isRunning {
+		broadcast := &model.Broadcast{}
+		err := db.Model(model.Broadcast{}).Where("status = ?", model.BroadcastSending).Order("id").First(broadcast).Error
+		if err == gorm.ErrRecordNotFound {
+			err = db.Model(model.Broadcast{}).
+				Where("status = ? AND send_at <= ?", model.BroadcastScheduled, time.Now().UnixMilli()).
+				Order("send_at, id").First(broadcast).Error
+			if err == nil {
+				err = s.start(broadcast)
+			}
+		}
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				logger.Warning("run broadcast failed:", err)
+			}
+			return
+		}
+		if !s.send(broadcast) {
+			return
+		}
+	}
+}
+
+// start resolves the recipients of the broadcast into its deliveries
+func (s *BroadcastService) start(broadcast *model.Broadcast) error {
+	deliveries, err := s.recipients(broadcast)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(deliveries) > 0 {
+			err := tx.CreateInBatches(deliveries, 100).Error
+			if err != nil {
+				return err
+			}
+		}
+		broadcast.Status = model.BroadcastSending
+		broadcast.Total = len(deliveries)
+		return tx.Model(broadcast).Updates(map[string]interface{}{"status": broadcast.Status, "total": broadcast.Total}).Error
+	})
+}
+
+// recipients are the telegram clients in the segment of the broadcast, each with its first account in it
+func (s *BroadcastService) recipients(broadcast *model.Broadcast) ([]*model.BroadcastDelivery, error) {
+	clients, err := s.telegramService.GetTgClients()
+	if err != nil {
+		return nil, err
+	}
+	var planChats map[int64]bool
+	if broadcast.Segment == model.BroadcastPlan {
+		planChats, err = s.planChats(broadcast.SegmentValue)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	deliveries := make([]*model.BroadcastDelivery, 0, len(clients))
+	for _, client := range clients {
+		uids := client.GetUids()
+		uid, ok := "", false
+		switch broadcast.Segment {
+		case model.BroadcastAll:
+			ok = true
+		case model.BroadcastPlan:
+			ok = planChats[client.ChatID]
+		default:
+			for _, u := range uids {
+				traffic, err := s.inboundService.SearchClientTraffic(u)
+				if err == nil && traffic != nil && broadcastMatch(broadcast, traffic, now) {
+					uid, ok = u, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if uid == "" && len(uids) > 0 {
+			uid = uids[0]
+		}
+		deliveries = append(deliveries, &model.BroadcastDelivery{
+			BroadcastId: broadcast.Id,
+			ChatID:      client.ChatID,
+			Uid:         uid,
+			Status:      model.DeliveryPending,
+		})
+	}
+	return deliveries, nil
+}
+
+// planChats are the chats that bought the plan with an approved request or a paid payment
+func (s *BroadcastService) planChats(planId int) (map[int64]bool, error) {
+	db := database.GetDB()
+	var requestChats, paymentChats []int64
+	err := db.Model(model.TgClientMsg{}).Where("plan_id = ? AND status = ?", planId, model.RequestApproved).
+		Pluck("chat_id", &requestChats).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(model.Payment{}).Where("plan_id = ? AND status = ?", planId, model.PaymentPaid).
+		Pluck("chat_id", &paymentChats).Error
+	if err != nil {
+		return nil, err
+	}
+	chats := make(map[int64]bool, len(requestChats)+len(paymentChats))
+	for _, chatId := range append(requestChats, paymentChats...) {
+		chats[chatId] = true
+	}
+	return chats, nil
+}
+
+func broadcastMatch(broadcast *model.Broadcast, traffic *xray.ClientTraffic, now int64) bool {
+	depleted := !traffic.Enable || traffic.IsDepleted() || (traffic.ExpiryTime > 0 && traffic.ExpiryTime <= now)
+	switch broadcast.Segment {
+	case model.BroadcastActive:
+		return !depleted
+	case model.BroadcastDepleted:
+		return depleted
+	case model.BroadcastExpiring:
+		return !depleted && traffic.ExpiryTime > 0 && traffic.ExpiryTime <= now+int64(broadcast.SegmentValue)*86400000
+	case model.BroadcastInbound:
+		return traffic.InboundId == broadcast.SegmentValue
+	}
+	return false
+}
+
+// send delivers the pending deliveries of the broadcast one by one through the send queue, it returns false when
+// it stops before the broadcast is done
+func (s *BroadcastService) send(broadcast *model.Broadcast) bool {
+	db := database.GetDB()
+	for {
+		var status string
+		err := db.Model(model.Broadcast{}).Where("id = ?", broadcast.Id).Pluck("status", &status).Error
+		if err != nil {
+			logger.Warning("send broadcast failed:", err)
+			return false
+		}
+		if status != model.BroadcastSending {
+			return true
+		}
+		if !isRunning {
+			return false
+		}
+
+		delivery := &model.BroadcastDelivery{}
+		err = db.Model(model.BroadcastDelivery{}).
+			Where("broadcast_id = ? AND status = ?", broadcast.Id, model.DeliveryPending).
+			Order("id").First(delivery).Error
+		if err == gorm.ErrRecordNotFound {
+			err = db.Model(broadcast).Updates(map[string]interface{}{
+				"status":      model.BroadcastDone,
+				"finished_at": time.Now().UnixMilli(),
+			}).Error
+			if err != nil {
+				logger.Warning("finish broadcast failed:", err)
+			}
+			return true
+		}
+		if err != nil {
+			logger.Warning("send broadcast failed:", err)
+			return false
+		}
+
+		msg, err := s.message(broadcast, delivery)
+		if err == nil {
+			err = tgSendWait(msg)
+		}
+		delivery.Status = model.DeliverySent
+		delivery.Error = ""
+		counter := "sent"
+		if err != nil {
+			delivery.Status = model.DeliveryFailed
+			delivery.Error = err.Error()
+			counter = "failed"
+		}
+		delivery.SentAt = time.Now().UnixMilli()
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Save(delivery).Error
+			if err != nil {
+				return err
+			}
+			return tx.Model(broadcast).Update(counter, gorm.Expr(counter+" + 1")).Error
+		})
+		if err != nil {
+			logger.Warning("save broadcast delivery failed:", err)
+			return false
+		}
+	}
+}
+
+// message renders the broadcast for the recipient of delivery, media are sent by url or by telegram file id.
+// The placeholders may make the text longer than telegram allows, so the length is checked again.
+func (s *BroadcastService) message(broadcast *model.Broadcast, delivery *model.BroadcastDelivery) (tgbotapi.Chattable, error) {
+	text := s.render(broadcast.Msg, delivery.ChatID, delivery.Uid)
+	maxLength := tgMaxMsgLength
+	if broadcast.MediaType != "" {
+		maxLength = tgMaxCaptionLength
+	}
+	if utf8.RuneCountInString(text) > maxLength {
+		return nil, common.NewError("rendered broadcast is longer than", maxLength)
+	}
+	var file tgbotapi.RequestFileData = tgbotapi.FileID(broadcast.Media)
+	if strings.HasPrefix(broadcast.Media, "http://") || strings.HasPrefix(broadcast.Media, "https://") {
+		file = tgbotapi.FileURL(broadcast.Media)
+	}
+	switch broadcast.MediaType {
+	case "photo":
+		msg := tgbotapi.NewPhoto(delivery.ChatID, file)
+		msg.Caption = text
+		msg.ParseMode = tgbotapi.ModeHTML
+		return msg, nil
+	case "video":
+		msg := tgbotapi.NewVideo(delivery.ChatID, file)
+		msg.Caption = text
+		msg.ParseMode = tgbotapi.ModeHTML
+		return msg, nil
+	case "document":
+		msg := tgbotapi.NewDocument(delivery.ChatID, file)
+		msg.Caption = text
+		msg.ParseMode = tgbotapi.ModeHTML
+		return msg, nil
+	}
+	msg := tgbotapi.NewMessage(delivery.ChatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	return msg, nil
+}
+
+// render fills the placeholders of msg: <UUID> and <CHAT_ID> like the other messages of the bot, <NAME> of the
+// telegram client, and <EMAIL>, <USED>, <TOTAL> and <EXPIRY> of its account. The values are escaped because the
+// message is sent as HTML.
+func (s *BroadcastService) render(msg string, chatId int64, uid string) string {
+	msg = s.telegramService.replaceMarkup(&msg, chatId, html.EscapeString(uid))
+	name, lang := "", defaultLang
+	if client, err := s.telegramService.getTgClient(chatId); err == nil {
+		name = client.Name
+		if client.Language != "" {
+			lang = client.Language
+		}
+	}
+	email, used, total, expiry := "", "", "", ""
+	if uid != "" {
+		traffic, err := s.inboundService.SearchClientTraffic(uid)
+		if err == nil && traffic != nil {
+			email = traffic.Email
+			used = common.FormatTraffic(traffic.Up + traffic.Down)
+			total = Tr("unlimited", lang)
+			if traffic.Total > 0 {
+				total = common.FormatTraffic(traffic.Total)
+			}
+			switch {
+			case traffic.ExpiryTime == 0:
+				expiry = Tr("unlimited", lang)
+			case traffic.ExpiryTime < 0:
+				expiry = Tr("days", lang, "Days=="+strconv.FormatInt(traffic.ExpiryTime/-86400000, 10))
+			default:
+				expiry = time.UnixMilli(traffic.ExpiryTime).Format("2006-01-02 15:04:05")
+			}
+		}
+	}
+	replacer := strings.NewReplacer(
+		"<NAME>", html.EscapeString(name),
+		"<EMAIL>", html.EscapeString(email),
+		"<USED>", html.EscapeString(used),
+		"<TOTAL>", html.EscapeString(total),
+		"<EXPIRY>", html.EscapeString(expiry))
+	return replacer.Replace(msg)
+}
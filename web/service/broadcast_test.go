@@ -0,0 +1,43 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"x-ui/database"
+	"x-ui/database/model"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestBroadcastMessageEscapesPlaceholders(t *testing.T) {
+	err := database.InitDB("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if db, err := database.GetDB().DB(); err == nil {
+			db.Close()
+		}
+	})
+	err = database.GetDB().Create(&model.TgClient{ChatID: 101, Name: "Tom & <Jerry>"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &BroadcastService{}
+	delivery := &model.BroadcastDelivery{ChatID: 101}
+	msg, err := s.message(&model.Broadcast{Msg: "<b>Hi <NAME></b>"}, delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := msg.(tgbotapi.MessageConfig).Text; text != "<b>Hi Tom &amp; &lt;Jerry&gt;</b>" {
+		t.Errorf("rendered %q", text)
+	}
+
+	// the caption fits before the name is filled in
+	caption := strings.Repeat("x", tgMaxCaptionLength-len("<NAME>")) + "<NAME>"
+	_, err = s.message(&model.Broadcast{Msg: caption, MediaType: "photo", Media: "file-id"}, delivery)
+	if err == nil {
+		t.Error("a caption longer than telegram allows after rendering was accepted")
+	}
+}
@@ -0,0 +1,457 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/util/random"
+	"x-ui/xray"
+
+	"github.com/xtls/xray-core/common/uuid"
+	"gorm.io/gorm"
+)
+
+const bulkMaxQuantity = 500
+
+type BulkAddRequest struct {
+	InboundId    int    `json:"inboundId" form:"inboundId"`
+	Quantity     int    `json:"quantity" form:"quantity"`
+	FirstNum     int    `json:"firstNum" form:"firstNum"`
+	EmailPrefix  string `json:"emailPrefix" form:"emailPrefix"`
+	EmailPostfix string `json:"emailPostfix" form:"emailPostfix"`
+	LimitIP      int    `json:"limitIp" form:"limitIp"`
+	TotalGB      int64  `json:"totalGB" form:"totalGB"`
+	ExpiryTime   int64  `json:"expiryTime" form:"expiryTime"`
+	Flow         string `json:"flow" form:"flow"`
+	TgId         string `json:"tgId" form:"tgId"`
+	SubId        string `json:"subId" form:"subId"`
+}
+
+// ClientFilter selects clients for bulk operations, empty fields match everything
+// but a filter needs at least one condition so a request can not hit every client by accident
+type ClientFilter struct {
+	InboundId   int      `json:"inboundId" form:"inboundId"`
+	Emails      []string `json:"emails" form:"emails"`
+	Expired     bool     `json:"expired" form:"expired"`
+	Depleted    bool     `json:"depleted" form:"depleted"`
+	TgId        string   `json:"tgId" form:"tgId"`
+	SubIdPrefix string   `json:"subIdPrefix" form:"subIdPrefix"`
+	All         bool     `json:"all" form:"all"`
+}
+
+type BulkClientRequest struct {
+	ClientFilter
+	Days            int   `json:"days" form:"days"`
+	Traffic         int64 `json:"traffic" form:"traffic"`
+	Enable          bool  `json:"enable" form:"enable"`
+	TargetInboundId int   `json:"targetInboundId" form:"targetInboundId"`
+	Level           int   `json:"level" form:"level"`
+}
+
+type ClientBulkService struct {
+	inboundService InboundService
+}
+
+func newUUID() string {
+	id := uuid.New()
+	return id.String()
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return 0
+}
+
+func (f *ClientFilter) check() error {
+	if !f.All && f.InboundId == 0 && len(f.Emails) == 0 && !f.Expired && !f.Depleted && f.TgId == "" && f.SubIdPrefix == "" {
+		return common.NewError("client filter is empty")
+	}
+	return nil
+}
+
+func (f *ClientFilter) match(client map[string]interface{}, traffic *xray.ClientTraffic, now int64) bool {
+	email, _ := client["email"].(string)
+	if len(f.Emails) > 0 && !common.IsSubString(email, f.Emails) {
+		return false
+	}
+	if f.TgId != "" {
+		if tgId, _ := client["tgId"].(string); tgId != f.TgId {
+			return false
+		}
+	}
+	if f.SubIdPrefix != "" {
+		if subId, _ := client["subId"].(string); !strings.HasPrefix(subId, f.SubIdPrefix) {
+			return false
+		}
+	}
+	if f.Expired {
+		expiryTime := toInt64(client["expiryTime"])
+		if expiryTime <= 0 || expiryTime > now {
+			return false
+		}
+	}
+	if f.Depleted {
+		if traffic == nil || !traffic.IsDepleted() {
+			return false
+		}
+	}
+	return true
+}
+
+// eachClient calls fn for the clients matching filter and saves their inbounds, fn returns false to remove the client
+func (s *ClientBulkService) eachClient(tx *gorm.DB, filter *ClientFilter,
+	fn func(inbound *model.Inbound, client map[string]interface{}, traffic *xray.ClientTraffic) (bool, error)) (int, error) {
+	err := filter.check()
+	if err != nil {
+		return 0, err
+	}
+
+	var inbounds []*model.Inbound
+	query := tx.Model(model.Inbound{}).Preload("ClientStats")
+	if filter.InboundId > 0 {
+		query = query.Where("id = ?", filter.InboundId)
+	}
+	err = query.Find(&inbounds).Error
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UnixMilli()
+	count := 0
+	for _, inbound := range inbounds {
+		var settings map[string]interface{}
+		err = json.Unmarshal([]byte(inbound.Settings), &settings)
+		if err != nil {
+			return 0, err
+		}
+		clients, ok := settings["clients"].([]interface{})
+		if !ok {
+			continue
+		}
+		traffics := make(map[string]*xray.ClientTraffic, len(inbound.ClientStats))
+		for i := range inbound.ClientStats {
+			traffics[inbound.ClientStats[i].Email] = &inbound.ClientStats[i]
+		}
+
+		modified := false
+		newClients := make([]interface{}, 0, len(clients))
+		for _, client := range clients {
+			c, ok := client.(map[string]interface{})
+			if !ok {
+				newClients = append(newClients, client)
+				continue
+			}
+			email, _ := c["email"].(string)
+			if !filter.match(c, traffics[email], now) {
+				newClients = append(newClients, client)
+				continue
+			}
+			keep, err := fn(inbound, c, traffics[email])
+			if err != nil {
+				return 0, err
+			}
+			if keep {
+				newClients = append(newClients, c)
+			}
+			modified = true
+			count++
+		}
+		if !modified {
+			continue
+		}
+
+		settings["clients"] = newClients
+		newSettings, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", string(newSettings)).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (s *ClientBulkService) transaction(fn func(tx *gorm.DB) (int, error)) (count int, err error) {
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	return fn(tx)
+}
+
+// AddClients creates quantity clients on one inbound, emails are numbered from FirstNum or random when it is 0
+func (s *ClientBulkService) AddClients(req *BulkAddRequest) ([]model.Client, error) {
+	if req.Quantity < 1 || req.Quantity > bulkMaxQuantity {
+		return nil, common.NewErrorf("quantity must be between 1 and %v", bulkMaxQuantity)
+	}
+
+	clients := make([]model.Client, 0, req.Quantity)
+	// the inbound is read in the transaction so that clients added to it meanwhile are not overwritten
+	_, err := s.transaction(func(tx *gorm.DB) (int, error) {
+		inbound := &model.Inbound{}
+		err := tx.Model(model.Inbound{}).First(inbound, req.InboundId).Error
+		if err != nil {
+			return 0, err
+		}
+
+		emails := make(map[string]bool, req.Quantity)
+		for i := 0; i < req.Quantity; i++ {
+			name := strings.ToLower(random.Seq(8))
+			if req.FirstNum > 0 {
+				name = fmt.Sprint(req.FirstNum + i)
+			}
+			client := model.Client{
+				Email:      req.EmailPrefix + name + req.EmailPostfix,
+				LimitIP:    req.LimitIP,
+				TotalGB:    req.TotalGB,
+				ExpiryTime: req.ExpiryTime,
+				Enable:     true,
+				TgID:       req.TgId,
+				SubID:      req.SubId,
+			}
+			if emails[client.Email] {
+				return 0, common.NewError("Duplicate email in the batch:", client.Email)
+			}
+			emails[client.Email] = true
+			if client.SubID == "" {
+				client.SubID = strings.ToLower(random.Seq(16))
+			}
+			switch inbound.Protocol {
+			case model.VMess:
+				client.ID = newUUID()
+			case model.VLESS:
+				client.ID = newUUID()
+				client.Flow = req.Flow
+			case model.Trojan:
+				client.Password = random.Seq(10)
+			default:
+				return 0, common.NewErrorf("inbound %v of protocol %v has no clients", inbound.Remark, inbound.Protocol)
+			}
+			clients = append(clients, client)
+		}
+
+		existEmail, err := s.inboundService.checkEmailsExistForClients(tx, clients)
+		if err != nil {
+			return 0, err
+		}
+		if existEmail != "" {
+			return 0, common.NewError("Duplicate email:", existEmail)
+		}
+
+		var settings map[string]interface{}
+		err = json.Unmarshal([]byte(inbound.Settings), &settings)
+		if err != nil {
+			return 0, err
+		}
+		oldClients, _ := settings["clients"].([]interface{})
+		for _, client := range clients {
+			oldClients = append(oldClients, client)
+		}
+		settings["clients"] = oldClients
+		newSettings, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+
+		err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", string(newSettings)).Error
+		if err != nil {
+			return 0, err
+		}
+		for _, client := range clients {
+			err = tx.Create(&xray.ClientTraffic{
+				InboundId:  inbound.Id,
+				Email:      client.Email,
+				Total:      client.TotalGB,
+				UpLimit:    client.UpLimit,
+				DownLimit:  client.DownLimit,
+				ExpiryTime: client.ExpiryTime,
+				Enable:     true,
+			}).Error
+			if err != nil {
+				return 0, err
+			}
+		}
+		return len(clients), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// ExtendClients adds days to the expiry and traffic to the quota of the matching clients,
+// unlimited ones stay unlimited and expired ones are extended from now. Disabled clients are only enabled again
+// when they were disabled by their quota or expiry and the extension covers it, the ones an admin disabled stay so.
+func (s *ClientBulkService) ExtendClients(req *BulkClientRequest) (int, error) {
+	if req.Days < 0 || req.Traffic < 0 || (req.Days == 0 && req.Traffic == 0) {
+		return 0, common.NewError("nothing to extend")
+	}
+	extend := int64(req.Days) * 24 * time.Hour.Milliseconds()
+	now := time.Now().UnixMilli()
+	return s.transaction(func(tx *gorm.DB) (int, error) {
+		return s.eachClient(tx, &req.ClientFilter, func(inbound *model.Inbound, c map[string]interface{}, traffic *xray.ClientTraffic) (bool, error) {
+			oldExpiryTime := toInt64(c["expiryTime"])
+			expiryTime := oldExpiryTime
+			if expiryTime < 0 {
+				// delayed start, the negative value is the duration after the first use
+				expiryTime -= extend
+			} else if expiryTime > 0 {
+				if expiryTime < now {
+					expiryTime = now
+				}
+				expiryTime += extend
+			}
+			totalGB := toInt64(c["totalGB"])
+			if totalGB > 0 {
+				totalGB += req.Traffic
+			}
+
+			enable, _ := c["enable"].(bool)
+			if traffic != nil {
+				enable = traffic.Enable
+				expired := oldExpiryTime > 0 && oldExpiryTime <= now
+				if !enable && (traffic.IsDepleted() || expired) {
+					extended := *traffic
+					extended.Total = totalGB
+					enable = !extended.IsDepleted() && (expiryTime <= 0 || expiryTime > now)
+				}
+			}
+			c["expiryTime"] = expiryTime
+			c["totalGB"] = totalGB
+			c["enable"] = enable
+			return true, tx.Model(xray.ClientTraffic{}).Where("email = ?", c["email"]).Updates(map[string]interface{}{
+				"enable":      enable,
+				"total":       totalGB,
+				"expiry_time": expiryTime,
+			}).Error
+		})
+	})
+}
+
+func (s *ClientBulkService) SetClientsEnable(req *BulkClientRequest) (int, error) {
+	return s.transaction(func(tx *gorm.DB) (int, error) {
+		return s.eachClient(tx, &req.ClientFilter, func(inbound *model.Inbound, c map[string]interface{}, traffic *xray.ClientTraffic) (bool, error) {
+			c["enable"] = req.Enable
+			return true, tx.Model(xray.ClientTraffic{}).Where("email = ?", c["email"]).Update("enable", req.Enable).Error
+		})
+	})
+}
+
+// SetClientsLevel moves the matching clients to a policy level, 0 is the default level of the config template
+func (s *ClientBulkService) SetClientsLevel(req *BulkClientRequest) (int, error) {
+	if req.Level != 0 {
+		var count int64
+		db := database.GetDB()
+		err := db.Model(model.PolicyLevel{}).Where("level = ?", req.Level).Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		if count == 0 {
+			return 0, common.NewError("policy level not found:", req.Level)
+		}
+	}
+	return s.transaction(func(tx *gorm.DB) (int, error) {
+		return s.eachClient(tx, &req.ClientFilter, func(inbound *model.Inbound, c map[string]interface{}, traffic *xray.ClientTraffic) (bool, error) {
+			c["level"] = req.Level
+			return true, nil
+		})
+	})
+}
+
+func (s *ClientBulkService) DelClients(req *BulkClientRequest) (int, error) {
+	return s.transaction(func(tx *gorm.DB) (int, error) {
+		return s.eachClient(tx, &req.ClientFilter, func(inbound *model.Inbound, c map[string]interface{}, traffic *xray.ClientTraffic) (bool, error) {
+			email, _ := c["email"].(string)
+			err := s.inboundService.DelClientStat(tx, email)
+			if err != nil {
+				return false, err
+			}
+			return false, s.inboundService.DelClientIPs(tx, email)
+		})
+	})
+}
+
+// MoveClients moves the matching clients to another inbound keeping their emails and traffic,
+// credentials are carried over when the protocols allow it
+func (s *ClientBulkService) MoveClients(req *BulkClientRequest) (int, error) {
+	target, err := s.inboundService.GetInbound(req.TargetInboundId)
+	if err != nil {
+		return 0, err
+	}
+	switch target.Protocol {
+	case model.VMess, model.VLESS, model.Trojan:
+	default:
+		return 0, common.NewErrorf("inbound %v of protocol %v has no clients", target.Remark, target.Protocol)
+	}
+
+	return s.transaction(func(tx *gorm.DB) (int, error) {
+		moved := make([]interface{}, 0)
+		_, err := s.eachClient(tx, &req.ClientFilter, func(inbound *model.Inbound, c map[string]interface{}, traffic *xray.ClientTraffic) (bool, error) {
+			if inbound.Id == target.Id {
+				return true, nil
+			}
+			// the other protocols use the id or the password as the credential
+			id, _ := c["id"].(string)
+			password, _ := c["password"].(string)
+			switch target.Protocol {
+			case model.Trojan:
+				if password == "" {
+					c["password"] = id
+				}
+				delete(c, "flow")
+			case model.VMess, model.VLESS:
+				if id == "" {
+					id = newUUID()
+					if parsed, err := uuid.ParseString(password); err == nil {
+						id = parsed.String()
+					}
+					c["id"] = id
+				}
+				if target.Protocol == model.VMess {
+					delete(c, "flow")
+				}
+			}
+			moved = append(moved, c)
+			return false, tx.Model(xray.ClientTraffic{}).Where("email = ?", c["email"]).Update("inbound_id", target.Id).Error
+		})
+		if err != nil || len(moved) == 0 {
+			return 0, err
+		}
+
+		// the target may have been changed by eachClient, so it is read again
+		err = tx.Model(model.Inbound{}).First(target, target.Id).Error
+		if err != nil {
+			return 0, err
+		}
+		var settings map[string]interface{}
+		err = json.Unmarshal([]byte(target.Settings), &settings)
+		if err != nil {
+			return 0, err
+		}
+		clients, _ := settings["clients"].([]interface{})
+		settings["clients"] = append(clients, moved...)
+		newSettings, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		return len(moved), tx.Model(model.Inbound{}).Where("id = ?", target.Id).Update("settings", string(newSettings)).Error
+	})
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func TestExtendClientsKeepsDisabledClients(t *testing.T) {
+	err := database.InitDB("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if db, err := database.GetDB().DB(); err == nil {
+			db.Close()
+		}
+	})
+
+	db := database.GetDB()
+	err = db.Create(&model.Inbound{
+		Port:     20000,
+		Protocol: model.VLESS,
+		Enable:   true,
+		Tag:      "inbound-20000",
+		Settings: `{"clients":[
+			{"id":"1","email":"admin","enable":false,"totalGB":100,"expiryTime":0},
+			{"id":"2","email":"depleted","enable":false,"totalGB":100,"expiryTime":0}
+		],"decryption":"none"}`,
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffics := []*xray.ClientTraffic{
+		{InboundId: 1, Email: "admin", Up: 10, Down: 10, Total: 100},
+		{InboundId: 1, Email: "depleted", Up: 50, Down: 50, Total: 100},
+	}
+	for _, traffic := range traffics {
+		err = db.Create(traffic).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &ClientBulkService{}
+	_, err = s.ExtendClients(&BulkClientRequest{
+		ClientFilter: ClientFilter{Emails: []string{"admin", "depleted"}},
+		Traffic:      100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"admin": false, "depleted": true}
+	for email, enable := range want {
+		traffic := &xray.ClientTraffic{}
+		err = db.Where("email = ?", email).First(traffic).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if traffic.Enable != enable {
+			t.Errorf("client %v is enabled %v after the extension, want %v", email, traffic.Enable, enable)
+		}
+		if traffic.Total != 200 {
+			t.Errorf("client %v has a quota of %v after the extension, want 200", email, traffic.Total)
+		}
+	}
+}
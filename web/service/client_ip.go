@@ -0,0 +1,189 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/mmdb"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// the optional ASN database, e.g. GeoLite2-ASN.mmdb, is looked up next to the geo files
+const asnDatabaseName = "GeoLite2-ASN.mmdb"
+
+var ipLookupLock sync.Mutex
+var geoIPLookup *xray.GeoIPLookup
+var geoIPModTime time.Time
+var asnReader *mmdb.Reader
+var asnModTime time.Time
+
+type SharedIp struct {
+	Ip      string   `json:"ip"`
+	Emails  []string `json:"emails"`
+	Country string   `json:"country"`
+	Asn     string   `json:"asn"`
+}
+
+type ClientIpService struct {
+}
+
+// modTime returns the modification time of path, zero when it does not exist
+func modTime(path string) time.Time {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
+
+// refreshIpLookups reloads the geoip and ASN databases when their files change, the caller must hold ipLookupLock
+func (s *ClientIpService) refreshIpLookups() {
+	geoIPPath := xray.GetGeoipPath()
+	if t := modTime(geoIPPath); !t.Equal(geoIPModTime) {
+		geoIPModTime = t
+		geoIPLookup = nil
+		if !t.IsZero() {
+			lookup, err := xray.LoadGeoIPLookup(geoIPPath)
+			if err != nil {
+				logger.Warning("load geoip for client ips failed:", err)
+			} else {
+				geoIPLookup = lookup
+			}
+		}
+	}
+
+	asnPath := xray.GetGeoAssetPath(asnDatabaseName)
+	if t := modTime(asnPath); !t.Equal(asnModTime) {
+		asnModTime = t
+		asnReader = nil
+		if !t.IsZero() {
+			reader, err := mmdb.Open(asnPath)
+			if err != nil {
+				logger.Warning("load asn database failed:", err)
+			} else {
+				asnReader = reader
+			}
+		}
+	}
+}
+
+// LookupIp returns the country code and the "AS<number> <organization>" of ip when the databases know them
+func (s *ClientIpService) LookupIp(ip string) (country string, asn string) {
+	ipLookupLock.Lock()
+	defer ipLookupLock.Unlock()
+	s.refreshIpLookups()
+
+	if geoIPLookup != nil {
+		country = geoIPLookup.Country(ip)
+	}
+	if asnReader != nil {
+		record, err := asnReader.Lookup(ip)
+		if m, ok := record.(map[string]interface{}); err == nil && ok {
+			if number, ok := m["autonomous_system_number"]; ok {
+				asn = fmt.Sprintf("AS%v", number)
+				if org, ok := m["autonomous_system_organization"].(string); ok {
+					asn += " " + org
+				}
+			}
+		}
+	}
+	return country, asn
+}
+
+// AddHistory merges seen ips into the history, new addresses get their location looked up once
+func (s *ClientIpService) AddHistory(histories []*model.ClientIpHistory) (err error) {
+	if len(histories) == 0 {
+		return nil
+	}
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for _, history := range histories {
+		var count int64
+		err = tx.Model(model.ClientIpHistory{}).Where("email = ? and ip = ?", history.Email, history.Ip).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			history.Country, history.Asn = s.LookupIp(history.Ip)
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "email"}, {Name: "ip"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"first_seen": gorm.Expr("min(first_seen, ?)", history.FirstSeen),
+				"last_seen":  gorm.Expr("max(last_seen, ?)", history.LastSeen),
+				"hits":       gorm.Expr("hits + ?", history.Hits),
+			}),
+		}).Create(history).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ClientIpService) GetClientIpHistory(email string) ([]*model.ClientIpHistory, error) {
+	db := database.GetDB()
+	var histories []*model.ClientIpHistory
+	err := db.Model(model.ClientIpHistory{}).Where("email = ?", email).Order("last_seen desc").Find(&histories).Error
+	if err != nil {
+		return nil, err
+	}
+	return histories, nil
+}
+
+// GetIpHistory lists the clients that connected from ip
+func (s *ClientIpService) GetIpHistory(ip string) ([]*model.ClientIpHistory, error) {
+	db := database.GetDB()
+	var histories []*model.ClientIpHistory
+	err := db.Model(model.ClientIpHistory{}).Where("ip = ?", ip).Order("last_seen desc").Find(&histories).Error
+	if err != nil {
+		return nil, err
+	}
+	return histories, nil
+}
+
+// GetSharedIps lists addresses used by more than one client since the given time, a hint of account sharing
+func (s *ClientIpService) GetSharedIps(since int64) ([]*SharedIp, error) {
+	db := database.GetDB()
+	var histories []*model.ClientIpHistory
+	err := db.Model(model.ClientIpHistory{}).
+		Where("last_seen >= ? and ip in (?)", since,
+			db.Model(model.ClientIpHistory{}).Select("ip").Where("last_seen >= ?", since).Group("ip").Having("count(*) > 1")).
+		Order("ip").Find(&histories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	shared := make([]*SharedIp, 0)
+	for _, history := range histories {
+		if len(shared) == 0 || shared[len(shared)-1].Ip != history.Ip {
+			shared = append(shared, &SharedIp{Ip: history.Ip, Country: history.Country, Asn: history.Asn})
+		}
+		last := shared[len(shared)-1]
+		last.Emails = append(last.Emails, history.Email)
+	}
+	return shared, nil
+}
+
+func (s *ClientIpService) UpdateClientIpHistory(tx *gorm.DB, oldEmail string, newEmail string) error {
+	return tx.Model(model.ClientIpHistory{}).Where("email = ?", oldEmail).Update("email", newEmail).Error
+}
+
+func (s *ClientIpService) DelClientIpHistory(tx *gorm.DB, email string) error {
+	return tx.Where("email = ?", email).Delete(model.ClientIpHistory{}).Error
+}
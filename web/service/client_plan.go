@@ -0,0 +1,314 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/util/random"
+)
+
+type PlanClientRequest struct {
+	PlanId int    `json:"planId" form:"planId"`
+	Email  string `json:"email" form:"email"`
+	TgId   string `json:"tgId" form:"tgId"`
+}
+
+type ClientPlanService struct {
+	inboundService  InboundService
+	customerService CustomerService
+}
+
+func (s *ClientPlanService) GetPlans() ([]*model.ClientPlan, error) {
+	db := database.GetDB()
+	var plans []*model.ClientPlan
+	err := db.Model(model.ClientPlan{}).Order("price").Find(&plans).Error
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+func (s *ClientPlanService) GetEnabledPlans() ([]*model.ClientPlan, error) {
+	db := database.GetDB()
+	var plans []*model.ClientPlan
+	err := db.Model(model.ClientPlan{}).Where("enable = ?", true).Order("price").Find(&plans).Error
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+func (s *ClientPlanService) GetPlan(id int) (*model.ClientPlan, error) {
+	db := database.GetDB()
+	plan := &model.ClientPlan{}
+	err := db.Model(model.ClientPlan{}).First(plan, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (s *ClientPlanService) checkPlan(plan *model.ClientPlan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return common.NewError("plan name is empty")
+	}
+	if plan.TotalGB < 0 || plan.Days < 0 || plan.LimitIP < 0 || plan.Price < 0 {
+		return common.NewError("plan values can not be negative:", plan.Name)
+	}
+	inboundIds := plan.GetInboundIds()
+	if len(inboundIds) == 0 {
+		return common.NewError("plan has no inbounds:", plan.Name)
+	}
+	ids := make([]string, 0, len(inboundIds))
+	for _, inboundId := range inboundIds {
+		inbound, err := s.inboundService.GetInbound(inboundId)
+		if err != nil {
+			return common.NewError("plan inbound not found:", inboundId)
+		}
+		switch inbound.Protocol {
+		case model.VMess, model.VLESS, model.Trojan:
+		default:
+			return common.NewErrorf("inbound %v of protocol %v has no clients", inbound.Remark, inbound.Protocol)
+		}
+		ids = append(ids, fmt.Sprint(inboundId))
+	}
+	plan.InboundIds = strings.Join(ids, ",")
+	return nil
+}
+
+func (s *ClientPlanService) AddPlan(plan *model.ClientPlan) error {
+	err := s.checkPlan(plan)
+	if err != nil {
+		return err
+	}
+	plan.Id = 0
+	db := database.GetDB()
+	return db.Create(plan).Error
+}
+
+func (s *ClientPlanService) UpdatePlan(plan *model.ClientPlan) error {
+	err := s.checkPlan(plan)
+	if err != nil {
+		return err
+	}
+	_, err = s.GetPlan(plan.Id)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	return db.Save(plan).Error
+}
+
+func (s *ClientPlanService) DelPlan(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.ClientPlan{}, id).Error
+}
+
+// planExpiryTime extends from base, or from now when base has already passed
+func planExpiryTime(plan *model.ClientPlan, base int64) int64 {
+	if plan.Days == 0 {
+		return 0
+	}
+	now := time.Now().UnixMilli()
+	if base < now {
+		base = now
+	}
+	return base + int64(plan.Days)*24*time.Hour.Milliseconds()
+}
+
+func clientSettings(client model.Client) (string, error) {
+	settings, err := json.Marshal(map[string][]model.Client{"clients": {client}})
+	if err != nil {
+		return "", err
+	}
+	return string(settings), nil
+}
+
+// CreateClientFromPlan adds a client to the inbound of the plan, plans with several inbounds create a customer
+// named after email that owns one client per inbound and the quota and expiry of the plan
+func (s *ClientPlanService) CreateClientFromPlan(planId int, email string, tgId string) ([]model.Client, error) {
+	plan, err := s.GetPlan(planId)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.Enable {
+		return nil, common.NewError("plan is disabled:", plan.Name)
+	}
+	email = strings.TrimSpace(email)
+	if email == "" {
+		email = strings.ToLower(random.Seq(8))
+	}
+
+	inboundIds := plan.GetInboundIds()
+	if len(inboundIds) > 1 {
+		customer := &model.Customer{
+			Name:       email,
+			TgId:       tgId,
+			TotalGB:    plan.TotalGB,
+			ExpiryTime: planExpiryTime(plan, 0),
+			Enable:     true,
+		}
+		err = s.customerService.AddCustomer(customer)
+		if err != nil {
+			return nil, err
+		}
+		clients, err := s.customerService.addClients(customer, inboundIds, plan.Flow, plan.LimitIP)
+		if err != nil {
+			s.customerService.DelCustomer(customer.Id)
+			return nil, err
+		}
+		return clients, nil
+	}
+
+	inbound, err := s.inboundService.GetInbound(inboundIds[0])
+	if err != nil {
+		return nil, err
+	}
+	client := model.Client{
+		Email:      email,
+		LimitIP:    plan.LimitIP,
+		TotalGB:    plan.TotalGB,
+		ExpiryTime: planExpiryTime(plan, 0),
+		Enable:     true,
+		TgID:       tgId,
+		SubID:      strings.ToLower(random.Seq(16)),
+	}
+	switch inbound.Protocol {
+	case model.VMess:
+		client.ID = newUUID()
+	case model.VLESS:
+		client.ID = newUUID()
+		client.Flow = plan.Flow
+	case model.Trojan:
+		client.Password = newUUID()
+	default:
+		return nil, common.NewErrorf("inbound %v of protocol %v has no clients", inbound.Remark, inbound.Protocol)
+	}
+	clients := []model.Client{client}
+
+	existEmail, err := s.inboundService.checkEmailsExistForClients(database.GetDB(), clients)
+	if err != nil {
+		return nil, err
+	}
+	if existEmail != "" {
+		return nil, common.NewError("Duplicate email:", existEmail)
+	}
+
+	settings, err := clientSettings(client)
+	if err != nil {
+		return nil, err
+	}
+	err = s.inboundService.AddInboundClient(&model.Inbound{Id: inbound.Id, Settings: settings})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// RenewClientFromPlan applies the quota and duration of the plan to the client with email and the ones sharing
+// its subscription or to its customer, their traffic is reset and the remaining time is kept
+func (s *ClientPlanService) RenewClientFromPlan(planId int, email string) ([]model.Client, error) {
+	plan, err := s.GetPlan(planId)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.Enable {
+		return nil, common.NewError("plan is disabled:", plan.Name)
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+
+	subId := ""
+	found := false
+	for _, inbound := range inbounds {
+		clients, _ := s.inboundService.getClients(inbound)
+		for _, client := range clients {
+			if client.Email == email {
+				subId = client.SubID
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, common.NewError("client not found:", email)
+	}
+
+	traffic, err := s.inboundService.GetClientTrafficByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if traffic != nil && traffic.CustomerId > 0 {
+		return s.renewCustomer(plan, traffic.CustomerId, inbounds)
+	}
+
+	renewed := make([]model.Client, 0)
+	for _, inbound := range inbounds {
+		clients, _ := s.inboundService.getClients(inbound)
+		for _, client := range clients {
+			if client.Email != email && (subId == "" || client.SubID != subId) {
+				continue
+			}
+			clientId := client.ID
+			if inbound.Protocol == model.Trojan {
+				clientId = client.Password
+			}
+			client.TotalGB = plan.TotalGB
+			client.LimitIP = plan.LimitIP
+			client.ExpiryTime = planExpiryTime(plan, client.ExpiryTime)
+			client.Enable = true
+			if inbound.Protocol == model.VLESS {
+				client.Flow = plan.Flow
+			}
+			settings, err := clientSettings(client)
+			if err != nil {
+				return nil, err
+			}
+			err = s.inboundService.UpdateInboundClient(&model.Inbound{Id: inbound.Id, Settings: settings}, clientId)
+			if err != nil {
+				return nil, err
+			}
+			err = s.inboundService.ResetClientTraffic(inbound.Id, client.Email)
+			if err != nil {
+				return nil, err
+			}
+			renewed = append(renewed, client)
+		}
+	}
+	return renewed, nil
+}
+
+func (s *ClientPlanService) renewCustomer(plan *model.ClientPlan, customerId int, inbounds []*model.Inbound) ([]model.Client, error) {
+	customer, err := s.customerService.GetCustomer(customerId)
+	if err != nil {
+		return nil, err
+	}
+	customer.TotalGB = plan.TotalGB
+	customer.ExpiryTime = planExpiryTime(plan, customer.ExpiryTime)
+	customer.Enable = true
+	err = s.customerService.UpdateCustomer(customer)
+	if err != nil {
+		return nil, err
+	}
+	err = s.customerService.ResetCustomerTraffic(customer.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	renewed := make([]model.Client, 0)
+	for _, inbound := range inbounds {
+		clients, _ := s.inboundService.getClients(inbound)
+		for _, client := range clients {
+			if client.SubID == customer.SubId {
+				renewed = append(renewed, client)
+			}
+		}
+	}
+	return renewed, nil
+}
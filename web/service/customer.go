@@ -0,0 +1,322 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+	"x-ui/util/random"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+type CustomerClientsRequest struct {
+	InboundIds []int    `json:"inboundIds" form:"inboundIds"`
+	Emails     []string `json:"emails" form:"emails"`
+}
+
+type CustomerService struct {
+	inboundService    InboundService
+	clientBulkService ClientBulkService
+}
+
+// fillUsage sums the traffic of the clients of every customer
+func (s *CustomerService) fillUsage(customers []*model.Customer) error {
+	if len(customers) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(customers))
+	for _, customer := range customers {
+		ids = append(ids, customer.Id)
+	}
+	var usages []struct {
+		CustomerId int
+		Up         int64
+		Down       int64
+	}
+	db := database.GetDB()
+	err := db.Model(xray.ClientTraffic{}).
+		Select("customer_id, sum(up) as up, sum(down) as down").
+		Where("customer_id in ?", ids).
+		Group("customer_id").
+		Scan(&usages).Error
+	if err != nil {
+		return err
+	}
+	for _, usage := range usages {
+		for _, customer := range customers {
+			if customer.Id == usage.CustomerId {
+				customer.Up = usage.Up
+				customer.Down = usage.Down
+			}
+		}
+	}
+	return nil
+}
+
+func (s *CustomerService) isValid(customer *model.Customer) bool {
+	if !customer.Enable {
+		return false
+	}
+	if customer.ExpiryTime > 0 && customer.ExpiryTime <= time.Now().UnixMilli() {
+		return false
+	}
+	return customer.TotalGB == 0 || customer.Up+customer.Down < customer.TotalGB
+}
+
+func (s *CustomerService) GetCustomers() ([]*model.Customer, error) {
+	db := database.GetDB()
+	var customers []*model.Customer
+	err := db.Model(model.Customer{}).Find(&customers).Error
+	if err != nil {
+		return nil, err
+	}
+	return customers, s.fillUsage(customers)
+}
+
+func (s *CustomerService) GetCustomer(id int) (*model.Customer, error) {
+	db := database.GetDB()
+	customer := &model.Customer{}
+	err := db.Model(model.Customer{}).First(customer, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return customer, s.fillUsage([]*model.Customer{customer})
+}
+
+func (s *CustomerService) GetCustomerBySubId(subId string) (*model.Customer, error) {
+	db := database.GetDB()
+	customer := &model.Customer{}
+	err := db.Model(model.Customer{}).Where("sub_id = ?", subId).First(customer).Error
+	if err != nil {
+		return nil, err
+	}
+	return customer, s.fillUsage([]*model.Customer{customer})
+}
+
+func (s *CustomerService) GetCustomerClients(id int) ([]*xray.ClientTraffic, error) {
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err := db.Model(xray.ClientTraffic{}).Where("customer_id = ?", id).Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	return traffics, nil
+}
+
+func (s *CustomerService) checkCustomer(customer *model.Customer) error {
+	customer.Name = strings.TrimSpace(customer.Name)
+	if customer.Name == "" {
+		return common.NewError("customer name is empty")
+	}
+	if customer.TotalGB < 0 || customer.ExpiryTime < 0 {
+		return common.NewError("customer limits can not be negative:", customer.Name)
+	}
+	return nil
+}
+
+func (s *CustomerService) AddCustomer(customer *model.Customer) error {
+	err := s.checkCustomer(customer)
+	if err != nil {
+		return err
+	}
+	if customer.SubId == "" {
+		customer.SubId = strings.ToLower(random.Seq(16))
+	}
+	customer.Id = 0
+	db := database.GetDB()
+	return db.Create(customer).Error
+}
+
+// UpdateCustomer saves the limits of a customer and turns its clients on or off accordingly,
+// the subscription id is kept because the clients carry it
+func (s *CustomerService) UpdateCustomer(customer *model.Customer) error {
+	err := s.checkCustomer(customer)
+	if err != nil {
+		return err
+	}
+	oldCustomer, err := s.GetCustomer(customer.Id)
+	if err != nil {
+		return err
+	}
+	customer.SubId = oldCustomer.SubId
+	customer.Up = oldCustomer.Up
+	customer.Down = oldCustomer.Down
+
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Save(customer).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(xray.ClientTraffic{}).Where("customer_id = ?", customer.Id).Update("enable", s.isValid(customer)).Error
+	})
+}
+
+func (s *CustomerService) DelCustomer(id int) error {
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(xray.ClientTraffic{}).Where("customer_id = ?", id).Update("customer_id", 0).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(model.Customer{}, id).Error
+	})
+}
+
+func (s *CustomerService) ResetCustomerTraffic(id int) error {
+	customer, err := s.GetCustomer(id)
+	if err != nil {
+		return err
+	}
+	customer.Up = 0
+	customer.Down = 0
+	db := database.GetDB()
+	return db.Model(xray.ClientTraffic{}).Where("customer_id = ?", id).Updates(map[string]interface{}{
+		"up":     0,
+		"down":   0,
+		"enable": s.isValid(customer),
+	}).Error
+}
+
+// AddCustomerClients creates one client for the customer on each inbound, they share one credential
+func (s *CustomerService) AddCustomerClients(id int, inboundIds []int) ([]model.Client, error) {
+	customer, err := s.GetCustomer(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.addClients(customer, inboundIds, "", 0)
+}
+
+func (s *CustomerService) addClients(customer *model.Customer, inboundIds []int, flow string, limitIp int) ([]model.Client, error) {
+	credential := newUUID()
+	clients := make([]model.Client, 0, len(inboundIds))
+	for _, inboundId := range inboundIds {
+		inbound, err := s.inboundService.GetInbound(inboundId)
+		if err != nil {
+			return nil, err
+		}
+		client := model.Client{
+			Email:   fmt.Sprintf("%s-%d", customer.Name, inboundId),
+			LimitIP: limitIp,
+			Enable:  true,
+			TgID:    customer.TgId,
+			SubID:   customer.SubId,
+		}
+		switch inbound.Protocol {
+		case model.VMess:
+			client.ID = credential
+		case model.VLESS:
+			client.ID = credential
+			client.Flow = flow
+		case model.Trojan:
+			client.Password = credential
+		default:
+			return nil, common.NewErrorf("inbound %v of protocol %v has no clients", inbound.Remark, inbound.Protocol)
+		}
+		clients = append(clients, client)
+	}
+
+	// all inbounds get their client or none, the inbounds are read in the transaction so that clients
+	// added to them meanwhile are not overwritten
+	_, err := s.clientBulkService.transaction(func(tx *gorm.DB) (int, error) {
+		existEmail, err := s.inboundService.checkEmailsExistForClients(tx, clients)
+		if err != nil {
+			return 0, err
+		}
+		if existEmail != "" {
+			return 0, common.NewError("Duplicate email:", existEmail)
+		}
+
+		for i, client := range clients {
+			inbound := &model.Inbound{}
+			err = tx.Model(model.Inbound{}).First(inbound, inboundIds[i]).Error
+			if err != nil {
+				return 0, err
+			}
+			var settings map[string]interface{}
+			err = json.Unmarshal([]byte(inbound.Settings), &settings)
+			if err != nil {
+				return 0, err
+			}
+			oldClients, _ := settings["clients"].([]interface{})
+			settings["clients"] = append(oldClients, client)
+			newSettings, err := json.MarshalIndent(settings, "", "  ")
+			if err != nil {
+				return 0, err
+			}
+			err = tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", string(newSettings)).Error
+			if err != nil {
+				return 0, err
+			}
+			err = tx.Create(&xray.ClientTraffic{
+				InboundId:  inbound.Id,
+				CustomerId: customer.Id,
+				Email:      client.Email,
+				Enable:     s.isValid(customer),
+			}).Error
+			if err != nil {
+				return 0, err
+			}
+		}
+		return len(clients), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// AttachClients hands existing clients over to the customer, their own quota and expiry are cleared
+// because the ones of the customer apply from now on
+func (s *CustomerService) AttachClients(id int, emails []string) (int, error) {
+	customer, err := s.GetCustomer(id)
+	if err != nil {
+		return 0, err
+	}
+	if len(emails) == 0 {
+		return 0, common.NewError("no clients to attach")
+	}
+	filter := &ClientFilter{Emails: emails}
+	return s.clientBulkService.transaction(func(tx *gorm.DB) (int, error) {
+		return s.clientBulkService.eachClient(tx, filter, func(inbound *model.Inbound, c map[string]interface{}, traffic *xray.ClientTraffic) (bool, error) {
+			c["subId"] = customer.SubId
+			c["totalGB"] = 0
+			c["expiryTime"] = 0
+			return true, tx.Model(xray.ClientTraffic{}).Where("email = ?", c["email"]).Updates(map[string]interface{}{
+				"customer_id": customer.Id,
+				"total":       0,
+				"expiry_time": 0,
+				"enable":      s.isValid(customer),
+			}).Error
+		})
+	})
+}
+
+// DetachClients makes clients independent again, they keep the expiry of their customer
+func (s *CustomerService) DetachClients(emails []string) (int, error) {
+	if len(emails) == 0 {
+		return 0, common.NewError("no clients to detach")
+	}
+	customers, err := s.GetCustomers()
+	if err != nil {
+		return 0, err
+	}
+	filter := &ClientFilter{Emails: emails}
+	return s.clientBulkService.transaction(func(tx *gorm.DB) (int, error) {
+		return s.clientBulkService.eachClient(tx, filter, func(inbound *model.Inbound, c map[string]interface{}, traffic *xray.ClientTraffic) (bool, error) {
+			updates := map[string]interface{}{"customer_id": 0}
+			for _, customer := range customers {
+				if traffic != nil && traffic.CustomerId == customer.Id {
+					c["expiryTime"] = customer.ExpiryTime
+					updates["expiry_time"] = customer.ExpiryTime
+				}
+			}
+			return true, tx.Model(xray.ClientTraffic{}).Where("email = ?", c["email"]).Updates(updates).Error
+		})
+	})
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+func TestAddCustomerClientsRollback(t *testing.T) {
+	err := database.InitDB("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if db, err := database.GetDB().DB(); err == nil {
+			db.Close()
+		}
+	})
+
+	db := database.GetDB()
+	settings := []string{`{"clients":[],"decryption":"none"}`, `{"clients":`}
+	for i, setting := range settings {
+		err = db.Create(&model.Inbound{
+			Port:     20000 + i,
+			Protocol: model.VLESS,
+			Enable:   true,
+			Tag:      fmt.Sprintf("inbound-%v", 20000+i),
+			Settings: setting,
+		}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	customer := &model.Customer{Name: "customer", SubId: "sub", Enable: true}
+	err = db.Create(customer).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &CustomerService{}
+	_, err = s.AddCustomerClients(customer.Id, []int{1, 2})
+	if err == nil {
+		t.Fatal("clients were added to an inbound with broken settings")
+	}
+
+	inbound := &model.Inbound{}
+	err = db.First(inbound, 1).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inbound.Settings != settings[0] {
+		t.Errorf("settings of the first inbound are %v, want them unchanged", inbound.Settings)
+	}
+	var count int64
+	db.Model(xray.ClientTraffic{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%v client traffics left after the failed add, want 0", count)
+	}
+
+	clients, err := s.AddCustomerClients(customer.Id, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic := &xray.ClientTraffic{}
+	err = db.Where("email = ?", clients[0].Email).First(traffic).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if traffic.CustomerId != customer.Id || !traffic.Enable {
+		t.Errorf("client traffic %+v does not belong to the enabled customer", traffic)
+	}
+}
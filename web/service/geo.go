@@ -0,0 +1,303 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+var geoLock sync.Mutex
+
+var downloadClient = &http.Client{Timeout: 10 * time.Minute}
+
+type GeoService struct {
+	xrayService XrayService
+}
+
+func (s *GeoService) GetGeoAssets() ([]*model.GeoAsset, error) {
+	db := database.GetDB()
+	var assets []*model.GeoAsset
+	err := db.Model(model.GeoAsset{}).Find(&assets).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return assets, nil
+}
+
+func (s *GeoService) getGeoAsset(id int) (*model.GeoAsset, error) {
+	db := database.GetDB()
+	asset := &model.GeoAsset{}
+	err := db.Model(model.GeoAsset{}).First(asset, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+func (s *GeoService) checkGeoAsset(asset *model.GeoAsset) error {
+	if asset.Name == "" || filepath.Base(asset.Name) != asset.Name || !strings.HasSuffix(asset.Name, ".dat") {
+		return common.NewError("invalid geo file name:", asset.Name)
+	}
+	if asset.Type != xray.GeoIP && asset.Type != xray.GeoSite {
+		return common.NewError("unknown geo file type:", asset.Type)
+	}
+	if asset.Url == "" {
+		return common.NewError("geo file source is empty:", asset.Name)
+	}
+	return nil
+}
+
+func (s *GeoService) AddGeoAsset(asset *model.GeoAsset) error {
+	err := s.checkGeoAsset(asset)
+	if err != nil {
+		return err
+	}
+	asset.Id = 0
+	asset.Version = ""
+	asset.Checksum = ""
+	asset.Size = 0
+	asset.LastUpdate = 0
+	asset.LastCheck = 0
+	asset.LastError = ""
+
+	db := database.GetDB()
+	return db.Create(asset).Error
+}
+
+func (s *GeoService) UpdateGeoAsset(asset *model.GeoAsset) error {
+	err := s.checkGeoAsset(asset)
+	if err != nil {
+		return err
+	}
+	oldAsset, err := s.getGeoAsset(asset.Id)
+	if err != nil {
+		return err
+	}
+	if oldAsset.Name != asset.Name {
+		return common.NewError("geo file name can not be changed:", oldAsset.Name)
+	}
+	oldAsset.Type = asset.Type
+	oldAsset.Url = asset.Url
+	oldAsset.ChecksumUrl = asset.ChecksumUrl
+	oldAsset.Enable = asset.Enable
+
+	db := database.GetDB()
+	return db.Save(oldAsset).Error
+}
+
+func (s *GeoService) DelGeoAsset(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.GeoAsset{}, id).Error
+}
+
+func (s *GeoService) RefreshGeoAssets() error {
+	geoLock.Lock()
+	defer geoLock.Unlock()
+
+	db := database.GetDB()
+	var assets []*model.GeoAsset
+	err := db.Model(model.GeoAsset{}).Where("enable = ?", true).Find(&assets).Error
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	errs := make([]error, 0)
+	for _, asset := range assets {
+		isChanged, err := s.refreshGeoAsset(asset)
+		if err != nil {
+			logger.Warning("update geo file", asset.Name, "failed:", err)
+			errs = append(errs, err)
+		}
+		changed = changed || isChanged
+	}
+	if changed {
+		s.restartXray()
+	}
+	return common.Combine(errs...)
+}
+
+func (s *GeoService) RefreshGeoAsset(id int) error {
+	geoLock.Lock()
+	defer geoLock.Unlock()
+
+	asset, err := s.getGeoAsset(id)
+	if err != nil {
+		return err
+	}
+	changed, err := s.refreshGeoAsset(asset)
+	if changed {
+		s.restartXray()
+	}
+	return err
+}
+
+func (s *GeoService) restartXray() {
+	if !s.xrayService.IsXrayRunning() {
+		return
+	}
+	err := s.xrayService.RestartXray(true)
+	if err != nil {
+		logger.Error("restart xray after geo update failed:", err)
+	}
+}
+
+func (s *GeoService) refreshGeoAsset(asset *model.GeoAsset) (changed bool, err error) {
+	defer func() {
+		asset.LastCheck = time.Now().Unix() * 1000
+		if err != nil {
+			asset.LastError = err.Error()
+		} else {
+			asset.LastError = ""
+		}
+		saveErr := database.GetDB().Save(asset).Error
+		if saveErr != nil {
+			logger.Warning("save geo file state failed:", saveErr)
+		}
+	}()
+
+	path := xray.GetGeoAssetPath(asset.Name)
+	newPath := path + ".new"
+	defer os.Remove(newPath)
+
+	version, err := downloadFile(asset.Url, newPath)
+	if err != nil {
+		return false, err
+	}
+	checksum, size, err := fileChecksum(newPath)
+	if err != nil {
+		return false, err
+	}
+	if asset.ChecksumUrl != "" {
+		expected, err := fetchChecksum(asset.ChecksumUrl)
+		if err != nil {
+			return false, err
+		}
+		if !strings.EqualFold(expected, checksum) {
+			return false, common.NewErrorf("checksum mismatch for %v: expected %v, got %v", asset.Name, expected, checksum)
+		}
+	}
+
+	// compare with the file on disk, it may be replaced by an xray update
+	currentChecksum, _, err := fileChecksum(path)
+	if err == nil && currentChecksum == checksum {
+		asset.Checksum = checksum
+		asset.Size = size
+		if version != "" {
+			asset.Version = version
+		}
+		return false, nil
+	}
+
+	err = xray.ValidateGeoFile(newPath, asset.Type)
+	if err != nil {
+		return false, err
+	}
+	err = os.Rename(newPath, path)
+	if err != nil {
+		return false, err
+	}
+
+	logger.Infof("geo file %v updated, version: %v", asset.Name, version)
+	asset.Version = version
+	asset.Checksum = checksum
+	asset.Size = size
+	asset.LastUpdate = time.Now().Unix() * 1000
+	return true, nil
+}
+
+// openSource opens a http(s) url or a local file (plain path or file://), returns its content and version
+func openSource(url string) (io.ReadCloser, string, error) {
+	if !strings.Contains(url, "://") || strings.HasPrefix(url, "file://") {
+		file, err := os.Open(strings.TrimPrefix(url, "file://"))
+		if err != nil {
+			return nil, "", err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, "", err
+		}
+		return file, stat.ModTime().Format("2006-01-02 15:04:05"), nil
+	}
+
+	resp, err := downloadClient.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, "", common.NewErrorf("download %v failed: %v", url, resp.Status)
+	}
+	version := strings.Trim(resp.Header.Get("ETag"), "\"")
+	if version == "" {
+		version = resp.Header.Get("Last-Modified")
+	}
+	return resp.Body, version, nil
+}
+
+func downloadFile(url string, path string) (string, error) {
+	reader, version, err := openSource(url)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	if err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+func fetchChecksum(url string) (string, error) {
+	reader, _, err := openSource(url)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, 4096))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", common.NewError("empty checksum file:", url)
+	}
+	return fields[0], nil
+}
+
+func fileChecksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
@@ -15,6 +15,7 @@ import (
 )
 
 type InboundService struct {
+	clientIpService ClientIpService
 }
 
 func (s *InboundService) GetInbounds(userId int) ([]*model.Inbound, error) {
@@ -65,10 +66,9 @@ func (s *InboundService) getClients(inbound *model.Inbound) ([]model.Client, err
 	return clients, nil
 }
 
-func (s *InboundService) getAllEmails() ([]string, error) {
-	db := database.GetDB()
+func (s *InboundService) getAllEmails(tx *gorm.DB) ([]string, error) {
 	var emails []string
-	err := db.Raw(`
+	err := tx.Raw(`
 		SELECT JSON_EXTRACT(client.value, '$.email')
 		FROM inbounds,
 			JSON_EACH(JSON_EXTRACT(inbounds.settings, '$.clients')) AS client
@@ -89,8 +89,9 @@ func (s *InboundService) contains(slice []string, str string) bool {
 	return false
 }
 
-func (s *InboundService) checkEmailsExistForClients(clients []model.Client) (string, error) {
-	allEmails, err := s.getAllEmails()
+// checkEmailsExistForClients checks the clients against each other and the clients stored in tx
+func (s *InboundService) checkEmailsExistForClients(tx *gorm.DB, clients []model.Client) (string, error) {
+	allEmails, err := s.getAllEmails(tx)
 	if err != nil {
 		return "", err
 	}
@@ -114,7 +115,7 @@ func (s *InboundService) checkEmailExistForInbound(inbound *model.Inbound) (stri
 	if err != nil {
 		return "", err
 	}
-	allEmails, err := s.getAllEmails()
+	allEmails, err := s.getAllEmails(database.GetDB())
 	if err != nil {
 		return "", err
 	}
@@ -275,7 +276,7 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) error {
 	}
 
 	interfaceClients := settings["clients"].([]interface{})
-	existEmail, err := s.checkEmailsExistForClients(clients)
+	existEmail, err := s.checkEmailsExistForClients(database.GetDB(), clients)
 	if err != nil {
 		return err
 	}
@@ -409,7 +410,7 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	}
 
 	if len(clients[0].Email) > 0 && clients[0].Email != oldEmail {
-		existEmail, err := s.checkEmailsExistForClients(clients)
+		existEmail, err := s.checkEmailsExistForClients(database.GetDB(), clients)
 		if err != nil {
 			return err
 		}
@@ -599,11 +600,30 @@ func (s *InboundService) DisableInvalidClients() (int64, error) {
 	db := database.GetDB()
 	now := time.Now().Unix() * 1000
 	result := db.Model(xray.ClientTraffic{}).
-		Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
+		Where("((total > 0 and up + down >= total) or (up_limit > 0 and up >= up_limit) or (down_limit > 0 and down >= down_limit) or "+
+			"(expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
 		Update("enable", false)
 	err := result.Error
 	count := result.RowsAffected
-	return count, err
+	if err != nil {
+		return count, err
+	}
+
+	// clients of a customer share its quota and expiry
+	var customerIds []int
+	err = db.Model(model.Customer{}).
+		Joins("left join client_traffics on client_traffics.customer_id = customers.id").
+		Group("customers.id").
+		Having("customers.enable = ? or (customers.expiry_time > 0 and customers.expiry_time <= ?) or "+
+			"(customers.total_gb > 0 and coalesce(sum(client_traffics.up + client_traffics.down), 0) >= customers.total_gb)", false, now).
+		Pluck("customers.id", &customerIds).Error
+	if err != nil || len(customerIds) == 0 {
+		return count, err
+	}
+	result = db.Model(xray.ClientTraffic{}).
+		Where("customer_id in ? and enable = ?", customerIds, true).
+		Update("enable", false)
+	return count + result.RowsAffected, result.Error
 }
 func (s *InboundService) RemoveOrphanedTraffics() {
 	db := database.GetDB()
@@ -623,6 +643,8 @@ func (s *InboundService) AddClientStat(inboundId int, client *model.Client) erro
 	clientTraffic.InboundId = inboundId
 	clientTraffic.Email = client.Email
 	clientTraffic.Total = client.TotalGB
+	clientTraffic.UpLimit = client.UpLimit
+	clientTraffic.DownLimit = client.DownLimit
 	clientTraffic.ExpiryTime = client.ExpiryTime
 	clientTraffic.Enable = true
 	clientTraffic.Up = 0
@@ -643,6 +665,8 @@ func (s *InboundService) UpdateClientStat(email string, client *model.Client) er
 			"enable":      true,
 			"email":       client.Email,
 			"total":       client.TotalGB,
+			"up_limit":    client.UpLimit,
+			"down_limit":  client.DownLimit,
 			"expiry_time": client.ExpiryTime})
 	err := result.Error
 	if err != nil {
@@ -652,7 +676,11 @@ func (s *InboundService) UpdateClientStat(email string, client *model.Client) er
 }
 
 func (s *InboundService) UpdateClientIPs(tx *gorm.DB, oldEmail string, newEmail string) error {
-	return tx.Model(model.InboundClientIps{}).Where("client_email = ?", oldEmail).Update("client_email", newEmail).Error
+	err := tx.Model(model.InboundClientIps{}).Where("client_email = ?", oldEmail).Update("client_email", newEmail).Error
+	if err != nil {
+		return err
+	}
+	return s.clientIpService.UpdateClientIpHistory(tx, oldEmail, newEmail)
 }
 
 func (s *InboundService) DelClientStat(tx *gorm.DB, email string) error {
@@ -661,7 +689,11 @@ func (s *InboundService) DelClientStat(tx *gorm.DB, email string) error {
 
 func (s *InboundService) DelClientIPs(tx *gorm.DB, email string) error {
 	logger.Warning(email)
-	return tx.Where("client_email = ?", email).Delete(model.InboundClientIps{}).Error
+	err := tx.Where("client_email = ?", email).Delete(model.InboundClientIps{}).Error
+	if err != nil {
+		return err
+	}
+	return s.clientIpService.DelClientIpHistory(tx, email)
 }
 
 func (s *InboundService) ResetClientTraffic(id int, clientEmail string) error {
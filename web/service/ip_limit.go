@@ -0,0 +1,514 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/sys"
+	"x-ui/xray"
+
+	"gorm.io/gorm/clause"
+)
+
+// ips are tracked at most this long, longer policy windows are cut to it
+const ipLimitMaxWindow = 24 * time.Hour
+
+const maxIpLimitViolations = 100
+
+type activeIp struct {
+	firstSeen int64
+	lastSeen  int64
+}
+
+type IpLimitViolation struct {
+	Email   string   `json:"email"`
+	TgID    string   `json:"-"`
+	Inbound string   `json:"inbound"`
+	LimitIp int      `json:"limitIp"`
+	Ips     []string `json:"ips"`
+	Dropped []string `json:"dropped"`
+	Banned  int64    `json:"banned"`
+	Time    int64    `json:"time"`
+}
+
+var ipLimitLock sync.Mutex
+var activeClientIps = make(map[string]map[string]*activeIp)
+var ipLimitViolations = make([]*IpLimitViolation, 0, maxIpLimitViolations)
+
+// reportedViolations keeps the dropped ips per client so a lasting violation is reported once
+var reportedViolations = make(map[string]string)
+
+type IpLimitService struct {
+	inboundService InboundService
+	settingService SettingService
+	tgbotService   Tgbot
+}
+
+func (s *IpLimitService) GetPolicies() ([]*model.IpLimitPolicy, error) {
+	db := database.GetDB()
+	var policies []*model.IpLimitPolicy
+	err := db.Model(model.IpLimitPolicy{}).Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (s *IpLimitService) checkPolicy(policy *model.IpLimitPolicy) error {
+	if policy.Window <= 0 || time.Duration(policy.Window)*time.Minute > ipLimitMaxWindow {
+		return common.NewErrorf("ip limit window must be between 1 and %v minutes", int(ipLimitMaxWindow.Minutes()))
+	}
+	if policy.SubnetV4 < 1 || policy.SubnetV4 > 32 {
+		return common.NewError("invalid IPv4 subnet prefix:", policy.SubnetV4)
+	}
+	if policy.SubnetV6 < 1 || policy.SubnetV6 > 128 {
+		return common.NewError("invalid IPv6 subnet prefix:", policy.SubnetV6)
+	}
+	if policy.BanTime < 0 {
+		return common.NewError("invalid ban time:", policy.BanTime)
+	}
+	return nil
+}
+
+func (s *IpLimitService) AddPolicy(policy *model.IpLimitPolicy) error {
+	err := s.checkPolicy(policy)
+	if err != nil {
+		return err
+	}
+	policy.Id = 0
+	db := database.GetDB()
+	return db.Create(policy).Error
+}
+
+func (s *IpLimitService) UpdatePolicy(policy *model.IpLimitPolicy) error {
+	err := s.checkPolicy(policy)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	oldPolicy := &model.IpLimitPolicy{}
+	err = db.Model(model.IpLimitPolicy{}).First(oldPolicy, policy.Id).Error
+	if err != nil {
+		return err
+	}
+	policy.InboundId = oldPolicy.InboundId
+	policy.Email = oldPolicy.Email
+	return db.Save(policy).Error
+}
+
+func (s *IpLimitService) DelPolicy(id int) error {
+	db := database.GetDB()
+	policy := &model.IpLimitPolicy{}
+	err := db.Model(model.IpLimitPolicy{}).First(policy, id).Error
+	if err != nil {
+		return err
+	}
+	if policy.InboundId == 0 && policy.Email == "" {
+		return common.NewError("the default ip limit policy can not be deleted")
+	}
+	return db.Delete(policy).Error
+}
+
+// getPolicy picks the client policy, then the inbound policy, then the default one
+func getPolicy(policies []*model.IpLimitPolicy, inboundId int, email string) *model.IpLimitPolicy {
+	var inboundPolicy, defaultPolicy *model.IpLimitPolicy
+	for _, policy := range policies {
+		switch {
+		case policy.Email != "" && policy.Email == email:
+			return policy
+		case policy.Email == "" && policy.InboundId == inboundId:
+			inboundPolicy = policy
+		case policy.Email == "" && policy.InboundId == 0:
+			defaultPolicy = policy
+		}
+	}
+	if inboundPolicy != nil {
+		return inboundPolicy
+	}
+	if defaultPolicy != nil {
+		return defaultPolicy
+	}
+	return &model.IpLimitPolicy{Window: 3, SubnetV4: 32, SubnetV6: 128}
+}
+
+// subnetKey maps an ip to the subnet it is counted as under the policy
+func subnetKey(ip string, policy *model.IpLimitPolicy) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	bits := policy.SubnetV6
+	if addr.Is4() {
+		bits = policy.SubnetV4
+	}
+	if bits <= 0 || bits >= addr.BitLen() {
+		return addr.String()
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
+
+func (s *IpLimitService) TrackAccessLogs(accessLogs []*xray.AccessLog) {
+	ipLimitLock.Lock()
+	defer ipLimitLock.Unlock()
+
+	for _, accessLog := range accessLogs {
+		if accessLog.Email == "" || !accessLog.Accepted {
+			continue
+		}
+		ips, ok := activeClientIps[accessLog.Email]
+		if !ok {
+			ips = make(map[string]*activeIp)
+			activeClientIps[accessLog.Email] = ips
+		}
+		ip, ok := ips[accessLog.SourceIP]
+		if !ok {
+			ips[accessLog.SourceIP] = &activeIp{firstSeen: accessLog.Time, lastSeen: accessLog.Time}
+			continue
+		}
+		if accessLog.Time > ip.lastSeen {
+			ip.lastSeen = accessLog.Time
+		}
+	}
+}
+
+// pruneActiveIps drops ips older than the longest window, the caller must hold ipLimitLock
+func (s *IpLimitService) pruneActiveIps() {
+	since := time.Now().Add(-ipLimitMaxWindow).UnixMilli()
+	for email, ips := range activeClientIps {
+		for ip, active := range ips {
+			if active.lastSeen < since {
+				delete(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			delete(activeClientIps, email)
+		}
+	}
+}
+
+// getActiveBans deletes the expired bans and returns the others by client and subnet
+func (s *IpLimitService) getActiveBans() (map[string]map[string]*model.IpLimitBan, error) {
+	db := database.GetDB()
+	now := time.Now().UnixMilli()
+	var expired []*model.IpLimitBan
+	err := db.Model(model.IpLimitBan{}).Where("until <= ?", now).Find(&expired).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, ban := range expired {
+		logger.Info("ip limit ban of", ban.Email, "from", ban.Subnet, "expired")
+	}
+	if len(expired) > 0 {
+		err = db.Where("until <= ?", now).Delete(model.IpLimitBan{}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var bans []*model.IpLimitBan
+	err = db.Model(model.IpLimitBan{}).Find(&bans).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]map[string]*model.IpLimitBan)
+	for _, ban := range bans {
+		if result[ban.Email] == nil {
+			result[ban.Email] = make(map[string]*model.IpLimitBan)
+		}
+		result[ban.Email][ban.Subnet] = ban
+	}
+	return result, nil
+}
+
+// bannedIps returns the ips recorded with the bans of a client and the tracked ips inside its banned subnets,
+// so a ban holds after a restart and after its ips left the policy window, the caller must hold ipLimitLock
+func (s *IpLimitService) bannedIps(email string, bans map[string]*model.IpLimitBan) []string {
+	ips := make([]string, 0)
+	for subnet, ban := range bans {
+		if ban.Ips != "" {
+			ips = append(ips, strings.Split(ban.Ips, ",")...)
+		}
+		prefix, err := netip.ParsePrefix(subnet)
+		if err != nil {
+			if addr, err := netip.ParseAddr(subnet); err == nil {
+				ips = append(ips, addr.String())
+			}
+			continue
+		}
+		for ip := range activeClientIps[email] {
+			addr, err := netip.ParseAddr(ip)
+			if err == nil && prefix.Contains(addr) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// clientSubnets groups the ips a client used within the policy window by subnet, the earliest connected first
+func (s *IpLimitService) clientSubnets(email string, policy *model.IpLimitPolicy) ([]string, map[string][]string) {
+	since := time.Now().Add(-time.Duration(policy.Window) * time.Minute).UnixMilli()
+	firstSeen := make(map[string]int64)
+	members := make(map[string][]string)
+	for ip, active := range activeClientIps[email] {
+		if active.lastSeen < since {
+			continue
+		}
+		key := subnetKey(ip, policy)
+		if seen, ok := firstSeen[key]; !ok || active.firstSeen < seen {
+			firstSeen[key] = active.firstSeen
+		}
+		members[key] = append(members[key], ip)
+	}
+	subnets := make([]string, 0, len(firstSeen))
+	for key := range firstSeen {
+		subnets = append(subnets, key)
+	}
+	sort.Slice(subnets, func(i, j int) bool {
+		if firstSeen[subnets[i]] != firstSeen[subnets[j]] {
+			return firstSeen[subnets[i]] < firstSeen[subnets[j]]
+		}
+		return subnets[i] < subnets[j]
+	})
+	return subnets, members
+}
+
+func (s *IpLimitService) GetActiveIps(email string) []string {
+	ipLimitLock.Lock()
+	defer ipLimitLock.Unlock()
+	s.pruneActiveIps()
+	ips := make([]string, 0, len(activeClientIps[email]))
+	for ip := range activeClientIps[email] {
+		ips = append(ips, ip)
+	}
+	common.SortIPs(ips)
+	return ips
+}
+
+// Enforce drops the connections of the ips that exceed the client limit or are banned and returns all blocked ips.
+// It runs with the client ip job every 10 seconds. Sockets are dropped by source ip and inbound port, so other
+// clients behind the same ip (NAT) lose their connections on the inbound too.
+func (s *IpLimitService) Enforce() ([]string, error) {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := s.GetPolicies()
+	if err != nil {
+		return nil, err
+	}
+	bans, err := s.getActiveBans()
+	if err != nil {
+		return nil, err
+	}
+
+	ipLimitLock.Lock()
+	s.pruneActiveIps()
+
+	blockedIps := make([]string, 0)
+	violating := make(map[string]string)
+	notifications := make([]*IpLimitViolation, 0)
+	newBans := make([]*model.IpLimitBan, 0)
+	now := time.Now()
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		settings := map[string][]model.Client{}
+		json.Unmarshal([]byte(inbound.Settings), &settings)
+
+		excessIps := make([]string, 0)
+		for _, client := range settings["clients"] {
+			// bans hold until they expire, even when the limit is lifted in between
+			excessIps = append(excessIps, s.bannedIps(client.Email, bans[client.Email])...)
+			if client.LimitIP <= 0 {
+				continue
+			}
+			policy := getPolicy(policies, inbound.Id, client.Email)
+			subnets, members := s.clientSubnets(client.Email, policy)
+
+			// banned subnets neither count against the limit nor get through
+			allowed := make([]string, 0, len(subnets))
+			for _, subnet := range subnets {
+				if _, banned := bans[client.Email][subnet]; banned {
+					excessIps = append(excessIps, members[subnet]...)
+				} else {
+					allowed = append(allowed, subnet)
+				}
+			}
+			if len(allowed) <= client.LimitIP {
+				continue
+			}
+
+			dropped := make([]string, 0)
+			for _, subnet := range allowed[client.LimitIP:] {
+				dropped = append(dropped, members[subnet]...)
+			}
+			excessIps = append(excessIps, dropped...)
+
+			violation := &IpLimitViolation{
+				Email:   client.Email,
+				TgID:    client.TgID,
+				Inbound: inbound.Tag,
+				LimitIp: client.LimitIP,
+				Ips:     allowed,
+				Dropped: dropped,
+				Time:    now.UnixMilli(),
+			}
+			if policy.BanTime > 0 {
+				violation.Banned = now.Add(time.Duration(policy.BanTime) * time.Minute).UnixMilli()
+				for _, subnet := range allowed[client.LimitIP:] {
+					newBans = append(newBans, &model.IpLimitBan{
+						Email:  client.Email,
+						Subnet: subnet,
+						Ips:    strings.Join(members[subnet], ","),
+						Until:  violation.Banned,
+					})
+				}
+			}
+
+			violating[client.Email] = strings.Join(dropped, ",")
+			if reportedViolations[client.Email] == violating[client.Email] {
+				continue
+			}
+			s.addViolation(violation)
+			if policy.Notify {
+				notifications = append(notifications, violation)
+			}
+		}
+		if len(excessIps) == 0 {
+			continue
+		}
+		blockedIps = append(blockedIps, excessIps...)
+
+		count, err := sys.DestroySockets([]int{inbound.Port}, excessIps)
+		if err != nil {
+			logger.Warning("drop connections of inbound", inbound.Tag, "failed:", err)
+		} else if count > 0 {
+			logger.Debug("dropped", count, "connections of inbound", inbound.Tag)
+		}
+	}
+	reportedViolations = violating
+	ipLimitLock.Unlock()
+
+	err = s.saveBans(newBans)
+	if err != nil {
+		logger.Warning("save ip limit bans failed:", err)
+	}
+	for _, violation := range notifications {
+		s.notify(violation)
+	}
+	blockedIps = uniqueIps(blockedIps)
+	common.SortIPs(blockedIps)
+	return blockedIps, nil
+}
+
+// saveBans stores new bans, a subnet banned again gets the later expiry and the ips seen this time
+func (s *IpLimitService) saveBans(bans []*model.IpLimitBan) error {
+	if len(bans) == 0 {
+		return nil
+	}
+	db := database.GetDB()
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "email"}, {Name: "subnet"}},
+		DoUpdates: clause.AssignmentColumns([]string{"ips", "until"}),
+	}).Create(bans).Error
+}
+
+func uniqueIps(ips []string) []string {
+	seen := make(map[string]bool, len(ips))
+	result := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if ip == "" || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		result = append(result, ip)
+	}
+	return result
+}
+
+// addViolation keeps the latest violations, the caller must hold ipLimitLock
+func (s *IpLimitService) addViolation(violation *IpLimitViolation) {
+	logger.Warningf("client %v exceeds ip limit %v with %v, dropping %v", violation.Email, violation.LimitIp, violation.Ips, violation.Dropped)
+	if len(ipLimitViolations) == maxIpLimitViolations {
+		ipLimitViolations = ipLimitViolations[1:]
+	}
+	ipLimitViolations = append(ipLimitViolations, violation)
+}
+
+func (s *IpLimitService) GetViolations() []*IpLimitViolation {
+	ipLimitLock.Lock()
+	defer ipLimitLock.Unlock()
+	violations := make([]*IpLimitViolation, len(ipLimitViolations))
+	copy(violations, ipLimitViolations)
+	return violations
+}
+
+func (s *IpLimitService) GetBans() ([]*model.IpLimitBan, error) {
+	db := database.GetDB()
+	var bans []*model.IpLimitBan
+	err := db.Model(model.IpLimitBan{}).Where("until > ?", time.Now().UnixMilli()).Order("until").Find(&bans).Error
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+func (s *IpLimitService) DelBan(email string, subnet string) error {
+	db := database.GetDB()
+	err := db.Where("email = ? and subnet = ?", email, subnet).Delete(model.IpLimitBan{}).Error
+	if err != nil {
+		return err
+	}
+	ipLimitLock.Lock()
+	delete(reportedViolations, email)
+	ipLimitLock.Unlock()
+	return nil
+}
+
+// notify sends a violation to the telegram admins, the client itself when its tgId is a chat id, and the webhook
+func (s *IpLimitService) notify(violation *IpLimitViolation) {
+	msg := func(lang string) string {
+		msg := Tr("msgIpLimitExceeded", lang,
+			"Email=="+violation.Email,
+			"Inbound=="+violation.Inbound,
+			"Limit=="+fmt.Sprint(violation.LimitIp),
+			"Ips=="+strings.Join(violation.Ips, ", "),
+			"Dropped=="+strings.Join(violation.Dropped, ", "))
+		if violation.Banned > 0 {
+			msg += "\r\n" + Tr("msgIpLimitBanned", lang, "Time=="+time.UnixMilli(violation.Banned).Format("2006-01-02 15:04:05"))
+		}
+		return msg
+	}
+
+	if s.tgbotService.IsRunnging() {
+		s.tgbotService.SendTrToTgbotAdmins(msg)
+		chatId, err := strconv.ParseInt(violation.TgID, 10, 64)
+		if err == nil {
+			s.tgbotService.SendMsgToTgbot(chatId, msg(s.tgbotService.telegramService.getChatLang(chatId, defaultLang)))
+		}
+	}
+
+	webhook, err := s.settingService.GetIpLimitWebhook()
+	if err != nil || webhook == "" {
+		return
+	}
+	sendWebhook(webhook, map[string]interface{}{
+		"event":     "ipLimitViolation",
+		"violation": violation,
+	})
+}
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// failed logins are counted over this window for the loginFail rules
+const loginFailWindow = 10 * time.Minute
+
+var defaultNotifyTemplates = map[model.NotifyCondition]string{
+	model.NotifyTraffic:   `⚠️ {{.Email}} used {{printf "%.0f" .Value}}% of its traffic`,
+	model.NotifyExpiry:    `⏳ {{.Email}} expires in {{printf "%.1f" .Value}} days`,
+	model.NotifyCpu:       `🔴 CPU usage {{printf "%.2f" .Value}}% is more than threshold {{.Threshold}}%`,
+	model.NotifyMem:       `🔴 Memory usage {{printf "%.2f" .Value}}% is more than threshold {{.Threshold}}%`,
+	model.NotifyDisk:      `🔴 Disk usage {{printf "%.2f" .Value}}% is more than threshold {{.Threshold}}%`,
+	model.NotifyXrayDown:  `🔴 Xray is not running: {{.Detail}}`,
+	model.NotifyLoginFail: `🔐 {{printf "%.0f" .Value}} failed logins in the last 10 minutes from: {{.Detail}}`,
+}
+
+type loginFail struct {
+	at time.Time
+	ip string
+}
+
+var (
+	loginFails     []loginFail
+	loginFailsLock sync.Mutex
+)
+
+// NotifyEvent is a condition of a rule that holds, its fields can be used in the template of the rule
+type NotifyEvent struct {
+	Rule      string                `json:"rule"`
+	Condition model.NotifyCondition `json:"condition"`
+	Key       string                `json:"key"`
+	Email     string                `json:"email,omitempty"`
+	Inbound   string                `json:"inbound,omitempty"`
+	Value     float64               `json:"value"`
+	Threshold float64               `json:"threshold"`
+	Detail    string                `json:"detail,omitempty"`
+	Time      string                `json:"time"`
+
+	chatIds []int64
+}
+
+type NotifyService struct {
+	inboundService InboundService
+	xrayService    XrayService
+	tgbotService   Tgbot
+}
+
+func (s *NotifyService) GetRules() ([]*model.NotifyRule, error) {
+	db := database.GetDB()
+	var rules []*model.NotifyRule
+	err := db.Model(model.NotifyRule{}).Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *NotifyService) checkRule(rule *model.NotifyRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if _, ok := defaultNotifyTemplates[rule.Condition]; !ok {
+		return common.NewError("unknown notify condition:", rule.Condition)
+	}
+	if rule.Threshold < 0 || rule.Cooldown < 0 {
+		return common.NewError("notify rule values can not be negative:", rule.Name)
+	}
+	targets := make([]string, 0)
+	for _, target := range strings.Split(rule.Targets, ",") {
+		target = strings.TrimSpace(target)
+		switch target {
+		case "":
+			continue
+		case "admins", "client", "webhook":
+			targets = append(targets, target)
+		default:
+			return common.NewError("unknown notify target:", target)
+		}
+	}
+	if len(targets) == 0 {
+		return common.NewError("notify rule has no target:", rule.Name)
+	}
+	rule.Targets = strings.Join(targets, ",")
+	if rule.HasTarget("webhook") && rule.Webhook == "" {
+		return common.NewError("notify rule has no webhook:", rule.Name)
+	}
+	if rule.Template != "" {
+		if _, err := template.New("").Parse(rule.Template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *NotifyService) AddRule(rule *model.NotifyRule) error {
+	err := s.checkRule(rule)
+	if err != nil {
+		return err
+	}
+	rule.Id = 0
+	db := database.GetDB()
+	return db.Create(rule).Error
+}
+
+// UpdateRule saves the rule and forgets its alerts, so they are sent again with the new settings
+func (s *NotifyService) UpdateRule(rule *model.NotifyRule) error {
+	err := s.checkRule(rule)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	err = db.Model(model.NotifyRule{}).First(&model.NotifyRule{}, rule.Id).Error
+	if err != nil {
+		return err
+	}
+	err = db.Save(rule).Error
+	if err != nil {
+		return err
+	}
+	return db.Where("rule_id = ?", rule.Id).Delete(model.NotifyState{}).Error
+}
+
+func (s *NotifyService) DelRule(id int) error {
+	db := database.GetDB()
+	err := db.Where("rule_id = ?", id).Delete(model.NotifyState{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Delete(model.NotifyRule{}, id).Error
+}
+
+// LoginFailed records a failed login of the panel for the loginFail rules
+func (s *NotifyService) LoginFailed(ip string) {
+	loginFailsLock.Lock()
+	defer loginFailsLock.Unlock()
+	now := time.Now()
+	i := 0
+	for i < len(loginFails) && now.Sub(loginFails[i].at) > loginFailWindow {
+		i++
+	}
+	loginFails = append(loginFails[i:], loginFail{at: now, ip: ip})
+}
+
+func recentLoginFails() (int, []string) {
+	loginFailsLock.Lock()
+	defer loginFailsLock.Unlock()
+	now := time.Now()
+	count := 0
+	ips := make([]string, 0)
+	seen := map[string]bool{}
+	for _, fail := range loginFails {
+		if now.Sub(fail.at) > loginFailWindow {
+			continue
+		}
+		count++
+		if !seen[fail.ip] {
+			seen[fail.ip] = true
+			ips = append(ips, fail.ip)
+		}
+	}
+	return count, ips
+}
+
+// CheckRules evaluates the enabled rules and sends the alerts that are new or whose cooldown is over
+func (s *NotifyService) CheckRules() {
+	rules, err := s.GetRules()
+	if err != nil {
+		logger.Warning("get notify rules failed:", err)
+		return
+	}
+	checker := &notifyChecker{service: s}
+	for _, rule := range rules {
+		if !rule.Enable {
+			continue
+		}
+		events, err := checker.events(rule)
+		if err != nil {
+			logger.Warning("check notify rule", rule.Name, "failed:", err)
+			continue
+		}
+		err = s.dispatch(rule, events)
+		if err != nil {
+			logger.Warning("dispatch notify rule", rule.Name, "failed:", err)
+		}
+	}
+}
+
+// dispatch sends the events that were not sent within the cooldown of the rule and drops the state of the
+// alerts that cleared
+func (s *NotifyService) dispatch(rule *model.NotifyRule, events []*NotifyEvent) error {
+	db := database.GetDB()
+	var states []*model.NotifyState
+	err := db.Model(model.NotifyState{}).Where("rule_id = ?", rule.Id).Find(&states).Error
+	if err != nil {
+		return err
+	}
+	sent := make(map[string]*model.NotifyState, len(states))
+	for _, state := range states {
+		sent[state.Key] = state
+	}
+
+	now := time.Now()
+	cooldown := time.Duration(rule.Cooldown) * time.Minute
+	for _, event := range events {
+		state, ok := sent[event.Key]
+		delete(sent, event.Key)
+		if ok && (rule.Cooldown == 0 || now.Sub(time.UnixMilli(state.LastSent)) < cooldown) {
+			continue
+		}
+		s.send(rule, event)
+		if !ok {
+			state = &model.NotifyState{RuleId: rule.Id, Key: event.Key}
+		}
+		state.LastSent = now.UnixMilli()
+		err = db.Save(state).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, state := range sent {
+		err = db.Delete(state).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *NotifyService) send(rule *model.NotifyRule, event *NotifyEvent) {
+	event.Rule = rule.Name
+	event.Time = time.Now().Format("2006-01-02 15:04:05")
+	msg, err := renderNotify(rule, event)
+	if err != nil {
+		logger.Warning("render notify rule", rule.Name, "failed:", err)
+		return
+	}
+	if rule.HasTarget("admins") && s.tgbotService.IsRunnging() {
+		s.tgbotService.SendMsgToTgbotAdmins(msg)
+	}
+	if rule.HasTarget("client") && s.tgbotService.IsRunnging() {
+		for _, chatId := range event.chatIds {
+			s.tgbotService.SendMsgToTgbot(chatId, msg)
+		}
+	}
+	if rule.HasTarget("webhook") {
+		sendWebhook(rule.Webhook, map[string]interface{}{
+			"event":   "notifyRule",
+			"alert":   event,
+			"message": msg,
+		})
+	}
+}
+
+func renderNotify(rule *model.NotifyRule, event *NotifyEvent) (string, error) {
+	text := rule.Template
+	if text == "" {
+		text = defaultNotifyTemplates[rule.Condition]
+	}
+	tmpl, err := template.New(rule.Name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, event)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// notifyChecker evaluates the rules of one check, the clients and the usage of the server are read once
+type notifyChecker struct {
+	service *NotifyService
+	clients []*notifyClient
+	usage   map[model.NotifyCondition]float64
+}
+
+type notifyClient struct {
+	inbound *model.Inbound
+	client  model.Client
+	traffic *xray.ClientTraffic
+}
+
+func (c *notifyChecker) events(rule *model.NotifyRule) ([]*NotifyEvent, error) {
+	event := func(key string, value float64) *NotifyEvent {
+		return &NotifyEvent{Condition: rule.Condition, Key: key, Value: value, Threshold: rule.Threshold}
+	}
+	events := make([]*NotifyEvent, 0)
+	switch rule.Condition {
+	case model.NotifyTraffic, model.NotifyExpiry:
+		clients, err := c.getClients()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UnixMilli()
+		for _, client := range clients {
+			traffic := client.traffic
+			var value float64
+			if rule.Condition == model.NotifyTraffic {
+				if traffic.Total <= 0 {
+					continue
+				}
+				value = float64(traffic.Up+traffic.Down) * 100 / float64(traffic.Total)
+				if value < rule.Threshold {
+					continue
+				}
+			} else {
+				if traffic.ExpiryTime <= now {
+					continue
+				}
+				value = float64(traffic.ExpiryTime-now) / float64(24*time.Hour.Milliseconds())
+				if value > rule.Threshold {
+					continue
+				}
+			}
+			e := event(traffic.Email, value)
+			e.Email = traffic.Email
+			e.Inbound = client.inbound.Remark
+			if rule.HasTarget("client") {
+				e.chatIds = client.chatIds()
+			}
+			events = append(events, e)
+		}
+	case model.NotifyCpu, model.NotifyMem, model.NotifyDisk:
+		value, err := c.getUsage(rule.Condition)
+		if err != nil {
+			return nil, err
+		}
+		if value >= rule.Threshold {
+			events = append(events, event(string(rule.Condition), value))
+		}
+	case model.NotifyXrayDown:
+		if !c.service.xrayService.IsXrayRunning() {
+			e := event(string(rule.Condition), 1)
+			if err := c.service.xrayService.GetXrayErr(); err != nil {
+				e.Detail = err.Error()
+			}
+			events = append(events, e)
+		}
+	case model.NotifyLoginFail:
+		count, ips := recentLoginFails()
+		if count > 0 && float64(count) >= rule.Threshold {
+			e := event(string(rule.Condition), float64(count))
+			e.Detail = strings.Join(ips, ", ")
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// getClients returns the enabled clients that have traffic stats
+func (c *notifyChecker) getClients() ([]*notifyClient, error) {
+	if c.clients != nil {
+		return c.clients, nil
+	}
+	inbounds, err := c.service.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	c.clients = make([]*notifyClient, 0)
+	for _, inbound := range inbounds {
+		clients, err := c.service.inboundService.getClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			for i := range inbound.ClientStats {
+				traffic := &inbound.ClientStats[i]
+				if traffic.Email == client.Email && traffic.Enable {
+					c.clients = append(c.clients, &notifyClient{inbound: inbound, client: client, traffic: traffic})
+				}
+			}
+		}
+	}
+	return c.clients, nil
+}
+
+// chatIds are the chat of the tgId of the client and the enabled telegram clients it is linked to
+func (c *notifyClient) chatIds() []int64 {
+	chatIds := make([]int64, 0)
+	if chatId, err := strconv.ParseInt(strings.TrimSpace(c.client.TgID), 10, 64); err == nil {
+		chatIds = append(chatIds, chatId)
+	}
+	uid := c.client.ID
+	if c.inbound.Protocol == model.Trojan {
+		uid = c.client.Password
+	}
+	var linked []int64
+	db := database.GetDB()
+	err := db.Model(model.TgClientUid{}).
+		Joins("join tg_clients on tg_clients.chat_id = tg_client_uids.chat_id").
+		Where("tg_client_uids.uid = ? and tg_clients.enabled = ?", uid, true).
+		Pluck("tg_client_uids.chat_id", &linked).Error
+	if err != nil {
+		logger.Warning("get telegram clients of", c.client.Email, "failed:", err)
+	}
+	for _, chatId := range linked {
+		if len(chatIds) == 0 || chatIds[0] != chatId {
+			chatIds = append(chatIds, chatId)
+		}
+	}
+	return chatIds
+}
+
+func (c *notifyChecker) getUsage(condition model.NotifyCondition) (float64, error) {
+	if value, ok := c.usage[condition]; ok {
+		return value, nil
+	}
+	var value float64
+	switch condition {
+	case model.NotifyCpu:
+		percents, err := cpu.Percent(0, false)
+		if err != nil {
+			return 0, err
+		}
+		value = percents[0]
+	case model.NotifyMem:
+		memInfo, err := mem.VirtualMemory()
+		if err != nil {
+			return 0, err
+		}
+		value = memInfo.UsedPercent
+	case model.NotifyDisk:
+		diskInfo, err := disk.Usage("/")
+		if err != nil {
+			return 0, err
+		}
+		value = diskInfo.UsedPercent
+	}
+	if c.usage == nil {
+		c.usage = map[model.NotifyCondition]float64{}
+	}
+	c.usage[condition] = value
+	return value, nil
+}
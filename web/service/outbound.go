@@ -0,0 +1,82 @@
+package service
+
+import (
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/xray"
+
+	"gorm.io/gorm"
+)
+
+type OutboundService struct {
+}
+
+func (s *OutboundService) AddTraffic(traffics []*xray.Traffic) (err error) {
+	if len(traffics) == 0 {
+		return nil
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for _, traffic := range traffics {
+		if traffic.IsInbound {
+			continue
+		}
+		var outbound model.OutboundTraffics
+		err = tx.Model(model.OutboundTraffics{}).Where("tag = ?", traffic.Tag).
+			FirstOrCreate(&outbound, model.OutboundTraffics{Tag: traffic.Tag}).Error
+		if err != nil {
+			return err
+		}
+
+		outbound.Up += traffic.Up
+		outbound.Down += traffic.Down
+		outbound.Total = outbound.Up + outbound.Down
+
+		err = tx.Save(&outbound).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, error) {
+	db := database.GetDB()
+	var traffics []*model.OutboundTraffics
+
+	err := db.Model(model.OutboundTraffics{}).Find(&traffics).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logger.Warning(err)
+		return nil, err
+	}
+
+	return traffics, nil
+}
+
+func (s *OutboundService) ResetOutboundTraffic(tag string) error {
+	db := database.GetDB()
+
+	whereText := "tag "
+	if tag == "-alltags-" {
+		whereText += " <> ?"
+	} else {
+		whereText += " = ?"
+	}
+
+	result := db.Model(model.OutboundTraffics{}).
+		Where(whereText, tag).
+		Updates(map[string]interface{}{"up": 0, "down": 0, "total": 0})
+
+	return result.Error
+}
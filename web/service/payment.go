@@ -0,0 +1,420 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/random"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// PaymentEvent is what a provider reports about the invoice of a payment
+type PaymentEvent struct {
+	Key        string
+	ExternalId string
+	Amount     int64
+	Currency   string
+	Paid       bool
+}
+
+// PaymentProvider bills customers for the plans they order in the bot
+type PaymentProvider interface {
+	Name() string
+	Enabled() bool
+	// CreateInvoice bills payment and may set its ExternalId, it returns the link to pay the invoice or
+	// an empty link when the invoice was sent to the chat of the customer
+	CreateInvoice(payment *model.Payment, title string) (string, error)
+}
+
+// PaymentWebhook is implemented by providers that confirm payments by calling the panel
+type PaymentWebhook interface {
+	// ParseWebhook verifies a notification and returns its event, or nil when there is nothing to do
+	ParseWebhook(header http.Header, body []byte) (*PaymentEvent, error)
+}
+
+var paymentProviders = []PaymentProvider{
+	&TelegramPaymentProvider{},
+	&InvoicePaymentProvider{},
+}
+
+// RegisterPaymentProvider adds a provider or replaces the one with the same name
+func RegisterPaymentProvider(provider PaymentProvider) {
+	for i, p := range paymentProviders {
+		if p.Name() == provider.Name() {
+			paymentProviders[i] = provider
+			return
+		}
+	}
+	paymentProviders = append(paymentProviders, provider)
+}
+
+var invoiceClient = &http.Client{Timeout: 10 * time.Second}
+
+type PaymentService struct {
+	settingService    SettingService
+	clientPlanService ClientPlanService
+	telegramService   TelegramService
+	xrayService       XrayService
+}
+
+func (s *PaymentService) GetProviders() []PaymentProvider {
+	providers := make([]PaymentProvider, 0, len(paymentProviders))
+	for _, provider := range paymentProviders {
+		if provider.Enabled() {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+func (s *PaymentService) getProvider(name string) (PaymentProvider, error) {
+	for _, provider := range paymentProviders {
+		if provider.Name() == name && provider.Enabled() {
+			return provider, nil
+		}
+	}
+	return nil, common.NewError("payment provider is not enabled:", name)
+}
+
+func (s *PaymentService) GetPayments() ([]*model.Payment, error) {
+	db := database.GetDB()
+	var payments []*model.Payment
+	err := db.Model(model.Payment{}).Order("id desc").Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+func (s *PaymentService) getPayment(key string) (*model.Payment, error) {
+	db := database.GetDB()
+	payment := &model.Payment{}
+	err := db.Model(model.Payment{}).Where("idempotency_key = ?", key).First(payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// CreatePayment bills the plan of request with a provider. A payment that already exists with the same key
+// is returned as it is, so the customer is not billed twice for the same order
+func (s *PaymentService) CreatePayment(providerName string, key string, request *model.TgClientMsg) (*model.Payment, string, error) {
+	if key == "" {
+		key = random.Seq(32)
+	} else if payment, err := s.getPayment(key); err == nil {
+		return payment, "", nil
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, "", err
+	}
+	provider, err := s.getProvider(providerName)
+	if err != nil {
+		return nil, "", err
+	}
+	plan, err := s.clientPlanService.GetPlan(request.PlanId)
+	if err != nil {
+		return nil, "", err
+	}
+	scale, err := s.settingService.GetPaymentPriceScale()
+	if err != nil {
+		return nil, "", err
+	}
+	currency, err := s.settingService.GetPaymentCurrency()
+	if err != nil {
+		return nil, "", err
+	}
+
+	payment := &model.Payment{
+		IdempotencyKey: key,
+		Provider:       provider.Name(),
+		ChatID:         request.ChatID,
+		Type:           request.Type,
+		PlanId:         plan.Id,
+		Uid:            request.Uid,
+		Amount:         plan.Price * int64(scale),
+		Currency:       strings.ToUpper(currency),
+		Status:         model.PaymentPending,
+	}
+	db := database.GetDB()
+	err = db.Create(payment).Error
+	if err != nil {
+		return nil, "", err
+	}
+	link, err := provider.CreateInvoice(payment, plan.Name)
+	if err != nil {
+		payment.Status = model.PaymentCancelled
+		payment.Error = err.Error()
+	}
+	if err1 := db.Save(payment).Error; err1 != nil {
+		logger.Warning("save payment failed:", err1)
+	}
+	return payment, link, err
+}
+
+// CheckPayment tells if a payment can still be paid with amount
+func (s *PaymentService) CheckPayment(key string, amount int64, currency string) error {
+	payment, err := s.getPayment(key)
+	if err != nil {
+		return common.NewError("payment not found:", key)
+	}
+	if payment.Status != model.PaymentPending {
+		return common.NewError("payment is already", payment.Status)
+	}
+	if payment.Amount != amount || !strings.EqualFold(payment.Currency, currency) {
+		return common.NewErrorf("payment %v is %v %v, not %v %v", payment.Id, payment.Amount, payment.Currency, amount, currency)
+	}
+	return nil
+}
+
+// ConfirmPayment applies an event of a provider to its payment, a paid payment creates or renews the client of
+// its plan. Events of payments that are no longer pending are ignored, providers may repeat notifications
+func (s *PaymentService) ConfirmPayment(event *PaymentEvent) error {
+	payment, err := s.getPayment(event.Key)
+	if err != nil {
+		return common.NewError("payment not found:", event.Key)
+	}
+	if payment.Status != model.PaymentPending {
+		return nil
+	}
+	db := database.GetDB()
+	pending := db.Model(model.Payment{}).Where("id = ? and status = ?", payment.Id, model.PaymentPending)
+
+	if !event.Paid {
+		return pending.Update("status", model.PaymentCancelled).Error
+	}
+	if event.Amount != payment.Amount || !strings.EqualFold(event.Currency, payment.Currency) {
+		err = common.NewErrorf("payment %v is %v %v but %v %v was paid", payment.Id, payment.Amount, payment.Currency, event.Amount, event.Currency)
+		s.failPayment(payment, err)
+		return err
+	}
+
+	updates := map[string]interface{}{"status": model.PaymentPaid}
+	if event.ExternalId != "" {
+		updates["external_id"] = event.ExternalId
+	}
+	result := pending.Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	request := &model.TgClientMsg{
+		ChatID: payment.ChatID,
+		Type:   payment.Type,
+		PlanId: payment.PlanId,
+		Uid:    payment.Uid,
+	}
+	clients, err := s.telegramService.applyRequest(request, fmt.Sprintf("tg%d-pay%d", payment.ChatID, payment.Id))
+	if len(clients) > 0 {
+		s.xrayService.SetToNeedRestart()
+	}
+	if err != nil {
+		s.failPayment(payment, err)
+		return err
+	}
+	s.telegramService.SendTrToAdmins("msgPaymentPaid",
+		"Id=="+fmt.Sprint(payment.Id),
+		"Amount=="+fmt.Sprint(payment.Amount),
+		"Currency=="+payment.Currency,
+		"Type=="+string(payment.Type),
+		"ChatId=="+fmt.Sprint(payment.ChatID),
+		"Provider=="+payment.Provider)
+	return nil
+}
+
+// failPayment keeps why a paid payment was not applied and asks the admins to handle it
+func (s *PaymentService) failPayment(payment *model.Payment, cause error) {
+	db := database.GetDB()
+	err := db.Model(model.Payment{}).Where("id = ?", payment.Id).Updates(map[string]interface{}{
+		"status": model.PaymentFailed,
+		"error":  cause.Error(),
+	}).Error
+	if err != nil {
+		logger.Warning("update payment failed:", err)
+	}
+	logger.Warning("payment", payment.Id, "failed:", cause)
+	s.telegramService.SendTrToAdmins("msgPaymentFailed",
+		"Id=="+fmt.Sprint(payment.Id),
+		"Amount=="+fmt.Sprint(payment.Amount),
+		"ChatId=="+fmt.Sprint(payment.ChatID),
+		"Error=="+cause.Error())
+}
+
+// HandleWebhook confirms a payment from the notification of a provider
+func (s *PaymentService) HandleWebhook(providerName string, header http.Header, body []byte) error {
+	provider, err := s.getProvider(providerName)
+	if err != nil {
+		return err
+	}
+	webhook, ok := provider.(PaymentWebhook)
+	if !ok {
+		return common.NewError("payment provider has no webhook:", providerName)
+	}
+	event, err := webhook.ParseWebhook(header, body)
+	if err != nil || event == nil {
+		return err
+	}
+	return s.ConfirmPayment(event)
+}
+
+// TelegramPaymentProvider sends invoices paid inside telegram through the provider connected in @BotFather
+type TelegramPaymentProvider struct {
+	settingService SettingService
+}
+
+func (p *TelegramPaymentProvider) Name() string {
+	return "telegram"
+}
+
+func (p *TelegramPaymentProvider) Enabled() bool {
+	token, err := p.settingService.GetTgPaymentToken()
+	return err == nil && token != "" && bot != nil
+}
+
+func (p *TelegramPaymentProvider) CreateInvoice(payment *model.Payment, title string) (string, error) {
+	token, err := p.settingService.GetTgPaymentToken()
+	if err != nil {
+		return "", err
+	}
+	invoice := tgbotapi.NewInvoice(payment.ChatID, title, title, payment.IdempotencyKey, token, "", payment.Currency,
+		[]tgbotapi.LabeledPrice{{Label: title, Amount: int(payment.Amount)}})
+	invoice.SuggestedTipAmounts = []int{}
+	return "", tgSend(invoice)
+}
+
+// InvoicePaymentProvider creates invoices with a generic invoice or crypto gateway. The gateway receives
+//
+//	{"orderId": key, "amount": amount, "currency": currency, "description": plan}
+//
+// answers with {"id": invoice id, "url": payment link} and posts {"orderId", "id", "status", "amount", "currency"}
+// to /payment/webhook/invoice, signed in the X-Signature header with the hex HMAC-SHA256 of the body
+type InvoicePaymentProvider struct {
+	settingService SettingService
+}
+
+type invoiceRequest struct {
+	OrderId     string `json:"orderId"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Description string `json:"description"`
+}
+
+type invoiceResponse struct {
+	Id  string `json:"id"`
+	Url string `json:"url"`
+}
+
+type invoiceNotification struct {
+	OrderId  string `json:"orderId"`
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (p *InvoicePaymentProvider) Name() string {
+	return "invoice"
+}
+
+func (p *InvoicePaymentProvider) Enabled() bool {
+	apiUrl, err := p.settingService.GetInvoiceApiUrl()
+	return err == nil && apiUrl != ""
+}
+
+func (p *InvoicePaymentProvider) CreateInvoice(payment *model.Payment, title string) (string, error) {
+	apiUrl, err := p.settingService.GetInvoiceApiUrl()
+	if err != nil {
+		return "", err
+	}
+	apiKey, err := p.settingService.GetInvoiceApiKey()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&invoiceRequest{
+		OrderId:     payment.IdempotencyKey,
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		Description: title,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, apiUrl, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", payment.IdempotencyKey)
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := invoiceClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", common.NewError("create invoice failed:", resp.Status)
+	}
+	invoice := &invoiceResponse{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(invoice)
+	if err != nil {
+		return "", err
+	}
+	if invoice.Url == "" {
+		return "", common.NewError("invoice has no payment link")
+	}
+	payment.ExternalId = invoice.Id
+	return invoice.Url, nil
+}
+
+func (p *InvoicePaymentProvider) ParseWebhook(header http.Header, body []byte) (*PaymentEvent, error) {
+	secret, err := p.settingService.GetInvoiceWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, common.NewError("invoice webhook secret is not set")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	signature, err := hex.DecodeString(header.Get("X-Signature"))
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, common.NewError("invalid invoice webhook signature")
+	}
+
+	notification := &invoiceNotification{}
+	err = json.Unmarshal(body, notification)
+	if err != nil {
+		return nil, err
+	}
+	event := &PaymentEvent{
+		Key:        notification.OrderId,
+		ExternalId: notification.Id,
+		Amount:     notification.Amount,
+		Currency:   notification.Currency,
+	}
+	switch strings.ToLower(notification.Status) {
+	case "paid", "confirmed", "completed":
+		event.Paid = true
+	case "expired", "failed", "cancelled", "canceled":
+		event.Paid = false
+	default:
+		return nil, nil
+	}
+	return event, nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/xray"
+)
+
+// fakePaymentProvider counts the invoices it was asked for
+type fakePaymentProvider struct {
+	invoices int
+}
+
+func (p *fakePaymentProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakePaymentProvider) Enabled() bool {
+	return true
+}
+
+func (p *fakePaymentProvider) CreateInvoice(payment *model.Payment, title string) (string, error) {
+	p.invoices++
+	payment.ExternalId = "inv-" + payment.IdempotencyKey
+	return "https://pay.example.com/" + payment.IdempotencyKey, nil
+}
+
+func initPaymentTest(t *testing.T) (*PaymentService, *fakePaymentProvider, *model.ClientPlan) {
+	err := database.InitDB("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if db, err := database.GetDB().DB(); err == nil {
+			db.Close()
+		}
+	})
+
+	provider := &fakePaymentProvider{}
+	RegisterPaymentProvider(provider)
+
+	inbound := &model.Inbound{
+		Port:           20000,
+		Protocol:       model.VLESS,
+		Enable:         true,
+		Tag:            "inbound-20000",
+		Settings:       `{"clients":[],"decryption":"none"}`,
+		StreamSettings: `{"network":"tcp","security":"none"}`,
+		Sniffing:       "{}",
+	}
+	db := database.GetDB()
+	err = db.Create(inbound).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &model.ClientPlan{Name: "month", TotalGB: 1 << 30, Days: 30, InboundIds: "1", Price: 5, Enable: true}
+	err = db.Create(plan).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&model.TgClient{ChatID: 101, Name: "customer"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PaymentService{}, provider, plan
+}
+
+func registrationRequest(plan *model.ClientPlan) *model.TgClientMsg {
+	return &model.TgClientMsg{ChatID: 101, Type: model.Registration, PlanId: plan.Id}
+}
+
+func TestCreatePaymentIdempotency(t *testing.T) {
+	s, provider, plan := initPaymentTest(t)
+
+	payment, link, err := s.CreatePayment("fake", "order-1", registrationRequest(plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link == "" || payment.Amount != 500 || payment.Currency != "USD" || payment.Status != model.PaymentPending {
+		t.Fatalf("unexpected payment %+v with link %q", payment, link)
+	}
+
+	again, _, err := s.CreatePayment("fake", "order-1", registrationRequest(plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != payment.Id || again.ExternalId != payment.ExternalId {
+		t.Errorf("repeated key created payment %v, want %v", again.Id, payment.Id)
+	}
+	if provider.invoices != 1 {
+		t.Errorf("provider was asked for %v invoices, want 1", provider.invoices)
+	}
+	var count int64
+	database.GetDB().Model(model.Payment{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%v payments stored, want 1", count)
+	}
+}
+
+func TestConfirmPaymentMismatch(t *testing.T) {
+	s, _, plan := initPaymentTest(t)
+
+	tests := []struct {
+		key      string
+		amount   int64
+		currency string
+	}{
+		{"order-amount", 499, "USD"},
+		{"order-currency", 500, "EUR"},
+	}
+	for _, test := range tests {
+		_, _, err := s.CreatePayment("fake", test.key, registrationRequest(plan))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.ConfirmPayment(&PaymentEvent{Key: test.key, Amount: test.amount, Currency: test.currency, Paid: true})
+		if err == nil {
+			t.Errorf("payment %v confirmed with %v %v", test.key, test.amount, test.currency)
+		}
+		payment, err := s.getPayment(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payment.Status != model.PaymentFailed {
+			t.Errorf("payment %v is %v, want %v", test.key, payment.Status, model.PaymentFailed)
+		}
+	}
+
+	var count int64
+	database.GetDB().Model(xray.ClientTraffic{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%v clients created for mismatched payments, want 0", count)
+	}
+}
+
+func TestConfirmPaymentDuplicateWebhook(t *testing.T) {
+	s, _, plan := initPaymentTest(t)
+
+	_, _, err := s.CreatePayment("fake", "order-1", registrationRequest(plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &PaymentEvent{Key: "order-1", ExternalId: "inv-order-1", Amount: 500, Currency: "usd", Paid: true}
+	for i := 0; i < 2; i++ {
+		err = s.ConfirmPayment(event)
+		if err != nil {
+			t.Fatalf("delivery %v: %v", i+1, err)
+		}
+	}
+
+	payment, err := s.getPayment("order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != model.PaymentPaid {
+		t.Errorf("payment is %v, want %v", payment.Status, model.PaymentPaid)
+	}
+	var count int64
+	database.GetDB().Model(xray.ClientTraffic{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%v clients created, want 1", count)
+	}
+}
+
+func TestInvoiceParseWebhookSignature(t *testing.T) {
+	initPaymentTest(t)
+	err := database.GetDB().Create(&model.Setting{Key: "invoiceWebhookSecret", Value: "secret"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"orderId":"order-1","id":"inv-1","status":"paid","amount":500,"currency":"USD"}`)
+	sign := func(secret string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name      string
+		signature string
+		body      []byte
+	}{
+		{"missing", "", body},
+		{"not hex", "not-a-signature", body},
+		{"other secret", sign("other", body), body},
+		{"other body", sign("secret", body), []byte(`{"orderId":"order-1","id":"inv-1","status":"paid","amount":1,"currency":"USD"}`)},
+	}
+	provider := &InvoicePaymentProvider{}
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set("X-Signature", test.signature)
+		event, err := provider.ParseWebhook(header, test.body)
+		if err == nil || event != nil {
+			t.Errorf("%v signature: got %+v, %v, want an error", test.name, event, err)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("X-Signature", sign("secret", body))
+	event, err := provider.ParseWebhook(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event == nil || !event.Paid || event.Key != "order-1" || event.Amount != 500 {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/util/common"
+)
+
+type PolicyLevelService struct {
+}
+
+func (s *PolicyLevelService) GetLevels() ([]*model.PolicyLevel, error) {
+	db := database.GetDB()
+	var levels []*model.PolicyLevel
+	err := db.Model(model.PolicyLevel{}).Order("level").Find(&levels).Error
+	if err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+func (s *PolicyLevelService) checkLevel(level *model.PolicyLevel) error {
+	level.Name = strings.TrimSpace(level.Name)
+	// level 0 is the default level of the config template
+	if level.Level < 1 {
+		return common.NewError("policy level must be at least 1:", level.Level)
+	}
+	if level.BufferSize < 0 || level.Handshake < 0 || level.ConnIdle < 0 || level.UplinkOnly < 0 || level.DownlinkOnly < 0 {
+		return common.NewError("policy level values can not be negative:", level.Level)
+	}
+	return nil
+}
+
+func (s *PolicyLevelService) AddLevel(level *model.PolicyLevel) error {
+	err := s.checkLevel(level)
+	if err != nil {
+		return err
+	}
+	level.Id = 0
+	db := database.GetDB()
+	return db.Create(level).Error
+}
+
+func (s *PolicyLevelService) UpdateLevel(level *model.PolicyLevel) error {
+	err := s.checkLevel(level)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	err = db.Model(model.PolicyLevel{}).First(&model.PolicyLevel{}, level.Id).Error
+	if err != nil {
+		return err
+	}
+	return db.Save(level).Error
+}
+
+func (s *PolicyLevelService) DelLevel(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.PolicyLevel{}, id).Error
+}
+
+// ApplyLevels adds the policy levels to the policy of the config template, levels of the template are
+// overridden and user stats stay on so the traffic of clients on every level is still counted
+func (s *PolicyLevelService) ApplyLevels(policyConfig []byte) ([]byte, error) {
+	levels, err := s.GetLevels()
+	if err != nil || len(levels) == 0 {
+		return policyConfig, err
+	}
+
+	policy := map[string]interface{}{}
+	if len(policyConfig) > 0 {
+		err = json.Unmarshal(policyConfig, &policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	policyLevels, _ := policy["levels"].(map[string]interface{})
+	if policyLevels == nil {
+		policyLevels = map[string]interface{}{}
+	}
+	for _, level := range levels {
+		policyLevel := map[string]interface{}{
+			"statsUserUplink":   true,
+			"statsUserDownlink": true,
+		}
+		if level.BufferSize > 0 {
+			policyLevel["bufferSize"] = level.BufferSize
+		}
+		if level.Handshake > 0 {
+			policyLevel["handshake"] = level.Handshake
+		}
+		if level.ConnIdle > 0 {
+			policyLevel["connIdle"] = level.ConnIdle
+		}
+		if level.UplinkOnly > 0 {
+			policyLevel["uplinkOnly"] = level.UplinkOnly
+		}
+		if level.DownlinkOnly > 0 {
+			policyLevel["downlinkOnly"] = level.DownlinkOnly
+		}
+		policyLevels[strconv.Itoa(level.Level)] = policyLevel
+	}
+	policy["levels"] = policyLevels
+	return json.Marshal(policy)
+}
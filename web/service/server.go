@@ -3,6 +3,7 @@ package service
 import (
 	"archive/zip"
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,11 +11,16 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"x-ui/config"
 	"x-ui/logger"
+	"x-ui/util/common"
 	"x-ui/util/sys"
 	"x-ui/xray"
 
@@ -66,6 +72,7 @@ type Status struct {
 		Sent uint64 `json:"sent"`
 		Recv uint64 `json:"recv"`
 	} `json:"netTraffic"`
+	Outbounds []*xray.OutboundStatus `json:"outbounds"`
 }
 
 type Release struct {
@@ -73,9 +80,12 @@ type Release struct {
 }
 
 type ServerService struct {
-	xrayService XrayService
+	xrayService    XrayService
+	settingService SettingService
 }
 
+var xrayInstallLock sync.Mutex
+
 func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 	now := time.Now()
 	status := &Status{
@@ -171,6 +181,13 @@ func (s *ServerService) GetStatus(lastStatus *Status) *Status {
 	}
 	status.Xray.Version = s.xrayService.GetXrayVersion()
 
+	if status.Xray.State == Running {
+		status.Outbounds, err = s.xrayService.GetOutboundStatus()
+		if err != nil {
+			logger.Debug("get outbound status failed:", err)
+		}
+	}
+
 	return status
 }
 
@@ -225,7 +242,13 @@ func (s *ServerService) RestartXrayService() (string error) {
 	return nil
 }
 
-func (s *ServerService) downloadXRay(version string) (string, error) {
+type XrayVersion struct {
+	Version string `json:"version"`
+	Active  bool   `json:"active"`
+	Time    int64  `json:"time"`
+}
+
+func (s *ServerService) getXrayZipName() string {
 	osName := runtime.GOOS
 	arch := runtime.GOARCH
 
@@ -241,43 +264,98 @@ func (s *ServerService) downloadXRay(version string) (string, error) {
 		arch = "arm64-v8a"
 	}
 
-	fileName := fmt.Sprintf("Xray-%s-%s.zip", osName, arch)
-	url := fmt.Sprintf("https://github.com/mhsanaei/Xray-core/releases/download/%s/%s", version, fileName)
-	resp, err := http.Get(url)
+	return fmt.Sprintf("Xray-%s-%s.zip", osName, arch)
+}
+
+func (s *ServerService) downloadXRay(version string) (string, string, error) {
+	mirror, err := s.settingService.GetXrayMirrorUrl()
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	fileName := s.getXrayZipName()
+	url := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(mirror, "/"), version, fileName)
+
+	digest, err := fetchXrayDigest(url + ".dgst")
+	if err != nil {
+		return "", "", err
 	}
-	defer resp.Body.Close()
 
 	os.Remove(fileName)
-	file, err := os.Create(fileName)
+	_, err = downloadFile(url, fileName)
+	if err != nil {
+		os.Remove(fileName)
+		return "", "", err
+	}
+
+	return fileName, digest, nil
+}
+
+func fetchXrayDigest(url string) (string, error) {
+	reader, _, err := openSource(url)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	defer reader.Close()
 
-	_, err = io.Copy(file, resp.Body)
+	data, err := io.ReadAll(io.LimitReader(reader, 8192))
 	if err != nil {
 		return "", err
 	}
+	return parseXrayDigest(string(data))
+}
 
-	return fileName, nil
+// parseXrayDigest accepts both the release .dgst format (SHA2-256= <hex>) and sha256sum output
+func parseXrayDigest(dgst string) (string, error) {
+	for _, line := range strings.Split(dgst, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "SHA2-256=") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "SHA2-256=")), nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && len(fields[0]) == 64 {
+			if _, err := hex.DecodeString(fields[0]); err == nil {
+				return fields[0], nil
+			}
+		}
+	}
+	return "", common.NewError("no sha256 digest found")
 }
 
 func (s *ServerService) UpdateXray(version string) error {
-	zipFileName, err := s.downloadXRay(version)
+	zipFileName, digest, err := s.downloadXRay(version)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(zipFileName)
+
+	return s.installXrayZip(zipFileName, digest)
+}
+
+func (s *ServerService) InstallXrayFromFile(zipFileName string, dgst string) error {
+	digest, err := parseXrayDigest(dgst)
 	if err != nil {
 		return err
 	}
+	return s.installXrayZip(zipFileName, digest)
+}
+
+func (s *ServerService) installXrayZip(zipFileName string, digest string) error {
+	xrayInstallLock.Lock()
+	defer xrayInstallLock.Unlock()
+
+	checksum, _, err := fileChecksum(zipFileName)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(checksum, digest) {
+		return common.NewErrorf("xray package checksum mismatch: expected %v, got %v", digest, checksum)
+	}
 
 	zipFile, err := os.Open(zipFileName)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		zipFile.Close()
-		os.Remove(zipFileName)
-	}()
+	defer zipFile.Close()
 
 	stat, err := zipFile.Stat()
 	if err != nil {
@@ -288,21 +366,14 @@ func (s *ServerService) UpdateXray(version string) error {
 		return err
 	}
 
-	s.xrayService.StopXray()
-	defer func() {
-		err := s.xrayService.RestartXray(true)
-		if err != nil {
-			logger.Error("start xray failed:", err)
-		}
-	}()
-
-	copyZipFile := func(zipName string, fileName string) error {
+	copyZipFile := func(zipName string, fileName string, perm fs.FileMode) error {
 		zipFile, err := reader.Open(zipName)
 		if err != nil {
 			return err
 		}
+		defer zipFile.Close()
 		os.Remove(fileName)
-		file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fs.ModePerm)
+		file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
 		if err != nil {
 			return err
 		}
@@ -311,50 +382,246 @@ func (s *ServerService) UpdateXray(version string) error {
 		return err
 	}
 
-	err = copyZipFile("xray", xray.GetBinaryPath())
+	newBinaryPath := xray.GetBinaryPath() + ".new"
+	defer os.Remove(newBinaryPath)
+	err = copyZipFile("xray", newBinaryPath, 0755)
 	if err != nil {
 		return err
 	}
-	err = copyZipFile("geosite.dat", xray.GetGeositePath())
+	version := xray.GetBinaryVersion(newBinaryPath)
+	if version == "Unknown" {
+		return common.NewError("xray binary in the package can not run on this server")
+	}
+
+	err = os.MkdirAll(xray.GetVersionFolderPath(version), fs.ModePerm)
 	if err != nil {
 		return err
 	}
-	err = copyZipFile("geoip.dat", xray.GetGeoipPath())
+	err = os.Rename(newBinaryPath, xray.GetVersionBinaryPath(version))
 	if err != nil {
 		return err
 	}
-	err = copyZipFile("iran.dat", xray.GetIranPath())
+
+	// geo files are kept up to date by GeoService, only fill the missing ones
+	for _, name := range []string{"geosite.dat", "geoip.dat", "iran.dat"} {
+		path := xray.GetGeoAssetPath(name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			err = copyZipFile(name, path, 0644)
+			if err != nil {
+				logger.Warning("copy", name, "from xray package failed:", err)
+			}
+		}
+	}
+
+	return s.activateXray(version)
+}
+
+func (s *ServerService) GetInstalledXrayVersions() ([]*XrayVersion, error) {
+	entries, err := os.ReadDir(xray.GetVersionsFolderPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	current := xray.GetBinaryVersion(xray.GetBinaryPath())
+	versions := make([]*XrayVersion, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, &XrayVersion{
+			Version: entry.Name(),
+			Active:  entry.Name() == current,
+			Time:    info.ModTime().Unix() * 1000,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Time > versions[j].Time
+	})
+	return versions, nil
+}
+
+func (s *ServerService) RollbackXray(version string) error {
+	xrayInstallLock.Lock()
+	defer xrayInstallLock.Unlock()
+
+	if filepath.Base(version) != version {
+		return common.NewError("invalid xray version:", version)
+	}
+	_, err := os.Stat(xray.GetVersionBinaryPath(version))
+	if err != nil {
+		return common.NewError("xray version is not installed:", version)
+	}
+	return s.activateXray(version)
+}
+
+// activateXray swaps the running binary with a kept version and restores the original one if it can not start
+func (s *ServerService) activateXray(version string) error {
+	newBinaryPath := xray.GetVersionBinaryPath(version)
+	err := s.testXrayBinary(newBinaryPath)
 	if err != nil {
 		return err
 	}
 
+	binaryPath := xray.GetBinaryPath()
+	backupPath := binaryPath + ".bak"
+	hasOriginal := false
+	if _, err := os.Stat(binaryPath); err == nil {
+		previous := xray.GetBinaryVersion(binaryPath)
+		if previous != "Unknown" && previous != version {
+			err = s.keepXrayVersion(previous)
+			if err != nil {
+				return err
+			}
+		}
+		// the kept version may be missing or the same as the new one, so always restore from an exact copy
+		err = copyFile(binaryPath, backupPath, 0755)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(backupPath)
+		hasOriginal = true
+	}
+
+	s.xrayService.StopXray()
+	err = copyFile(newBinaryPath, binaryPath, 0755)
+	if err == nil {
+		err = s.startXrayAndCheck()
+	}
+	if err != nil {
+		s.xrayService.StopXray()
+		if !hasOriginal {
+			os.Remove(binaryPath)
+			return err
+		}
+		logger.Warningf("xray %v failed, restore the original binary: %v", version, err)
+		restoreErr := copyFile(backupPath, binaryPath, 0755)
+		if restoreErr == nil {
+			restoreErr = s.xrayService.RestartXray(true)
+		}
+		if restoreErr != nil {
+			return common.NewErrorf("xray %v failed: %v, restore of the original binary failed: %v", version, err, restoreErr)
+		}
+		return common.NewErrorf("xray %v failed and the original binary was restored: %v", version, err)
+	}
+
+	s.pruneXrayVersions(version)
 	return nil
+}
+
+func (s *ServerService) testXrayBinary(binaryPath string) error {
+	xrayConfig, err := s.xrayService.GetXrayConfig()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(xrayConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	testConfigPath := config.GetBinFolderPath() + "/config.test.json"
+	err = os.WriteFile(testConfigPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(testConfigPath)
 
+	return xray.TestBinary(binaryPath, testConfigPath)
 }
 
-func (s *ServerService) GetLogs(count string) ([]string, error) {
-	// Define the journalctl command and its arguments
-	var cmdArgs []string
-	if runtime.GOOS == "linux" {
-		cmdArgs = []string{"journalctl", "-u", "x-ui", "--no-pager", "-n", count}
-	} else {
-		return []string{"Unsupported operating system"}, nil
+func (s *ServerService) startXrayAndCheck() error {
+	err := s.xrayService.RestartXray(true)
+	if err != nil {
+		return err
+	}
+	err = p.WaitReady(10 * time.Second)
+	if err != nil {
+		return common.NewError("xray failed after start:", err, s.xrayService.GetXrayResult())
 	}
+	return nil
+}
 
-	// Run the command
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+func (s *ServerService) keepXrayVersion(version string) error {
+	versionPath := xray.GetVersionBinaryPath(version)
+	if _, err := os.Stat(versionPath); err == nil {
+		return nil
+	}
+	err := os.MkdirAll(xray.GetVersionFolderPath(version), fs.ModePerm)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return copyFile(xray.GetBinaryPath(), versionPath, 0755)
+}
 
-	lines := strings.Split(out.String(), "\n")
+func (s *ServerService) pruneXrayVersions(current string) {
+	keep, err := s.settingService.GetXrayKeepVersions()
+	if err != nil {
+		logger.Warning("get xray keep versions failed:", err)
+		return
+	}
+	versions, err := s.GetInstalledXrayVersions()
+	if err != nil {
+		logger.Warning("list xray versions failed:", err)
+		return
+	}
+	kept := 0
+	for _, version := range versions {
+		if version.Version == current {
+			continue
+		}
+		kept++
+		if kept > keep {
+			err = os.RemoveAll(xray.GetVersionFolderPath(version.Version))
+			if err != nil {
+				logger.Warning("remove xray version", version.Version, "failed:", err)
+			}
+		}
+	}
+}
+
+func copyFile(src string, dst string, perm fs.FileMode) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	tmpPath := dst + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, srcFile)
+	file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+func (s *ServerService) GetLogs(count string) ([]string, error) {
+	limit, err := strconv.Atoi(count)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := logger.Search(&logger.Filter{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
 
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.String())
+	}
 	return lines, nil
 }
 
+func (s *ServerService) SearchLogs(filter *logger.Filter) ([]*logger.Entry, error) {
+	return logger.Search(filter)
+}
+
 func (s *ServerService) GetConfigJson() (interface{}, error) {
 	// Open the file for reading
 	file, err := os.Open(xray.GetConfigPath())
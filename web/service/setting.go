@@ -29,16 +29,28 @@ var defaultValueMap = map[string]string{
 	"secret":                   random.Seq(32),
 	"webBasePath":              "/",
 	"timeLocation":             "Asia/Tehran",
+	"geoUpdateRuntime":         "@daily",
+	"xrayMirrorUrl":            "https://github.com/mhsanaei/Xray-core/releases/download",
+	"xrayKeepVersions":         "3",
+	"ipLimitWebhook":           "",
+	"subURI":                   "",
+	"paymentCurrency":          "USD",
+	"paymentPriceScale":        "100",
+	"tgPaymentToken":           "",
+	"invoiceApiUrl":            "",
+	"invoiceApiKey":            "",
+	"invoiceWebhookSecret":     "",
 	"tgBotEnable":              "false",
 	"tgBotToken":               "",
-	"tgBotChatId":              "0",
+	"tgBotChatId":              "",
 	"tgRunTime":                "",
 	"tgCrmEnabled":             "false",
 	"tgClientRegFinalMsg":      "Congratulations! Your account is created. You will soon receive an email.",
 	"tgMoneyTransferMsg":       "Please transfer the fee to the following wallet/account and send over the receipt.",
-	"tgClientRegAccList":       "1- One user 1 month\n2- Two users 2 months",
 	"telegramCrmTargetInbound": "1",
 	"tgReferToFriendsMsg":      "You can refer us to friends and family by forwarding them this message.",
+	"tgReferralRewardDays":     "0",
+	"tgReferralRewardTraffic":  "0",
 	"tgContactSupportMsg":      "You can contact us via @support.",
 }
 
@@ -209,12 +221,37 @@ func (s *SettingService) SetTgBotToken(token string) error {
 	return s.setString("tgBotToken", token)
 }
 
-func (s *SettingService) GetTgBotChatId() (int, error) {
-	return s.getInt("tgBotChatId")
+func (s *SettingService) GetTgBotChatId() (string, error) {
+	return s.getString("tgBotChatId")
 }
 
-func (s *SettingService) SetTgBotChatId(chatId int) error {
-	return s.setInt("tgBotChatId", chatId)
+func (s *SettingService) SetTgBotChatId(chatIds string) error {
+	return s.setString("tgBotChatId", chatIds)
+}
+
+// GetTgBotAdminIds parses the comma separated chat ids of the bot admins
+func (s *SettingService) GetTgBotAdminIds() ([]int64, error) {
+	chatIds, err := s.GetTgBotChatId()
+	if err != nil {
+		return nil, err
+	}
+	adminIds := make([]int64, 0)
+	for _, chatId := range strings.Split(chatIds, ",") {
+		chatId = strings.TrimSpace(chatId)
+		if chatId == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(chatId, 10, 64)
+		if err != nil {
+			return nil, common.NewError("invalid telegram chat id:", chatId)
+		}
+		// 0 was the default of the setting when it held a single id
+		if id == 0 {
+			continue
+		}
+		adminIds = append(adminIds, id)
+	}
+	return adminIds, nil
 }
 
 func (s *SettingService) SetTgbotenabled(value bool) error {
@@ -288,6 +325,26 @@ func (s *SettingService) GetTimeLocation() (*time.Location, error) {
 	return location, nil
 }
 
+func (s *SettingService) GetGeoUpdateRuntime() (string, error) {
+	return s.getString("geoUpdateRuntime")
+}
+
+func (s *SettingService) GetXrayMirrorUrl() (string, error) {
+	return s.getString("xrayMirrorUrl")
+}
+
+func (s *SettingService) GetXrayKeepVersions() (int, error) {
+	return s.getInt("xrayKeepVersions")
+}
+
+func (s *SettingService) GetIpLimitWebhook() (string, error) {
+	return s.getString("ipLimitWebhook")
+}
+
+func (s *SettingService) GetSubURI() (string, error) {
+	return s.getString("subURI")
+}
+
 /*********************************************************
 * Telegram CRM
 *********************************************************/
@@ -308,10 +365,6 @@ func (s *SettingService) GetTgMoneyTransferMsg() (string, error) {
 	return s.getString("tgMoneyTransferMsg")
 }
 
-func (s *SettingService) GetTgCrmRegAccList() (string, error) {
-	return s.getString("tgClientRegAccList")
-}
-
 func (s *SettingService) GetTgReferToFriendsMsg() (string, error) {
 	return s.getString("tgReferToFriendsMsg")
 }
@@ -320,6 +373,44 @@ func (s *SettingService) GetTgContactSupportMsg() (string, error) {
 	return s.getString("tgContactSupportMsg")
 }
 
+func (s *SettingService) GetTgReferralRewardDays() (int, error) {
+	return s.getInt("tgReferralRewardDays")
+}
+
+// GetTgReferralRewardTraffic is the bonus traffic of a referral in GB
+func (s *SettingService) GetTgReferralRewardTraffic() (int, error) {
+	return s.getInt("tgReferralRewardTraffic")
+}
+
+/*********************************************************
+* Payments
+*********************************************************/
+
+func (s *SettingService) GetPaymentCurrency() (string, error) {
+	return s.getString("paymentCurrency")
+}
+
+// GetPaymentPriceScale is the number of the smallest units of the payment currency in one unit of the plan price
+func (s *SettingService) GetPaymentPriceScale() (int, error) {
+	return s.getInt("paymentPriceScale")
+}
+
+func (s *SettingService) GetTgPaymentToken() (string, error) {
+	return s.getString("tgPaymentToken")
+}
+
+func (s *SettingService) GetInvoiceApiUrl() (string, error) {
+	return s.getString("invoiceApiUrl")
+}
+
+func (s *SettingService) GetInvoiceApiKey() (string, error) {
+	return s.getString("invoiceApiKey")
+}
+
+func (s *SettingService) GetInvoiceWebhookSecret() (string, error) {
+	return s.getString("invoiceWebhookSecret")
+}
+
 /*********************************************************
 * End of Telegram CRM
 *********************************************************/
@@ -15,8 +15,9 @@ import (
 )
 
 type SubService struct {
-	address        string
-	inboundService InboundService
+	address         string
+	inboundService  InboundService
+	customerService CustomerService
 }
 
 func (s *SubService) GetSubs(subId string, host string) ([]string, string, error) {
@@ -66,6 +67,13 @@ func (s *SubService) GetSubs(subId string, host string) ([]string, string, error
 			}
 		}
 	}
+	// a customer subscription reports the shared quota instead of the sum of its clients
+	if customer, err := s.customerService.GetCustomerBySubId(subId); err == nil {
+		traffic.Up = customer.Up
+		traffic.Down = customer.Down
+		traffic.Total = customer.TotalGB
+		traffic.ExpiryTime = customer.ExpiryTime
+	}
 	header = fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", traffic.Up, traffic.Down, traffic.Total, traffic.ExpiryTime/1000)
 	return result, header, nil
 }
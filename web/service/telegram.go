@@ -1,7 +1,6 @@
 package service
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -11,30 +10,29 @@ import (
 	"x-ui/util/common"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const (
 	updateCommandPrefix = string("update:")
 	renewCommandPrefix  = string("renew:")
+	payCommandPrefix    = string("pay:")
+	langCommandPrefix   = string("lang:")
+
+	// admins get the messages of the bot in English until they choose a language
+	adminLang = string("en")
 )
 
 type TelegramService struct {
-	inboundService InboundService
-	settingService SettingService
+	inboundService    InboundService
+	settingService    SettingService
+	clientPlanService ClientPlanService
+	clientBulkService ClientBulkService
+	xrayService       XrayService
 }
 
 func (j *TelegramService) GetAllClientUsages(chatId int64) {
-	tgBottoken, err := j.settingService.GetTgBotToken()
-	if err != nil || tgBottoken == "" {
-		logger.Error("GetAllClientUsages failed, GetTgBotToken fail:", err)
-		return
-	}
-	bot, err := tgbotapi.NewBotAPI(tgBottoken)
-	if err != nil {
-		logger.Error("Get tgbot error:", err)
-		return
-	}
-
 	client, err := j.getTgClient(chatId)
 	if err != nil {
 		logger.Error(err)
@@ -46,13 +44,13 @@ func (j *TelegramService) GetAllClientUsages(chatId int64) {
 		lang = client.Language
 	}
 
-	uuids := strings.Split(client.Uid, ",")
+	uuids := client.GetUids()
 
 	crmEnabled := j.settingService.GetTgCrmEnabled()
 	for _, uuid := range uuids {
 		resp, err := j.GetClientUsage(chatId, uuid, crmEnabled, lang)
 		if err == nil {
-			bot.Send(resp)
+			tgSend(resp)
 		}
 	}
 }
@@ -69,87 +67,40 @@ func (j *TelegramService) GetClientUsage(chatId int64, uuid string, showRenewBtn
 	}
 	expiryTime := ""
 	if traffic.ExpiryTime == 0 {
-		expiryTime = fmt.Sprintf("unlimited")
+		expiryTime = Tr("unlimited", lang)
 	} else {
-		expiryTime = fmt.Sprintf("%s", time.Unix((traffic.ExpiryTime/1000), 0).Format("2006-01-02 15:04:05"))
+		expiryTime = time.Unix((traffic.ExpiryTime / 1000), 0).Format("2006-01-02 15:04:05")
 	}
 	total := ""
 	if traffic.Total == 0 {
-		total = fmt.Sprintf("unlimited")
+		total = Tr("unlimited", lang)
 	} else {
-		total = fmt.Sprintf("%s", common.FormatTraffic((traffic.Total)))
+		total = common.FormatTraffic((traffic.Total))
 	}
-	active := "No"
+	active := Tr("no", lang)
 	if traffic.Enable {
-		active = "Yes"
+		active = Tr("yes", lang)
 	}
-	resp.Text += fmt.Sprintf("💡 Active: %s\r\n📧 Name: %s\r\n🔄 Total: %s / %s\r\n📅 Expires on: %s\r\n\r\n",
-		active, traffic.Email, common.FormatTraffic((traffic.Up + traffic.Down)),
-		total, expiryTime)
+	resp.Text += Tr("usageCard", lang,
+		"Active=="+active,
+		"Email=="+traffic.Email,
+		"Used=="+common.FormatTraffic((traffic.Up+traffic.Down)),
+		"Total=="+total,
+		"Expiry=="+expiryTime)
 
 	buttons := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(Tr("update", lang), updateCommandPrefix+uuid))
 	if showRenewBtn {
 		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(Tr("renew", lang), renewCommandPrefix+uuid))
 	}
-	resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons)
+	selfService := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(Tr("subLink", lang), linkCommandPrefix+uuid),
+		tgbotapi.NewInlineKeyboardButtonData(Tr("clientIps", lang), ipsCommandPrefix+uuid),
+		tgbotapi.NewInlineKeyboardButtonData(Tr("rotateKey", lang), rotateCommandPrefix+uuid),
+	)
+	resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons, selfService)
 	return &resp, nil
 }
 
-func (j *TelegramService) NotifyUsersAboutToExpire() {
-	clients, err := j.GetTgClients()
-	if err != nil {
-		return
-	}
-
-	tgBottoken, err := j.settingService.GetTgBotToken()
-	if err != nil || tgBottoken == "" {
-		logger.Error("GetAllClientUsages failed, GetTgBotToken fail:", err)
-		return
-	}
-	bot, err := tgbotapi.NewBotAPI(tgBottoken)
-	if err != nil {
-		logger.Error("Get tgbot error:", err)
-		return
-	}
-
-	for i := range clients {
-		uuids := strings.Split(clients[i].Uid, ",")
-		lang := defaultLang
-		if clients[i].Language != "" {
-			lang = clients[i].Language
-		}
-
-		for _, uuid := range uuids {
-			traffic, err := j.inboundService.GetClientTrafficById(uuid)
-			if err != nil || !traffic.Enable {
-				continue
-			}
-			if traffic.Total > 0 && ((traffic.Up + traffic.Down) > traffic.Total*85/100) {
-				usageMsg, err := j.GetClientUsage(clients[i].ChatID, uuid, j.settingService.GetTgCrmEnabled(), lang)
-				if err != nil {
-					continue
-				}
-				msg := tgbotapi.NewMessage(clients[i].ChatID, Tr("msgTrafficExceeds85", lang))
-				bot.Send(msg)
-				bot.Send(usageMsg)
-			} else if traffic.ExpiryTime > 0 {
-				remainingHours := time.Unix((traffic.ExpiryTime / 1000), 0).Sub(time.Now())
-				if remainingHours <= time.Hour*24 {
-					usageMsg, err := j.GetClientUsage(clients[i].ChatID, uuid, j.settingService.GetTgCrmEnabled(), lang)
-					if err != nil {
-						continue
-					}
-					msg := tgbotapi.NewMessage(clients[i].ChatID, Tr("msgAccExpiringSoon", lang))
-					bot.Send(msg)
-
-					bot.Send(usageMsg)
-				}
-			}
-		}
-	}
-
-}
-
 func (j *TelegramService) CheckIfClientExists(uuid string) bool {
 	if strings.TrimSpace(uuid) == "" {
 		return false
@@ -162,15 +113,15 @@ func (j *TelegramService) CheckIfClientExists(uuid string) bool {
 }
 
 func (t *TelegramService) AddTgClient(client *model.TgClient) error {
-	db := database.GetTgDB()
+	db := database.GetDB()
 	err := db.Create(client).Error
 	return err
 }
 
 func (t *TelegramService) GetTgClients() ([]*model.TgClient, error) {
-	db := database.GetTgDB()
+	db := database.GetDB()
 	var clients []*model.TgClient
-	err := db.Model(&model.TgClient{}).Find(&clients).Error
+	err := db.Model(&model.TgClient{}).Preload("Uids").Find(&clients).Error
 	if err != nil {
 		logger.Error(err)
 		return nil, err
@@ -178,23 +129,26 @@ func (t *TelegramService) GetTgClients() ([]*model.TgClient, error) {
 	return clients, nil
 }
 
+// UpdateClient saves the client and links ClientUid to it, uids linked before are kept
 func (t *TelegramService) UpdateClient(client *model.TgClient) error {
-
-	db := database.GetTgDB()
-	dbClient, err := t.getTgClient(client.ChatID)
-	if err == nil && dbClient.Uid != "" {
-		if !strings.Contains(dbClient.Uid, client.Uid) {
-			client.Uid = dbClient.Uid + "," + client.Uid
-		} else {
-			client.Uid = dbClient.Uid
+	db := database.GetDB()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit(clause.Associations).Save(client).Error
+		if err != nil {
+			return err
 		}
-		client.Enabled = true
-	}
-	return db.Save(client).Error
+		if client.ClientUid == "" {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.TgClientUid{
+			ChatID: client.ChatID,
+			Uid:    client.ClientUid,
+		}).Error
+	})
 }
 
 func (t *TelegramService) RegisterClient(client *model.TgClient) error {
-	uuid := client.Uid
+	uuid := client.ClientUid
 	err := t.UpdateClient(client)
 	if err != nil {
 		logger.Error("RegisterClient error:", err)
@@ -234,8 +188,14 @@ func (t *TelegramService) RenewClient(client *model.TgClient) error {
 }
 
 func (t *TelegramService) DeleteClient(id int64) error {
-	db := database.GetTgDB()
-	err := db.Select("TgClientMsgs").Delete(&model.TgClient{ChatID: id}).Error
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Select(clause.Associations).Delete(&model.TgClient{ChatID: id}).Error
+		if err != nil {
+			return err
+		}
+		return t.deleteSession(tx, id)
+	})
 	if err != nil {
 		logger.Error(err)
 		return err
@@ -244,9 +204,9 @@ func (t *TelegramService) DeleteClient(id int64) error {
 }
 
 func (t *TelegramService) getTgClient(id int64) (*model.TgClient, error) {
-	db := database.GetTgDB()
+	db := database.GetDB()
 	client := &model.TgClient{}
-	err := db.Model(&model.TgClient{}).First(&client, id).Error
+	err := db.Model(&model.TgClient{}).Preload("Uids").First(client, id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -259,10 +219,10 @@ func (t *TelegramService) replaceMarkup(msg *string, chatId int64, uuid string)
 }
 
 func (t *TelegramService) HandleMessage(msg *tgbotapi.Message) *tgbotapi.MessageConfig {
-	if _, exists := TgSessions[msg.Chat.ID]; !exists {
-		TgSessions[msg.Chat.ID] = InitFSM()
-	}
-	return TgSessions[msg.Chat.ID].state(TgSessions[msg.Chat.ID], msg)
+	session := t.getSession(msg.Chat.ID)
+	resp := session.state(session, msg)
+	t.saveSession(msg.Chat.ID, session)
+	return resp
 }
 
 func (t *TelegramService) HandleCallback(callback *tgbotapi.CallbackQuery) (resp *tgbotapi.MessageConfig, delete bool, update bool) {
@@ -284,10 +244,13 @@ func (t *TelegramService) HandleCallback(callback *tgbotapi.CallbackQuery) (resp
 		}
 		return
 	} else if strings.HasPrefix(callback.Data, renewCommandPrefix) {
-		if _, exists := TgSessions[callback.Message.Chat.ID]; !exists {
-			TgSessions[chatId] = InitFSM()
-		}
-		resp = TgSessions[chatId].RenewAccount(chatId, strings.TrimPrefix(callback.Data, renewCommandPrefix))
+		session := t.getSession(chatId)
+		resp = session.RenewAccount(chatId, strings.TrimPrefix(callback.Data, renewCommandPrefix))
+		t.saveSession(chatId, session)
+		delete = false
+		update = false
+		return
+	} else if resp = t.handleSelfService(callback); resp != nil {
 		delete = false
 		update = false
 		return
@@ -300,7 +263,8 @@ func (t *TelegramService) HandleCallback(callback *tgbotapi.CallbackQuery) (resp
 			FirstName: callback.From.FirstName,
 			LastName:  callback.From.LastName,
 		},
-		Text: callback.Data,
+		MessageID: callback.Message.MessageID,
+		Text:      callback.Data,
 	})
 	delete = true
 	update = false
@@ -308,80 +272,71 @@ func (t *TelegramService) HandleCallback(callback *tgbotapi.CallbackQuery) (resp
 	return
 }
 
-func (t *TelegramService) CanAcceptPhoto(chatId int64) bool {
-	if _, exists := TgSessions[chatId]; !exists {
-		TgSessions[chatId] = InitFSM()
+// handleSelfService runs the actions of customers on their own clients, it returns nil for other callbacks
+func (t *TelegramService) handleSelfService(callback *tgbotapi.CallbackQuery) *tgbotapi.MessageConfig {
+	chatId := callback.Message.Chat.ID
+	lang := t.getClientLang(chatId)
+	switch data := callback.Data; {
+	case strings.HasPrefix(data, linkCommandPrefix):
+		return t.SendClientLink(chatId, strings.TrimPrefix(data, linkCommandPrefix), lang)
+	case strings.HasPrefix(data, rotateCommandPrefix):
+		return t.RotateClient(chatId, strings.TrimPrefix(data, rotateCommandPrefix), lang)
+	case strings.HasPrefix(data, ipsCommandPrefix):
+		return t.GetClientIps(chatId, strings.TrimPrefix(data, ipsCommandPrefix), lang)
+	case strings.HasPrefix(data, clearIpsCommandPrefix):
+		return t.ClearClientIps(chatId, strings.TrimPrefix(data, clearIpsCommandPrefix), lang)
 	}
-	return TgSessions[chatId].canAcceptPhoto
+	return nil
 }
 
 func (t *TelegramService) SendMsgToTgBot(chatId int64, msg string) error {
-
-	tgBottoken, err := t.settingService.GetTgBotToken()
-	if err != nil || tgBottoken == "" {
-		logger.Error("SendMsgToTgBot failed, GetTgBotToken fail:", err)
-		return err
-	}
-	bot, err := tgbotapi.NewBotAPI(tgBottoken)
-	if err != nil {
-		logger.Error("SendMsgToTgBot failed, NewBotAPI fail:", err)
-		return err
-	}
-
 	info := tgbotapi.NewMessage(chatId, msg)
 	info.ParseMode = "HTML"
 	info.DisableWebPagePreview = true
-	bot.Send(info)
-	return nil
-}
-
-func (t *TelegramService) BroadcastMsgToBot(msg string) error {
-
-	tgBottoken, err := t.settingService.GetTgBotToken()
-	if err != nil || tgBottoken == "" {
-		logger.Error("SendMsgToTgBot failed, GetTgBotToken fail:", err)
-		return err
-	}
-	bot, err := tgbotapi.NewBotAPI(tgBottoken)
+	err := tgSend(info)
 	if err != nil {
-		logger.Error("SendMsgToTgBot failed, NewBotAPI fail:", err)
-		return err
+		logger.Error("SendMsgToTgBot failed:", err)
 	}
+	return err
+}
 
-	clients, err := t.GetTgClients()
-	if err != nil {
-		return err
+// SendTrToAdmins sends the message key to every admin in the language of its chat
+func (t *TelegramService) SendTrToAdmins(key string, params ...string) error {
+	if len(adminIds) == 0 {
+		return common.NewError("no telegram admins")
 	}
-
-	for i := range clients {
-		info := tgbotapi.NewMessage(clients[i].ChatID, msg)
-		info.ParseMode = "HTML"
-		info.DisableWebPagePreview = true
-		bot.Send(info)
+	for _, adminId := range adminIds {
+		err := t.SendMsgToTgBot(adminId, Tr(key, t.getChatLang(adminId, adminLang), params...))
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (t *TelegramService) SendMsgToAdmin(msg string) error {
-	adminId, err := t.settingService.GetTgBotChatId()
-	if err != nil {
-		logger.Error("SendMsgToAdmin failed, NewBotAPI fail:", err)
-		return err
+	if len(adminIds) == 0 {
+		return common.NewError("no telegram admins")
+	}
+	for _, adminId := range adminIds {
+		err := t.SendMsgToTgBot(adminId, msg)
+		if err != nil {
+			return err
+		}
 	}
-	t.SendMsgToTgBot(int64(adminId), msg)
 	return nil
 }
 
 func (t *TelegramService) PushTgClientMsg(clientMsg *model.TgClientMsg) error {
-	db := database.GetTgDB()
+	db := database.GetDB()
 	err := db.Create(clientMsg).Error
 	return err
 }
 
 func (t *TelegramService) GetTgClientMsgs() ([]*model.TgClientMsg, error) {
-	db := database.GetTgDB().Model(&model.TgClientMsg{})
+	db := database.GetDB().Model(&model.TgClientMsg{})
 	var msgs []*model.TgClientMsg
-	err := db.Find(&msgs).Error
+	err := db.Where("status = ?", model.RequestPending).Find(&msgs).Error
 	if err != nil {
 		logger.Error(err)
 		return nil, err
@@ -389,8 +344,19 @@ func (t *TelegramService) GetTgClientMsgs() ([]*model.TgClientMsg, error) {
 	return msgs, nil
 }
 
+// GetAdminActions lists the client changes admins made from the bot, the latest first
+func (t *TelegramService) GetAdminActions() ([]*model.TgAdminAction, error) {
+	db := database.GetDB()
+	var actions []*model.TgAdminAction
+	err := db.Model(model.TgAdminAction{}).Order("id desc").Find(&actions).Error
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
 func (t *TelegramService) DeleteRegRequestMsg(chatId int64) error {
-	db := database.GetTgDB().Model(&model.TgClientMsg{})
+	db := database.GetDB().Model(&model.TgClientMsg{})
 	err := db.Delete(&model.TgClientMsg{}, "chat_id =? AND (type=? OR type=?)", chatId, model.Registration, model.Renewal).Error
 	if err != nil {
 		logger.Error(err)
@@ -400,7 +366,7 @@ func (t *TelegramService) DeleteRegRequestMsg(chatId int64) error {
 }
 
 func (t *TelegramService) DeleteMsg(id int64) error {
-	db := database.GetTgDB()
+	db := database.GetDB()
 	err := db.Model(&model.TgClientMsg{}).Delete(&model.TgClientMsg{}, id).Error
 	if err != nil {
 		logger.Error(err)
@@ -410,7 +376,7 @@ func (t *TelegramService) DeleteMsg(id int64) error {
 }
 
 func (t *TelegramService) SaveClientLanguage(id int64, lang string) error {
-	db := database.GetTgDB()
+	db := database.GetDB()
 	result := db.Model(model.TgClient{}).
 		Where("chat_id = ?", id).
 		Update("language", lang)
@@ -1,26 +1,38 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/mail"
+	"reflect"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"x-ui/database"
 	"x-ui/database/model"
 	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/web/locale"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"golang.org/x/text/language"
 	"golang.org/x/text/language/display"
+	"gorm.io/gorm"
 )
 
-var TgSessions map[int64]*TgSession = make(map[int64]*TgSession)
+var tgSessions = make(map[int64]*TgSession)
+var tgSessionsLock sync.Mutex
 
 type TgSession struct {
 	state           stateFn
 	canAcceptPhoto  bool
 	telegramService TelegramService
+	paymentService  PaymentService
 	client          *model.TgClient
 	clientRequest   *model.TgClientMsg
+	plan            *model.ClientPlan
 	lang            string
 }
 
@@ -38,6 +50,10 @@ const (
 	RegisterCmdKey       = string("register")
 	ReferToFriendsCmdKey = string("refer")
 	ContactSupportCmdKey = string("support")
+	SubLinkCmdKey        = string("link")
+	RotateCmdKey         = string("rotate")
+	ClientIpsCmdKey      = string("ips")
+	HistoryCmdKey        = string("history")
 
 	// Default language is Persian/Farsi, change to "en" for English
 	defaultLang = string("fa")
@@ -45,6 +61,18 @@ const (
 
 type stateFn func(*TgSession, *tgbotapi.Message) *tgbotapi.MessageConfig
 
+// tgStates names the states so sessions can be stored
+var tgStates = map[string]stateFn{
+	"idle":         IdleState,
+	"chooseLang":   ChooseLangState,
+	"regAccType":   RegAccTypeState,
+	"regEmail":     RegEmailState,
+	"regNote":      RegNoteState,
+	"regUuid":      RegUuidState,
+	"sendReceipt":  SendReceiptState,
+	"confirmReset": ConfirmResetState,
+}
+
 func CreateChatMenu() []tgbotapi.BotCommand {
 	commands := []commandEntity{
 		{
@@ -99,8 +127,14 @@ func IdleState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfig {
 
 	switch cmd {
 	case StartCmdKey:
+		if strings.HasPrefix(args, referralStartPrefix) {
+			err := s.telegramService.AttributeReferral(msg.Chat.ID, strings.TrimPrefix(args, referralStartPrefix))
+			if err != nil {
+				logger.Info("referral not attributed:", err)
+			}
+		}
 		resp = tgbotapi.NewMessage(msg.Chat.ID, "")
-		getLanguagesSelector(&resp)
+		getLanguagesSelector(&resp, s.lang, langCommandPrefix)
 		s.state = ChooseLangState
 
 	case MainMenuKey:
@@ -141,13 +175,13 @@ func IdleState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfig {
 					Enabled:  true,
 					ChatID:   msg.Chat.ID,
 					Name:     name,
-					Uid:      args,
+					Uids:     []model.TgClientUid{{Uid: args}},
 					Language: s.lang,
 				}
 				err = s.telegramService.AddTgClient(s.client)
 			} else {
 				if s.telegramService.CheckIfClientExists(args) {
-					client.Uid = args
+					client.ClientUid = args
 					err = s.telegramService.UpdateClient(client)
 				}
 			}
@@ -166,7 +200,9 @@ func IdleState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfig {
 		client, _ := s.telegramService.getTgClient(msg.Chat.ID)
 		s.client = client
 
-		s.showAccListKeyboard(&resp)
+		if !s.showPlansKeyboard(&resp) {
+			break
+		}
 
 		s.clientRequest = &model.TgClientMsg{
 			ChatID: msg.Chat.ID,
@@ -191,11 +227,39 @@ func IdleState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfig {
 		referToFriendsMsg, err := s.telegramService.settingService.GetTgReferToFriendsMsg()
 		if err != nil {
 			resp.Text = Tr("msgInternalError", s.lang)
+			break
+		}
+		link, err := s.telegramService.ReferralLink(client.ChatID)
+		if err != nil {
+			logger.Error("ReferToFriends failed to get the referral link:", err)
+			resp.Text = Tr("msgInternalError", s.lang)
+			break
 		}
+		// the link goes where the message puts <REF_LINK>, else after it
+		if !strings.Contains(referToFriendsMsg, "<REF_LINK>") {
+			referToFriendsMsg += "\r\n\r\n<REF_LINK>"
+		}
+		referToFriendsMsg = strings.ReplaceAll(referToFriendsMsg, "<REF_LINK>", link)
 		referToFriendsMsg = s.telegramService.replaceMarkup(&referToFriendsMsg, client.ChatID, "")
+		joined, converted, err := s.telegramService.getReferralCounts(client.ChatID)
+		if err == nil {
+			referToFriendsMsg += "\r\n\r\n" + Tr("msgReferralStats", s.lang, "Joined=="+fmt.Sprint(joined), "Converted=="+fmt.Sprint(converted))
+		}
 		resp.Text = referToFriendsMsg
 		resp.ParseMode = tgbotapi.ModeHTML
 
+	case SubLinkCmdKey:
+		return s.telegramService.ChooseOwnedClient(msg.Chat.ID, linkCommandPrefix, s.lang)
+
+	case RotateCmdKey:
+		return s.telegramService.ChooseOwnedClient(msg.Chat.ID, rotateCommandPrefix, s.lang)
+
+	case ClientIpsCmdKey:
+		return s.telegramService.ChooseOwnedClient(msg.Chat.ID, ipsCommandPrefix, s.lang)
+
+	case HistoryCmdKey:
+		return s.telegramService.GetPlanHistory(msg.Chat.ID, s.lang)
+
 	case ContactSupportCmdKey:
 		contactSupportMsg, err := s.telegramService.settingService.GetTgContactSupportMsg()
 		if err != nil {
@@ -218,10 +282,11 @@ func ChooseLangState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfi
 	}
 
 	resp := tgbotapi.NewMessage(msg.Chat.ID, "")
-	if !strings.HasPrefix(msg.Text, "lang:") {
-		getLanguagesSelector(&resp)
+	if !strings.HasPrefix(msg.Text, langCommandPrefix) {
+		getLanguagesSelector(&resp, s.lang, langCommandPrefix)
+		return &resp
 	}
-	s.lang = strings.TrimPrefix(msg.Text, "lang:")
+	s.lang = strings.TrimPrefix(msg.Text, langCommandPrefix)
 	resp.Text = Tr("msgChooseFromMenu", s.lang)
 	s.telegramService.SaveClientLanguage(msg.Chat.ID, s.lang)
 
@@ -237,14 +302,22 @@ func RegAccTypeState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfi
 	}
 
 	resp := tgbotapi.NewMessage(msg.Chat.ID, "")
-	orderType := strings.TrimSpace(msg.Text)
-	if orderType == "" {
+	planId, err := strconv.Atoi(strings.TrimSpace(msg.Text))
+	if err != nil {
+		resp.Text = Tr("msgIncorrectPackageNo", s.lang)
+		s.state = IdleState
+		return &resp
+	}
+	plan, err := s.telegramService.clientPlanService.GetPlan(planId)
+	if err != nil || !plan.Enable {
 		resp.Text = Tr("msgIncorrectPackageNo", s.lang)
 		s.state = IdleState
 		return &resp
 	}
 
-	s.clientRequest.Msg += "Type: " + orderType
+	s.plan = plan
+	s.clientRequest.PlanId = plan.Id
+	s.clientRequest.Msg += fmt.Sprintf("Plan: %s (%d), Price: %d", plan.Name, plan.Id, plan.Price)
 
 	if s.client == nil {
 		name := msg.Chat.FirstName + " " + msg.Chat.LastName + " @" + msg.Chat.UserName
@@ -266,6 +339,7 @@ func RegAccTypeState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfi
 		}
 		s.canAcceptPhoto = true // allow the client to send receipts
 		resp.Text = moneyTransferInstructions
+		s.appendPaymentKeyboard(&resp)
 		s.state = SendReceiptState
 	}
 
@@ -317,6 +391,7 @@ func RegNoteState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfig {
 		}
 		s.canAcceptPhoto = true // allow the client to send receipts
 		resp.Text = moneyTransferInstructions
+		s.appendPaymentKeyboard(&resp)
 		s.state = SendReceiptState
 	}
 
@@ -342,7 +417,7 @@ func RegUuidState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfig {
 		Enabled:  true,
 		ChatID:   msg.Chat.ID,
 		Name:     name,
-		Uid:      uuid,
+		Uids:     []model.TgClientUid{{Uid: uuid}},
 		Language: s.lang,
 	}
 
@@ -371,30 +446,62 @@ func SendReceiptState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConf
 		return &resp
 	}
 
-	if msg.Photo == nil {
+	if strings.HasPrefix(msg.Text, payCommandPrefix) {
+		return s.payRequest(msg, strings.TrimPrefix(msg.Text, payCommandPrefix))
+	}
+
+	if len(msg.Photo) == 0 {
 		resp.Text = Tr("msgIncorrectReceipt", s.lang)
 		return &resp
 	}
 
-	// Put the order up on the panel
+	// Put the order up on the panel and in the admin chats
+	s.clientRequest.PhotoId = msg.Photo[len(msg.Photo)-1].FileID
 	err := s.telegramService.PushTgClientMsg(s.clientRequest)
 	if err != nil {
 		logger.Error(err)
 		resp.Text = Tr("msgInternalError", s.lang)
+		return &resp
 	}
 
-	err = s.telegramService.SendMsgToAdmin("New client request! Please visit the panel.")
+	err = s.telegramService.SendReceiptToAdmins(s.clientRequest)
 	if err != nil {
-		logger.Error("SendReceiptState failed to send msg to admin:", err)
+		logger.Error("SendReceiptState failed to send the receipt to admins:", err)
 	}
 
 	s.canAcceptPhoto = false
+	s.clientRequest = nil
+	s.plan = nil
 	s.state = IdleState
 	resp.Text = Tr("msgOrderRegistered", s.lang)
 
 	return &resp
 }
 
+// payRequest bills the request with a payment provider instead of waiting for a receipt, the client is created
+// or renewed when the provider confirms the payment
+func (s *TgSession) payRequest(msg *tgbotapi.Message, provider string) *tgbotapi.MessageConfig {
+	resp := tgbotapi.NewMessage(msg.Chat.ID, "")
+	key := fmt.Sprintf("tg%d-%d-%s", msg.Chat.ID, msg.MessageID, provider)
+	_, link, err := s.paymentService.CreatePayment(provider, key, s.clientRequest)
+	if err != nil {
+		logger.Error("payRequest failed to create the payment:", err)
+		resp.Text = Tr("msgInternalError", s.lang)
+		return &resp
+	}
+
+	resp.Text = Tr("msgPaymentCreated", s.lang)
+	if link != "" {
+		resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL(Tr("payInvoice", s.lang), link)))
+	}
+	s.canAcceptPhoto = false
+	s.clientRequest = nil
+	s.plan = nil
+	s.state = IdleState
+	return &resp
+}
+
 func ConfirmResetState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfig {
 	resp := tgbotapi.NewMessage(msg.Chat.ID, "")
 	if strings.ToLower(msg.Text) == "yes" {
@@ -412,6 +519,105 @@ func ConfirmResetState(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageCon
 	return &resp
 }
 
+/*********************************************************
+* Sessions
+*********************************************************/
+
+// stateName returns the name a state is stored under, a state missing from tgStates is stored as idle
+func stateName(state stateFn) string {
+	pointer := reflect.ValueOf(state).Pointer()
+	for name, fn := range tgStates {
+		if reflect.ValueOf(fn).Pointer() == pointer {
+			return name
+		}
+	}
+	logger.Warning("telegram state", runtime.FuncForPC(pointer).Name(), "is not in tgStates, it is stored as idle")
+	return "idle"
+}
+
+// getSession returns the session of the chat, it is loaded from the database after a restart
+func (t *TelegramService) getSession(chatId int64) *TgSession {
+	tgSessionsLock.Lock()
+	defer tgSessionsLock.Unlock()
+	if session, exists := tgSessions[chatId]; exists {
+		return session
+	}
+
+	session := InitFSM()
+	stored := &model.TgSession{}
+	db := database.GetDB()
+	err := db.Model(model.TgSession{}).First(stored, chatId).Error
+	if err == nil {
+		if state, ok := tgStates[stored.State]; ok {
+			session.state = state
+		}
+		session.lang = stored.Lang
+		session.canAcceptPhoto = stored.CanAcceptPhoto
+		if stored.Client != "" {
+			client := &model.TgClient{}
+			if json.Unmarshal([]byte(stored.Client), client) == nil {
+				session.client = client
+			}
+		}
+		if stored.Request != "" {
+			request := &model.TgClientMsg{}
+			if json.Unmarshal([]byte(stored.Request), request) == nil {
+				session.clientRequest = request
+			}
+		}
+		if stored.PlanId > 0 {
+			plan, err := t.clientPlanService.GetPlan(stored.PlanId)
+			if err == nil {
+				session.plan = plan
+			}
+		}
+	}
+	tgSessions[chatId] = session
+	return session
+}
+
+// releaseSession drops an idle session from the cache once the update of the chat is handled, the database
+// keeps it for the next update so only the chats in the middle of a conversation stay cached
+func (t *TelegramService) releaseSession(chatId int64) {
+	tgSessionsLock.Lock()
+	defer tgSessionsLock.Unlock()
+	if session, exists := tgSessions[chatId]; exists && stateName(session.state) == "idle" {
+		delete(tgSessions, chatId)
+	}
+}
+
+func (t *TelegramService) saveSession(chatId int64, session *TgSession) {
+	stored := &model.TgSession{
+		ChatID:         chatId,
+		State:          stateName(session.state),
+		Lang:           session.lang,
+		CanAcceptPhoto: session.canAcceptPhoto,
+	}
+	if session.plan != nil {
+		stored.PlanId = session.plan.Id
+	}
+	if session.client != nil {
+		client, _ := json.Marshal(session.client)
+		stored.Client = string(client)
+	}
+	if session.clientRequest != nil {
+		request, _ := json.Marshal(session.clientRequest)
+		stored.Request = string(request)
+	}
+	db := database.GetDB()
+	err := db.Save(stored).Error
+	if err != nil {
+		logger.Warning("save telegram session failed:", err)
+	}
+}
+
+func (t *TelegramService) deleteSession(tx *gorm.DB, chatId int64) error {
+	tgSessionsLock.Lock()
+	delete(tgSessions, chatId)
+	tgSessionsLock.Unlock()
+	return tx.Delete(model.TgSession{}, chatId).Error
+}
+
 /*********************************************************
 * Helper functions
 *********************************************************/
@@ -426,17 +632,31 @@ func isTgCommand(msg *tgbotapi.Message) bool {
 func abort(s *TgSession, msg *tgbotapi.Message) *tgbotapi.MessageConfig {
 	s.state = IdleState
 	s.client = nil
+	s.plan = nil
 	s.canAcceptPhoto = false
 	return IdleState(s, msg)
 }
 
-func getLanguagesSelector(resp *tgbotapi.MessageConfig) {
-	resp.Text = "Choose your language:"
+// Tr translates key of the telegram messages to lang with the translation bundle of the panel, params are
+// name==value pairs
+func Tr(key string, lang string, params ...string) string {
+	return locale.Localize(lang, "tgbot."+key, params...)
+}
+
+// GetAvailableLangs returns the languages of the translation bundle
+func GetAvailableLangs() []string {
+	return locale.GetLanguages()
+}
+
+// getLanguagesSelector asks for the language of the chat with a button for every language, prefix is put
+// before the language in the data of the buttons
+func getLanguagesSelector(resp *tgbotapi.MessageConfig, lang string, prefix string) {
+	resp.Text = Tr("msgChooseLang", lang)
 	row := tgbotapi.NewInlineKeyboardRow()
 	langs := GetAvailableLangs()
 
 	for _, lang := range langs {
-		row = append(row, tgbotapi.NewInlineKeyboardButtonData(display.Self.Name(language.Make(lang)), "lang:"+lang))
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(display.Self.Name(language.Make(lang)), prefix+lang))
 	}
 	if len(row) > 0 {
 		resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
@@ -471,6 +691,10 @@ func (s *TgSession) appendChatMenu(resp *tgbotapi.MessageConfig) {
 	if crmEnabled {
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(Tr("menuOrder", s.lang), "/"+RegisterCmdKey)))
 	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(Tr("menuSubLink", s.lang), "/"+SubLinkCmdKey),
+		tgbotapi.NewInlineKeyboardButtonData(Tr("menuHistory", s.lang), "/"+HistoryCmdKey),
+	))
 	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData(Tr("menuRefer", s.lang), "/"+ReferToFriendsCmdKey),
 		tgbotapi.NewInlineKeyboardButtonData(Tr("menuSupport", s.lang), "/"+ContactSupportCmdKey),
@@ -481,24 +705,48 @@ func (s *TgSession) appendChatMenu(resp *tgbotapi.MessageConfig) {
 
 }
 
-func (s *TgSession) showAccListKeyboard(resp *tgbotapi.MessageConfig) {
-	accList, err := s.telegramService.settingService.GetTgCrmRegAccList()
-	if err != nil {
-		resp.Text = Tr("msgInternalError", s.lang)
+// appendPaymentKeyboard lets the customer pay with the enabled payment providers instead of sending a receipt
+func (s *TgSession) appendPaymentKeyboard(resp *tgbotapi.MessageConfig) {
+	providers := s.paymentService.GetProviders()
+	if len(providers) == 0 {
 		return
 	}
-
-	accList = strings.TrimSpace(accList)
-	accounts := strings.Split(accList, "\n")
 	row := tgbotapi.NewInlineKeyboardRow()
-	for i := 1; i <= len(accounts); i++ {
-		row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprint(i), fmt.Sprint(i)))
+	for _, provider := range providers {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("💳 %s (%s)", Tr("payWith", s.lang), provider.Name()), payCommandPrefix+provider.Name()))
 	}
-	if len(row) > 0 {
-		resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+func (s *TgSession) showPlansKeyboard(resp *tgbotapi.MessageConfig) bool {
+	plans, err := s.telegramService.clientPlanService.GetEnabledPlans()
+	if err != nil {
+		logger.Error("showPlansKeyboard failed to get plans: ", err)
+		resp.Text = Tr("msgInternalError", s.lang)
+		return false
+	}
+	if len(plans) == 0 {
+		resp.Text = Tr("msgNoPackages", s.lang)
+		return false
 	}
 
-	resp.Text = Tr("msgChoosePackage", s.lang) + "\n" + accList
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(plans))
+	for _, plan := range plans {
+		total := "unlimited"
+		if plan.TotalGB > 0 {
+			total = common.FormatTraffic(plan.TotalGB)
+		}
+		days := "unlimited"
+		if plan.Days > 0 {
+			days = fmt.Sprintf("%d days", plan.Days)
+		}
+		text := fmt.Sprintf("%s: %s / %s - %d", plan.Name, total, days, plan.Price)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(text, fmt.Sprint(plan.Id))))
+	}
+	resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	resp.Text = Tr("msgChoosePackage", s.lang)
+	return true
 }
 
 func (s *TgSession) RenewAccount(chatId int64, uuid string) *tgbotapi.MessageConfig {
@@ -516,11 +764,15 @@ func (s *TgSession) RenewAccount(chatId int64, uuid string) *tgbotapi.MessageCon
 	s.client = client
 
 	if client != nil {
-		s.showAccListKeyboard(&resp)
+		if !s.showPlansKeyboard(&resp) {
+			s.state = IdleState
+			return &resp
+		}
 		s.clientRequest = &model.TgClientMsg{
 			ChatID: s.client.ChatID,
 			Type:   model.Renewal,
 			Msg:    "Acc: " + uuid + ",",
+			Uid:    uuid,
 		}
 
 		s.state = RegAccTypeState
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var stateFuncRegex = regexp.MustCompile(`(?m)^func (\w+State)\(\w+ \*TgSession, \w+ \*tgbotapi\.Message\) \*tgbotapi\.MessageConfig`)
+
+// a state missing from tgStates is stored as idle, so a conversation in it is lost on restart
+func TestStatesRegistered(t *testing.T) {
+	registered := make(map[string]bool, len(tgStates))
+	for _, fn := range tgStates {
+		name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+		registered[name[strings.LastIndex(name, ".")+1:]] = true
+	}
+
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range stateFuncRegex.FindAllStringSubmatch(string(data), -1) {
+			found++
+			if !registered[match[1]] {
+				t.Errorf("%v of %v is missing from tgStates", match[1], file)
+			}
+		}
+	}
+	if found == 0 {
+		t.Fatal("no telegram states found")
+	}
+
+	for name, fn := range tgStates {
+		if stateName(fn) != name {
+			t.Errorf("state %v is stored as %v", name, stateName(fn))
+		}
+	}
+}
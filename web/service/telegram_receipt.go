@@ -0,0 +1,272 @@
+package service
+
+import (
+	"fmt"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// callback data of the receipt buttons is receipt:<action>:<request id>[:<plan id>]
+const receiptCallbackPrefix = "receipt:"
+
+func (t *TelegramService) getClientLang(chatId int64) string {
+	client, err := t.getTgClient(chatId)
+	if err == nil && client.Language != "" {
+		return client.Language
+	}
+	return defaultLang
+}
+
+// getChatLang is the language chosen in the chat, else the language of its telegram client, else fallback
+func (t *TelegramService) getChatLang(chatId int64, fallback string) string {
+	tgSessionsLock.Lock()
+	session, exists := tgSessions[chatId]
+	tgSessionsLock.Unlock()
+	if exists && session.lang != "" {
+		return session.lang
+	}
+	if !exists {
+		// the session is not loaded, getSession can not be used by the states it runs
+		stored := &model.TgSession{}
+		db := database.GetDB()
+		if db.Model(model.TgSession{}).First(stored, chatId).Error == nil && stored.Lang != "" {
+			return stored.Lang
+		}
+	}
+	client, err := t.getTgClient(chatId)
+	if err == nil && client.Language != "" {
+		return client.Language
+	}
+	return fallback
+}
+
+func (t *TelegramService) GetRequest(id int64) (*model.TgClientMsg, error) {
+	db := database.GetDB()
+	request := &model.TgClientMsg{}
+	err := db.Model(model.TgClientMsg{}).First(request, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// ReceiptCaption describes a request for the admins
+func (t *TelegramService) ReceiptCaption(request *model.TgClientMsg, lang string) string {
+	name := ""
+	client, err := t.getTgClient(request.ChatID)
+	if err == nil {
+		name = client.Name
+	}
+	caption := Tr("receiptCaption", lang,
+		"Id=="+fmt.Sprint(request.Id),
+		"Type=="+Tr(string(request.Type), lang),
+		"Name=="+name,
+		"ChatId=="+fmt.Sprint(request.ChatID),
+		"Msg=="+request.Msg)
+	if request.PlanId > 0 {
+		plan, err := t.clientPlanService.GetPlan(request.PlanId)
+		if err == nil {
+			caption += "\r\n" + Tr("receiptPlan", lang, "Name=="+plan.Name, "Price=="+fmt.Sprint(plan.Price))
+		}
+	}
+	if request.Uid != "" {
+		caption += "\r\n" + Tr("receiptAccount", lang, "Uid=="+request.Uid)
+	}
+	return caption
+}
+
+func ReceiptKeyboard(id int64, lang string) tgbotapi.InlineKeyboardMarkup {
+	data := func(action string) string {
+		return fmt.Sprintf("%s%s:%d", receiptCallbackPrefix, action, id)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(Tr("approve", lang), data("approve")),
+		tgbotapi.NewInlineKeyboardButtonData(Tr("reject", lang), data("reject")),
+		tgbotapi.NewInlineKeyboardButtonData(Tr("adjust", lang), data("adjust")),
+	))
+}
+
+// ReceiptPlansKeyboard lets an admin change the plan of a request before approving it
+func (t *TelegramService) ReceiptPlansKeyboard(id int64, lang string) (tgbotapi.InlineKeyboardMarkup, error) {
+	plans, err := t.clientPlanService.GetEnabledPlans()
+	if err != nil {
+		return tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(plans)+1)
+	for _, plan := range plans {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("%s - %d", plan.Name, plan.Price), fmt.Sprintf("%splan:%d:%d", receiptCallbackPrefix, id, plan.Id))))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+		Tr("back", lang), fmt.Sprintf("%sback:%d", receiptCallbackPrefix, id))))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+// SendReceiptToAdmins sends the receipt of a request with the approval buttons to every admin
+func (t *TelegramService) SendReceiptToAdmins(request *model.TgClientMsg) error {
+	if len(adminIds) == 0 {
+		return common.NewError("no telegram admins")
+	}
+	for _, adminId := range adminIds {
+		var err error
+		lang := t.getChatLang(adminId, adminLang)
+		caption := t.ReceiptCaption(request, lang)
+		if request.PhotoId != "" {
+			photo := tgbotapi.NewPhoto(adminId, tgbotapi.FileID(request.PhotoId))
+			photo.Caption = caption
+			photo.ReplyMarkup = ReceiptKeyboard(request.Id, lang)
+			err = tgSend(photo)
+		} else {
+			msg := tgbotapi.NewMessage(adminId, caption)
+			msg.ReplyMarkup = ReceiptKeyboard(request.Id, lang)
+			err = tgSend(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TelegramService) SetRequestPlan(id int64, planId int) error {
+	plan, err := t.clientPlanService.GetPlan(planId)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	result := db.Model(model.TgClientMsg{}).
+		Where("id = ? and status = ?", id, model.RequestPending).
+		Update("plan_id", plan.Id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("request is not pending:", id)
+	}
+	return nil
+}
+
+// review moves a pending request to status, only one admin can win when several review it at once
+func (t *TelegramService) review(id int64, status string, adminId int64, reviewer string) error {
+	db := database.GetDB()
+	result := db.Model(model.TgClientMsg{}).
+		Where("id = ? and status = ?", id, model.RequestPending).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"reviewed_by": adminId,
+			"reviewer":    reviewer,
+			"reviewed_at": time.Now().UnixMilli(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return common.NewError("request is not pending:", id)
+	}
+	return nil
+}
+
+func (t *TelegramService) reopen(id int64) {
+	db := database.GetDB()
+	err := db.Model(model.TgClientMsg{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":      model.RequestPending,
+		"reviewed_by": 0,
+		"reviewer":    "",
+		"reviewed_at": 0,
+	}).Error
+	if err != nil {
+		logger.Warning("reopen telegram request failed:", err)
+	}
+}
+
+// ApproveRequest creates or renews the client of the request from its plan, links it to the telegram client
+// and tells the customer
+func (t *TelegramService) ApproveRequest(id int64, adminId int64, reviewer string) ([]model.Client, error) {
+	request, err := t.GetRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if request.PlanId == 0 {
+		return nil, common.NewError("request has no plan:", id)
+	}
+	if _, err = t.getTgClient(request.ChatID); err != nil {
+		return nil, err
+	}
+	err = t.review(id, model.RequestApproved, adminId, reviewer)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := t.applyRequest(request, fmt.Sprintf("tg%d-%d", request.ChatID, request.Id))
+	if len(clients) == 0 {
+		// nothing was created, the request can be reviewed again
+		t.reopen(id)
+	}
+	return clients, err
+}
+
+// applyRequest creates the client of a registration under email or renews the client of a renewal from the
+// plan of the request, then links it to the telegram client and tells the customer. A registration converts the
+// referral of the chat
+func (t *TelegramService) applyRequest(request *model.TgClientMsg, email string) ([]model.Client, error) {
+	client, err := t.getTgClient(request.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := t.clientsFromRequest(request, email)
+	if err != nil {
+		return nil, err
+	}
+
+	client.ClientUid = clients[0].ID
+	if client.ClientUid == "" {
+		client.ClientUid = clients[0].Password
+	}
+	client.Enabled = true
+	if request.Type == model.Registration {
+		err = t.RegisterClient(client)
+		if err == nil {
+			t.ConvertReferral(request.ChatID)
+		}
+	} else {
+		err = t.RenewClient(client)
+	}
+	return clients, err
+}
+
+func (t *TelegramService) clientsFromRequest(request *model.TgClientMsg, email string) ([]model.Client, error) {
+	var clients []model.Client
+	var err error
+	switch request.Type {
+	case model.Registration:
+		clients, err = t.clientPlanService.CreateClientFromPlan(request.PlanId, email, fmt.Sprint(request.ChatID))
+	case model.Renewal:
+		traffic, err1 := t.inboundService.SearchClientTraffic(request.Uid)
+		if err1 != nil || traffic == nil {
+			return nil, common.NewError("client of the request not found:", request.Uid)
+		}
+		clients, err = t.clientPlanService.RenewClientFromPlan(request.PlanId, traffic.Email)
+	default:
+		return nil, common.NewError("unknown request type:", request.Type)
+	}
+	if err == nil && len(clients) == 0 {
+		err = common.NewError("no client was created for:", email)
+	}
+	return clients, err
+}
+
+func (t *TelegramService) RejectRequest(id int64, adminId int64, reviewer string) error {
+	request, err := t.GetRequest(id)
+	if err != nil {
+		return err
+	}
+	err = t.review(id, model.RequestRejected, adminId, reviewer)
+	if err != nil {
+		return err
+	}
+	return t.SendMsgToTgBot(request.ChatID, Tr("msgRequestRejected", t.getClientLang(request.ChatID)))
+}
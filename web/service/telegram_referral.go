@@ -0,0 +1,208 @@
+package service
+
+import (
+	"fmt"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/util/random"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// referral links open the bot with /start ref_<code>
+const referralStartPrefix = "ref_"
+
+// ReferralStat sums the referrals of a referrer, the rewards are the days and the GB its client got
+type ReferralStat struct {
+	ReferrerId    int64  `json:"referrerId"`
+	Name          string `json:"name"`
+	Code          string `json:"code"`
+	Joined        int    `json:"joined"`
+	Converted     int    `json:"converted"`
+	RewardDays    int    `json:"rewardDays"`
+	RewardTraffic int    `json:"rewardTraffic"`
+}
+
+// getReferralCode returns the referral code of the chat, a new one is made on the first call
+func (t *TelegramService) getReferralCode(chatId int64) (string, error) {
+	db := database.GetDB()
+	code := &model.ReferralCode{}
+	err := db.Model(model.ReferralCode{}).First(code, chatId).Error
+	if err == nil {
+		return code.Code, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return "", err
+	}
+	// retry on the unlikely clash with the code of another chat
+	for i := 0; i < 3; i++ {
+		code = &model.ReferralCode{ChatID: chatId, Code: random.Seq(8)}
+		err = db.Create(code).Error
+		if err == nil {
+			return code.Code, nil
+		}
+	}
+	return "", err
+}
+
+// ReferralLink is the deep link of the bot that attributes new chats to chatId
+func (t *TelegramService) ReferralLink(chatId int64) (string, error) {
+	if bot == nil {
+		return "", common.NewError("telegram bot is not running")
+	}
+	code, err := t.getReferralCode(chatId)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://t.me/%s?start=%s%s", bot.Self.UserName, referralStartPrefix, code), nil
+}
+
+// AttributeReferral records that chatId joined with the referral code, chats that are already customers and
+// chats that were referred before are not attributed again
+func (t *TelegramService) AttributeReferral(chatId int64, code string) error {
+	db := database.GetDB()
+	referrer := &model.ReferralCode{}
+	err := db.Model(model.ReferralCode{}).Where("code = ?", code).First(referrer).Error
+	if err != nil {
+		return common.NewError("unknown referral code:", code)
+	}
+	if referrer.ChatID == chatId {
+		return common.NewError("chat can not refer itself:", chatId)
+	}
+	if _, err = t.getTgClient(chatId); err == nil {
+		return common.NewError("chat is already a customer:", chatId)
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.Referral{
+		ReferrerId: referrer.ChatID,
+		ChatID:     chatId,
+		Status:     model.ReferralJoined,
+	}).Error
+}
+
+// ConvertReferral marks the referral of chatId converted when its first registration is applied and rewards the
+// client of the referrer with the days and the traffic of the settings
+func (t *TelegramService) ConvertReferral(chatId int64) {
+	db := database.GetDB()
+	result := db.Model(model.Referral{}).
+		Where("chat_id = ? AND status = ?", chatId, model.ReferralJoined).
+		Updates(map[string]interface{}{"status": model.ReferralConverted, "converted_at": time.Now().UnixMilli()})
+	if result.Error != nil {
+		logger.Warning("convert referral failed:", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	referral := &model.Referral{}
+	err := db.Model(model.Referral{}).Where("chat_id = ?", chatId).First(referral).Error
+	if err != nil {
+		logger.Warning("convert referral failed:", err)
+		return
+	}
+
+	days, err := t.settingService.GetTgReferralRewardDays()
+	if err != nil || days < 0 {
+		days = 0
+	}
+	traffic, err := t.settingService.GetTgReferralRewardTraffic()
+	if err != nil || traffic < 0 {
+		traffic = 0
+	}
+	if days == 0 && traffic == 0 {
+		return
+	}
+
+	updates := map[string]interface{}{"reward_days": days, "reward_traffic": traffic}
+	err = t.rewardReferrer(referral.ReferrerId, days, traffic)
+	if err != nil {
+		logger.Warning("referral reward of", referral.ReferrerId, "failed:", err)
+		updates = map[string]interface{}{"error": err.Error()}
+	}
+	err = db.Model(referral).Updates(updates).Error
+	if err != nil {
+		logger.Warning("save referral reward failed:", err)
+	}
+}
+
+// rewardReferrer extends the first client of the referrer by days and traffic GB
+func (t *TelegramService) rewardReferrer(referrerId int64, days int, traffic int) error {
+	client, err := t.getTgClient(referrerId)
+	if err != nil {
+		return err
+	}
+	email := ""
+	for _, uid := range client.GetUids() {
+		clientTraffic, err := t.inboundService.SearchClientTraffic(uid)
+		if err == nil && clientTraffic != nil {
+			email = clientTraffic.Email
+			break
+		}
+	}
+	if email == "" {
+		return common.NewError("referrer has no client:", referrerId)
+	}
+	_, err = t.clientBulkService.ExtendClients(&BulkClientRequest{
+		ClientFilter: ClientFilter{Emails: []string{email}},
+		Days:         days,
+		Traffic:      int64(traffic) * 1024 * 1024 * 1024,
+	})
+	if err != nil {
+		return err
+	}
+	t.xrayService.SetToNeedRestart()
+
+	lang := t.getClientLang(referrerId)
+	t.SendMsgToTgBot(referrerId, Tr("msgReferralReward", lang, "Days=="+fmt.Sprint(days), "Traffic=="+fmt.Sprint(traffic)))
+	return nil
+}
+
+func (t *TelegramService) GetReferrals() ([]*model.Referral, error) {
+	db := database.GetDB()
+	var referrals []*model.Referral
+	err := db.Model(model.Referral{}).Order("id desc").Find(&referrals).Error
+	if err != nil {
+		return nil, err
+	}
+	return referrals, nil
+}
+
+// GetReferralStats sums the referrals per referrer, the referrers with the most conversions first
+func (t *TelegramService) GetReferralStats() ([]*ReferralStat, error) {
+	db := database.GetDB()
+	var stats []*ReferralStat
+	err := db.Model(model.Referral{}).
+		Select("referrer_id, count(*) as joined, "+
+			"sum(case when status = ? then 1 else 0 end) as converted, "+
+			"sum(reward_days) as reward_days, sum(reward_traffic) as reward_traffic", model.ReferralConverted).
+		Group("referrer_id").
+		Order("converted desc, joined desc").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, stat := range stats {
+		if client, err := t.getTgClient(stat.ReferrerId); err == nil {
+			stat.Name = client.Name
+		}
+		code := &model.ReferralCode{}
+		if err := db.Model(model.ReferralCode{}).First(code, stat.ReferrerId).Error; err == nil {
+			stat.Code = code.Code
+		}
+	}
+	return stats, nil
+}
+
+// getReferralCounts returns how many chats joined with the link of chatId and how many of them converted
+func (t *TelegramService) getReferralCounts(chatId int64) (joined int64, converted int64, err error) {
+	db := database.GetDB()
+	err = db.Model(model.Referral{}).Where("referrer_id = ?", chatId).Count(&joined).Error
+	if err != nil {
+		return
+	}
+	err = db.Model(model.Referral{}).Where("referrer_id = ? AND status = ?", chatId, model.ReferralConverted).Count(&converted).Error
+	return
+}
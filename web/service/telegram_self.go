@@ -0,0 +1,289 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	linkCommandPrefix     = string("link:")
+	rotateCommandPrefix   = string("rotate:")
+	ipsCommandPrefix      = string("ips:")
+	clearIpsCommandPrefix = string("clearips:")
+)
+
+// ownedClient is a client of an inbound that belongs to a chat, by its tgId set to the chat id or by a link of
+// the CRM. Usernames are not trusted because they can be released and claimed by anyone.
+type ownedClient struct {
+	inbound *model.Inbound
+	client  model.Client
+}
+
+func (o *ownedClient) uid() string {
+	if o.inbound.Protocol == model.Trojan {
+		return o.client.Password
+	}
+	return o.client.ID
+}
+
+func matchTgId(tgId string, chatId int64) bool {
+	return strings.TrimSpace(tgId) == strconv.FormatInt(chatId, 10)
+}
+
+// getOwnedClients returns the clients the chat can manage itself
+func (t *TelegramService) getOwnedClients(chatId int64) ([]*ownedClient, error) {
+	linked := map[string]bool{}
+	if tgClient, err := t.getTgClient(chatId); err == nil && tgClient.Enabled {
+		for _, uid := range tgClient.GetUids() {
+			linked[uid] = true
+		}
+	}
+	inbounds, err := t.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]*ownedClient, 0)
+	for _, inbound := range inbounds {
+		clients, err := t.inboundService.getClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			o := &ownedClient{inbound: inbound, client: client}
+			if linked[o.uid()] || matchTgId(client.TgID, chatId) {
+				owned = append(owned, o)
+			}
+		}
+	}
+	return owned, nil
+}
+
+func (t *TelegramService) getOwnedClient(chatId int64, uid string) *ownedClient {
+	owned, err := t.getOwnedClients(chatId)
+	if err != nil {
+		logger.Warning("getOwnedClient failed:", err)
+		return nil
+	}
+	for _, o := range owned {
+		if o.uid() == uid {
+			return o
+		}
+	}
+	return nil
+}
+
+// ChooseOwnedClient asks which client an action with prefix applies to
+func (t *TelegramService) ChooseOwnedClient(chatId int64, prefix string, lang string) *tgbotapi.MessageConfig {
+	resp := tgbotapi.NewMessage(chatId, "")
+	owned, err := t.getOwnedClients(chatId)
+	if err != nil {
+		resp.Text = Tr("msgInternalError", lang)
+		return &resp
+	}
+	if len(owned) == 0 {
+		resp.Text = Tr("msgNotRegistered", lang)
+		return &resp
+	}
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(owned))
+	for _, o := range owned {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("%s (%s)", o.client.Email, o.inbound.Remark), prefix+o.uid())))
+	}
+	resp.Text = Tr("msgChooseClient", lang)
+	resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &resp
+}
+
+// clientLink is the subscription link of the client, or its share link when it has no subscription
+func (t *TelegramService) clientLink(o *ownedClient) string {
+	subURI, err := t.settingService.GetSubURI()
+	if err != nil || subURI == "" {
+		return ""
+	}
+	if o.client.SubID != "" {
+		if !strings.HasSuffix(subURI, "/") {
+			subURI += "/"
+		}
+		return subURI + o.client.SubID
+	}
+	uri, err := url.Parse(subURI)
+	if err != nil {
+		return ""
+	}
+	subService := SubService{address: uri.Hostname()}
+	return subService.getLink(o.inbound, o.client.Email)
+}
+
+// SendClientLink sends the link of a client with its QR code
+func (t *TelegramService) SendClientLink(chatId int64, uid string, lang string) *tgbotapi.MessageConfig {
+	resp := tgbotapi.NewMessage(chatId, "")
+	o := t.getOwnedClient(chatId, uid)
+	if o == nil {
+		resp.Text = Tr("incorrectUuid", lang)
+		return &resp
+	}
+	link := t.clientLink(o)
+	if link == "" {
+		resp.Text = Tr("msgLinkNotAvailable", lang)
+		return &resp
+	}
+	png, err := qrcode.Encode(link, qrcode.Medium, 512)
+	if err == nil {
+		photo := tgbotapi.NewPhoto(chatId, tgbotapi.FileBytes{Name: o.client.Email + ".png", Bytes: png})
+		photo.Caption = o.client.Email
+		tgSend(photo)
+	} else {
+		logger.Warning("SendClientLink failed to encode the QR code:", err)
+	}
+	resp.Text = "<code>" + link + "</code>"
+	resp.ParseMode = tgbotapi.ModeHTML
+	return &resp
+}
+
+// RotateClient gives the client a new uuid or password so links that leaked stop working, the CRM links
+// follow the new id and xray is restarted with it
+func (t *TelegramService) RotateClient(chatId int64, uid string, lang string) *tgbotapi.MessageConfig {
+	resp := tgbotapi.NewMessage(chatId, "")
+	o := t.getOwnedClient(chatId, uid)
+	if o == nil {
+		resp.Text = Tr("incorrectUuid", lang)
+		return &resp
+	}
+	client := o.client
+	newUid := newUUID()
+	if o.inbound.Protocol == model.Trojan {
+		client.Password = newUid
+	} else {
+		client.ID = newUid
+	}
+	settings, err := clientSettings(client)
+	if err == nil {
+		err = t.inboundService.UpdateInboundClient(&model.Inbound{Id: o.inbound.Id, Settings: settings}, uid)
+	}
+	if err == nil {
+		db := database.GetDB()
+		err = db.Model(model.TgClientUid{}).Where("uid = ?", uid).Update("uid", newUid).Error
+	}
+	if err != nil {
+		logger.Error("RotateClient failed:", err)
+		resp.Text = Tr("msgInternalError", lang)
+		return &resp
+	}
+	t.xrayService.SetToNeedRestart()
+
+	o.client = client
+	if inbound, err := t.inboundService.GetInbound(o.inbound.Id); err == nil {
+		o.inbound = inbound
+	}
+	resp.Text = Tr("msgRotateSuccess", lang)
+	if link := t.clientLink(o); link != "" {
+		resp.Text += "\r\n\r\n<code>" + link + "</code>"
+		resp.ParseMode = tgbotapi.ModeHTML
+	}
+	return &resp
+}
+
+// GetClientIps shows the ips recorded for the client with a button to clear them
+func (t *TelegramService) GetClientIps(chatId int64, uid string, lang string) *tgbotapi.MessageConfig {
+	resp := tgbotapi.NewMessage(chatId, "")
+	o := t.getOwnedClient(chatId, uid)
+	if o == nil {
+		resp.Text = Tr("incorrectUuid", lang)
+		return &resp
+	}
+	var ips []string
+	if jsonIps, err := t.inboundService.GetInboundClientIps(o.client.Email); err == nil && jsonIps != "" {
+		json.Unmarshal([]byte(jsonIps), &ips)
+	}
+	if len(ips) == 0 {
+		resp.Text = fmt.Sprintf("📧 %s\r\n%s", o.client.Email, Tr("msgNoIps", lang))
+		return &resp
+	}
+	resp.Text = fmt.Sprintf("📧 %s\r\n🌐 %s", o.client.Email, strings.Join(ips, "\r\n🌐 "))
+	resp.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(Tr("clearIps", lang), clearIpsCommandPrefix+uid)))
+	return &resp
+}
+
+func (t *TelegramService) ClearClientIps(chatId int64, uid string, lang string) *tgbotapi.MessageConfig {
+	resp := tgbotapi.NewMessage(chatId, "")
+	o := t.getOwnedClient(chatId, uid)
+	if o == nil {
+		resp.Text = Tr("incorrectUuid", lang)
+		return &resp
+	}
+	err := t.inboundService.ClearClientIps(o.client.Email)
+	if err != nil {
+		logger.Error("ClearClientIps failed:", err)
+		resp.Text = Tr("msgInternalError", lang)
+		return &resp
+	}
+	resp.Text = fmt.Sprintf("📧 %s\r\n%s", o.client.Email, Tr("msgIpsCleared", lang))
+	return &resp
+}
+
+// GetPlanHistory lists the approved requests and the paid payments of the chat, the latest first
+func (t *TelegramService) GetPlanHistory(chatId int64, lang string) *tgbotapi.MessageConfig {
+	resp := tgbotapi.NewMessage(chatId, "")
+	db := database.GetDB()
+	var requests []*model.TgClientMsg
+	err := db.Model(model.TgClientMsg{}).
+		Where("chat_id = ? and status = ?", chatId, model.RequestApproved).
+		Order("reviewed_at desc").Find(&requests).Error
+	if err != nil {
+		resp.Text = Tr("msgInternalError", lang)
+		return &resp
+	}
+	var payments []*model.Payment
+	err = db.Model(model.Payment{}).
+		Where("chat_id = ? and status = ?", chatId, model.PaymentPaid).
+		Order("updated_at desc").Find(&payments).Error
+	if err != nil {
+		resp.Text = Tr("msgInternalError", lang)
+		return &resp
+	}
+
+	type entry struct {
+		at   int64
+		text string
+	}
+	plans := map[int]string{}
+	planName := func(planId int) string {
+		if _, ok := plans[planId]; !ok {
+			plans[planId] = fmt.Sprint(planId)
+			if plan, err := t.clientPlanService.GetPlan(planId); err == nil {
+				plans[planId] = plan.Name
+			}
+		}
+		return plans[planId]
+	}
+	entries := make([]entry, 0, len(requests)+len(payments))
+	for _, request := range requests {
+		entries = append(entries, entry{request.ReviewedAt, fmt.Sprintf("%s (%s)", planName(request.PlanId), request.Type)})
+	}
+	for _, payment := range payments {
+		entries = append(entries, entry{payment.UpdatedAt, fmt.Sprintf("%s (%s) 💳 %d %s", planName(payment.PlanId), payment.Type, payment.Amount, payment.Currency)})
+	}
+	if len(entries) == 0 {
+		resp.Text = Tr("msgNoHistory", lang)
+		return &resp
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].at > entries[j].at })
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("📅 %s  %s", time.UnixMilli(e.at).Format("2006-01-02"), e.text))
+	}
+	resp.Text = strings.Join(lines, "\r\n")
+	return &resp
+}
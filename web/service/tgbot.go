@@ -1,11 +1,13 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"x-ui/config"
 	"x-ui/database/model"
@@ -20,6 +22,22 @@ var bot *tgbotapi.BotAPI
 var adminIds []int64
 var isRunning bool
 
+// all messages go through one queue that keeps the bot under the limit of telegram, about 30 messages per second
+const tgSendInterval = 35 * time.Millisecond
+const tgSendRetries = 3
+
+var tgSendQueue = make(chan tgOutgoing, 1000)
+var tgSendOnce sync.Once
+
+// tgOutgoing is a queued request, done gets its result when it is set
+type tgOutgoing struct {
+	c    tgbotapi.Chattable
+	done chan error
+}
+
+// admins choose their language with /lang, the customer flow has its own language callbacks
+const adminLangCallbackPrefix = "adminlang:"
+
 type LoginStatus byte
 
 const (
@@ -28,10 +46,36 @@ const (
 )
 
 type Tgbot struct {
-	inboundService InboundService
-	settingService SettingService
-	serverService  ServerService
-	lastStatus     *Status
+	inboundService    InboundService
+	settingService    SettingService
+	serverService     ServerService
+	telegramService   TelegramService
+	xrayService       XrayService
+	paymentService    PaymentService
+	clientBulkService ClientBulkService
+	clientPlanService ClientPlanService
+	lastStatus        *Status
+}
+
+// tgContext carries an update through the middlewares to its handler
+type tgContext struct {
+	update  *tgbotapi.Update
+	chatId  int64
+	isAdmin bool
+	lang    string
+}
+
+type tgHandler func(t *Tgbot, ctx *tgContext)
+
+type tgMiddleware func(next tgHandler) tgHandler
+
+// tgRouter dispatches commands by name and callbacks by their data or its prefix, the rest goes to fallback
+type tgRouter struct {
+	commands        map[string]tgHandler
+	callbacks       map[string]tgHandler
+	callbackPrefixs map[string]tgHandler
+	fallback        tgHandler
+	middlewares     []tgMiddleware
 }
 
 func (t *Tgbot) NewTgbot() *Tgbot {
@@ -45,21 +89,12 @@ func (t *Tgbot) Start() error {
 		return err
 	}
 
-	tgBotid, err := t.settingService.GetTgBotChatId()
+	adminIds, err = t.settingService.GetTgBotAdminIds()
 	if err != nil {
-		logger.Warning("Get GetTgBotChatId failed:", err)
+		logger.Warning("Failed to get IDs from GetTgBotChatId:", err)
 		return err
 	}
 
-	for _, adminId := range strings.Split(tgBotid, ",") {
-		id, err := strconv.Atoi(adminId)
-		if err != nil {
-			logger.Warning("Failed to get IDs from GetTgBotChatId:", err)
-			return err
-		}
-		adminIds = append(adminIds, int64(id))
-	}
-
 	bot, err = tgbotapi.NewBotAPI(tgBottoken)
 	if err != nil {
 		fmt.Println("Get tgbot's api error:", err)
@@ -67,6 +102,11 @@ func (t *Tgbot) Start() error {
 	}
 	bot.Debug = false
 
+	tgSendOnce.Do(func() {
+		go tgSendLoop()
+	})
+	tgSend(tgbotapi.NewSetMyCommands(CreateChatMenu()...))
+
 	// listen for TG bot income messages
 	if !isRunning {
 		logger.Info("Starting Telegram receiver ...")
@@ -88,88 +128,434 @@ func (t *Tgbot) Stop() {
 	adminIds = nil
 }
 
+// tgSend queues c for the send loop, it fails when the bot has not started or the queue is full
+func tgSend(c tgbotapi.Chattable) error {
+	if bot == nil {
+		return common.NewError("telegram bot is not running")
+	}
+	select {
+	case tgSendQueue <- tgOutgoing{c: c}:
+		return nil
+	default:
+		return common.NewError("telegram send queue is full")
+	}
+}
+
+// tgSendWait queues c behind the other messages and waits for the result of its request, it is meant for
+// background senders which must not fill the queue
+func tgSendWait(c tgbotapi.Chattable) error {
+	if bot == nil {
+		return common.NewError("telegram bot is not running")
+	}
+	done := make(chan error, 1)
+	tgSendQueue <- tgOutgoing{c: c, done: done}
+	return <-done
+}
+
+func tgSendLoop() {
+	for out := range tgSendQueue {
+		var err error
+		for i := 0; i < tgSendRetries; i++ {
+			_, err = bot.Request(out.c)
+			tgErr := &tgbotapi.Error{}
+			if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+				time.Sleep(time.Duration(tgErr.RetryAfter) * time.Second)
+				continue
+			}
+			if err != nil {
+				logger.Warning("Error sending telegram message :", err)
+			}
+			break
+		}
+		if out.done != nil {
+			out.done <- err
+		}
+		time.Sleep(tgSendInterval)
+	}
+}
+
 func (t *Tgbot) OnReceive() {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 10
 
 	updates := bot.GetUpdatesChan(u)
+	router := t.newRouter()
 
 	for update := range updates {
-		tgId := update.FromChat().ID
-		chatId := update.FromChat().ChatConfig().ChatID
-		isAdmin := checkAdmin(tgId)
-		if update.Message == nil {
-			if update.CallbackQuery != nil {
-				t.asnwerCallback(update.CallbackQuery, isAdmin)
+		if update.PreCheckoutQuery != nil {
+			t.answerPreCheckout(update.PreCheckoutQuery)
+			continue
+		}
+		chat := update.FromChat()
+		if chat == nil {
+			continue
+		}
+		if update.CallbackQuery != nil && update.CallbackQuery.Message == nil {
+			continue
+		}
+		router.dispatch(t, &tgContext{
+			update:  &update,
+			chatId:  chat.ID,
+			isAdmin: checkAdmin(chat.ID),
+		})
+	}
+}
+
+// newRouter puts the admin commands in front of the customer flow, customers and admins
+// using commands they are not allowed to end up in the customer flow
+func (t *Tgbot) newRouter() *tgRouter {
+	r := &tgRouter{
+		commands:        map[string]tgHandler{},
+		callbacks:       map[string]tgHandler{},
+		callbackPrefixs: map[string]tgHandler{},
+		fallback:        (*Tgbot).handleCustomer,
+	}
+	r.use(tgRecover, tgAnswerCallback, tgLanguage)
+
+	r.command("start", (*Tgbot).commandStart, r.adminOnly)
+	r.command("help", (*Tgbot).commandHelp, r.adminOnly)
+	r.command("status", (*Tgbot).commandStatus, r.adminOnly)
+	r.command("usage", (*Tgbot).commandUsage, r.adminOnly)
+	r.command("inbound", (*Tgbot).commandInbound, r.adminOnly)
+
+	r.command("lang", (*Tgbot).commandLang, r.adminOnly)
+	r.command("referrals", (*Tgbot).commandReferrals, r.adminOnly)
+
+	r.callback("get_usage", func(t *Tgbot, ctx *tgContext) { t.SendMsgToTgbot(ctx.chatId, t.getServerUsage(ctx.lang)) }, r.adminOnly)
+	r.callback("inbounds", func(t *Tgbot, ctx *tgContext) { t.SendMsgToTgbot(ctx.chatId, t.GetInboundUsages(ctx.lang)) }, r.adminOnly)
+	r.callback("deplete_soon", func(t *Tgbot, ctx *tgContext) { t.SendMsgToTgbot(ctx.chatId, t.getExhausted(ctx.lang)) }, r.adminOnly)
+	r.callback("get_backup", func(t *Tgbot, ctx *tgContext) { t.sendBackup(ctx.chatId, ctx.lang) }, r.adminOnly)
+	r.callback("commands", func(t *Tgbot, ctx *tgContext) {
+		t.SendMsgToTgbot(ctx.chatId, Tr("adminCommands", ctx.lang))
+	}, r.adminOnly)
+	r.callbackPrefix(receiptCallbackPrefix, (*Tgbot).handleReceipt, r.adminOnly)
+	r.callbackPrefix(adminLangCallbackPrefix, (*Tgbot).handleLang, r.adminOnly)
+	t.registerClientCommands(r)
+	return r
+}
+
+// use adds middlewares that run for every update, in order
+func (r *tgRouter) use(middlewares ...tgMiddleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+func (r *tgRouter) command(name string, handler tgHandler, middlewares ...tgMiddleware) {
+	r.commands[name] = chainTgHandler(handler, middlewares)
+}
+
+func (r *tgRouter) callback(data string, handler tgHandler, middlewares ...tgMiddleware) {
+	r.callbacks[data] = chainTgHandler(handler, middlewares)
+}
+
+func (r *tgRouter) callbackPrefix(prefix string, handler tgHandler, middlewares ...tgMiddleware) {
+	r.callbackPrefixs[prefix] = chainTgHandler(handler, middlewares)
+}
+
+func chainTgHandler(handler tgHandler, middlewares []tgMiddleware) tgHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+func (r *tgRouter) dispatch(t *Tgbot, ctx *tgContext) {
+	var handler tgHandler
+	if message := ctx.update.Message; message != nil && message.IsCommand() {
+		handler = r.commands[message.Command()]
+	} else if callbackQuery := ctx.update.CallbackQuery; callbackQuery != nil {
+		handler = r.callbacks[callbackQuery.Data]
+		for prefix, prefixHandler := range r.callbackPrefixs {
+			if handler == nil && strings.HasPrefix(callbackQuery.Data, prefix) {
+				handler = prefixHandler
 			}
+		}
+	}
+	if handler == nil {
+		handler = r.fallback
+	}
+	chainTgHandler(handler, r.middlewares)(t, ctx)
+}
+
+// adminOnly hands updates of non admins to the fallback of the router
+func (r *tgRouter) adminOnly(next tgHandler) tgHandler {
+	return func(t *Tgbot, ctx *tgContext) {
+		if ctx.isAdmin {
+			next(t, ctx)
 		} else {
-			if update.Message.IsCommand() {
-				t.answerCommand(update.Message, chatId, isAdmin)
+			r.fallback(t, ctx)
+		}
+	}
+}
+
+// tgRecover keeps the update loop alive when a handler panics
+func tgRecover(next tgHandler) tgHandler {
+	return func(t *Tgbot, ctx *tgContext) {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Error("telegram handler panic:", err)
+			}
+		}()
+		next(t, ctx)
+	}
+}
+
+func tgAnswerCallback(next tgHandler) tgHandler {
+	return func(t *Tgbot, ctx *tgContext) {
+		if ctx.update.CallbackQuery != nil {
+			if _, err := bot.Request(tgbotapi.NewCallback(ctx.update.CallbackQuery.ID, "")); err != nil {
+				logger.Warning(err)
 			}
 		}
+		next(t, ctx)
 	}
 }
 
-func (t *Tgbot) answerCommand(message *tgbotapi.Message, chatId int64, isAdmin bool) {
-	msg := ""
-	// Extract the command from the Message.
-	switch message.Command() {
-	case "help":
-		msg = "This bot is providing you some specefic data from the server.\n\n Please choose:"
-	case "start":
-		msg = "Hello <i>" + message.From.FirstName + "</i> 👋"
-		if isAdmin {
-			hostname, _ := os.Hostname()
-			msg += "\nWelcome to <b>" + hostname + "</b> management bot"
+// tgLanguage resolves the language of the chat from its session or its registered client
+func tgLanguage(next tgHandler) tgHandler {
+	return func(t *Tgbot, ctx *tgContext) {
+		if ctx.isAdmin {
+			ctx.lang = t.telegramService.getChatLang(ctx.chatId, adminLang)
+		} else {
+			ctx.lang = t.telegramService.getChatLang(ctx.chatId, defaultLang)
+		}
+		next(t, ctx)
+	}
+}
+
+func (t *Tgbot) commandStart(ctx *tgContext) {
+	hostname, _ := os.Hostname()
+	t.SendAnswer(ctx.chatId, Tr("adminStart", ctx.lang, "Name=="+ctx.update.Message.From.FirstName, "Hostname=="+hostname))
+}
+
+func (t *Tgbot) commandHelp(ctx *tgContext) {
+	t.SendAnswer(ctx.chatId, Tr("adminHelp", ctx.lang))
+}
+
+func (t *Tgbot) commandStatus(ctx *tgContext) {
+	t.SendAnswer(ctx.chatId, Tr("adminStatus", ctx.lang))
+}
+
+// commandReferrals lists the referrers with the most conversions
+func (t *Tgbot) commandReferrals(ctx *tgContext) {
+	stats, err := t.telegramService.GetReferralStats()
+	if err != nil {
+		logger.Warning(err)
+		t.SendMsgToTgbot(ctx.chatId, Tr("msgSomethingWrong", ctx.lang))
+		return
+	}
+	if len(stats) == 0 {
+		t.SendMsgToTgbot(ctx.chatId, Tr("msgNoReferrals", ctx.lang))
+		return
+	}
+	msg := Tr("referralTop", ctx.lang)
+	for i, stat := range stats {
+		if i == 10 {
+			break
+		}
+		name := stat.Name
+		if name == "" {
+			name = fmt.Sprint(stat.ReferrerId)
 		}
-		msg += "\n\nI can do some magics for you, please choose:"
-	case "status":
-		msg = "bot is ok ✅"
-	case "usage":
-		if len(message.CommandArguments()) > 1 {
-			if isAdmin {
-				t.searchClient(chatId, message.CommandArguments())
+		msg += "\r\n" + Tr("referralStatsRow", ctx.lang,
+			"Name=="+name,
+			"Joined=="+fmt.Sprint(stat.Joined),
+			"Converted=="+fmt.Sprint(stat.Converted),
+			"Days=="+fmt.Sprint(stat.RewardDays),
+			"Traffic=="+fmt.Sprint(stat.RewardTraffic))
+	}
+	t.SendMsgToTgbot(ctx.chatId, msg)
+}
+
+func (t *Tgbot) commandUsage(ctx *tgContext) {
+	email := ctx.update.Message.CommandArguments()
+	if len(email) > 1 {
+		t.searchClient(ctx.chatId, email, ctx.lang)
+	} else {
+		t.SendAnswer(ctx.chatId, Tr("msgEnterSearch", ctx.lang))
+	}
+}
+
+func (t *Tgbot) commandInbound(ctx *tgContext) {
+	t.searchInbound(ctx.chatId, ctx.update.Message.CommandArguments(), ctx.lang)
+}
+
+// commandLang lets an admin choose the language of the bot in its chat
+func (t *Tgbot) commandLang(ctx *tgContext) {
+	resp := tgbotapi.NewMessage(ctx.chatId, "")
+	getLanguagesSelector(&resp, ctx.lang, adminLangCallbackPrefix)
+	tgSend(resp)
+}
+
+func (t *Tgbot) handleLang(ctx *tgContext) {
+	session := t.telegramService.getSession(ctx.chatId)
+	defer t.telegramService.releaseSession(ctx.chatId)
+	session.lang = strings.TrimPrefix(ctx.update.CallbackQuery.Data, adminLangCallbackPrefix)
+	t.telegramService.saveSession(ctx.chatId, session)
+	message := ctx.update.CallbackQuery.Message
+	tgSend(tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID))
+	t.SendAnswer(ctx.chatId, Tr("msgLangChanged", session.lang))
+}
+
+// handleCustomer runs the customer flow of TelegramService for everything the admin commands did not take
+func (t *Tgbot) handleCustomer(ctx *tgContext) {
+	defer t.telegramService.releaseSession(ctx.chatId)
+	if session := t.telegramService.getSession(ctx.chatId); session.lang == "" {
+		session.lang = ctx.lang
+	}
+
+	if callbackQuery := ctx.update.CallbackQuery; callbackQuery != nil {
+		message := callbackQuery.Message
+		resp, del, upd := t.telegramService.HandleCallback(callbackQuery)
+		if resp != nil {
+			keyboard, ok := resp.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+			if upd && ok {
+				updateMsg := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, resp.Text)
+				updateMsg.ReplyMarkup = &keyboard
+				tgSend(updateMsg)
 			} else {
-				t.searchForClient(chatId, message.CommandArguments())
+				tgSend(resp)
 			}
-		} else {
-			msg = "❗Please provide a text for search!"
 		}
-	case "inbound":
-		if isAdmin {
-			t.searchInbound(chatId, message.CommandArguments())
-		} else {
-			msg = "❗ Unknown command"
+		if del {
+			tgSend(tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID))
 		}
-	default:
-		msg = "❗ Unknown command"
+		return
+	}
+
+	message := ctx.update.Message
+	if message == nil {
+		return
+	}
+	if message.SuccessfulPayment != nil {
+		t.confirmTgPayment(message.SuccessfulPayment)
+		return
+	}
+	resp := t.telegramService.HandleMessage(message)
+	if resp != nil {
+		tgSend(resp)
 	}
-	t.SendAnswer(chatId, msg, isAdmin)
 }
 
-func (t *Tgbot) asnwerCallback(callbackQuery *tgbotapi.CallbackQuery, isAdmin bool) {
-	// Respond to the callback query, telling Telegram to show the user
-	// a message with the data received.
-	callback := tgbotapi.NewCallback(callbackQuery.ID, callbackQuery.Data)
-	if _, err := bot.Request(callback); err != nil {
-		logger.Warning(err)
+// answerPreCheckout lets telegram charge the customer only for pending payments of the right amount
+func (t *Tgbot) answerPreCheckout(query *tgbotapi.PreCheckoutQuery) {
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+	err := t.paymentService.CheckPayment(query.InvoicePayload, int64(query.TotalAmount), query.Currency)
+	if err != nil {
+		logger.Warning("telegram pre checkout rejected:", err)
+		answer.OK = false
+		answer.ErrorMessage = Tr("msgPaymentExpired", t.telegramService.getClientLang(query.From.ID))
+	}
+	if _, err = bot.Request(answer); err != nil {
+		logger.Warning("answer telegram pre checkout failed:", err)
+	}
+}
+
+func (t *Tgbot) confirmTgPayment(payment *tgbotapi.SuccessfulPayment) {
+	err := t.paymentService.ConfirmPayment(&PaymentEvent{
+		Key:        payment.InvoicePayload,
+		ExternalId: payment.TelegramPaymentChargeID,
+		Amount:     int64(payment.TotalAmount),
+		Currency:   payment.Currency,
+		Paid:       true,
+	})
+	if err != nil {
+		logger.Warning("confirm telegram payment failed:", err)
+	}
+}
+
+// handleReceipt approves, rejects or changes the plan of a request from the buttons of its receipt
+func (t *Tgbot) handleReceipt(ctx *tgContext) {
+	query := ctx.update.CallbackQuery
+	message := query.Message
+	fields := strings.Split(strings.TrimPrefix(query.Data, receiptCallbackPrefix), ":")
+	if len(fields) < 2 {
+		return
+	}
+	id, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return
+	}
+	reviewer := adminName(query.From)
+	requestError := func(err error) string {
+		return Tr("msgRequestError", ctx.lang, "Id=="+fmt.Sprint(id), "Error=="+err.Error())
+	}
+
+	switch fields[0] {
+	case "approve":
+		clients, err := t.telegramService.ApproveRequest(id, ctx.chatId, reviewer)
+		if len(clients) > 0 {
+			t.xrayService.SetToNeedRestart()
+		}
+		if err != nil {
+			t.SendMsgToTgbot(ctx.chatId, requestError(err))
+			if len(clients) == 0 {
+				return
+			}
+		}
+		t.closeReceipt(message, id, "requestApproved", reviewer)
+	case "reject":
+		err = t.telegramService.RejectRequest(id, ctx.chatId, reviewer)
+		if err != nil {
+			t.SendMsgToTgbot(ctx.chatId, requestError(err))
+			return
+		}
+		t.closeReceipt(message, id, "requestRejected", reviewer)
+	case "adjust":
+		keyboard, err := t.telegramService.ReceiptPlansKeyboard(id, ctx.lang)
+		if err != nil {
+			t.SendMsgToTgbot(ctx.chatId, requestError(err))
+			return
+		}
+		tgSend(tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, keyboard))
+	case "plan":
+		if len(fields) < 3 {
+			return
+		}
+		planId, err := strconv.Atoi(fields[2])
+		if err == nil {
+			err = t.telegramService.SetRequestPlan(id, planId)
+		}
+		if err != nil {
+			t.SendMsgToTgbot(ctx.chatId, requestError(err))
+			return
+		}
+		request, err := t.telegramService.GetRequest(id)
+		if err != nil {
+			return
+		}
+		keyboard := ReceiptKeyboard(id, ctx.lang)
+		t.editReceipt(message, t.telegramService.ReceiptCaption(request, ctx.lang), &keyboard)
+	case "back":
+		tgSend(tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, ReceiptKeyboard(id, ctx.lang)))
 	}
+}
 
-	switch callbackQuery.Data {
-	case "get_usage":
-		t.SendMsgToTgbot(callbackQuery.From.ID, t.getServerUsage())
-	case "inbounds":
-		t.SendMsgToTgbot(callbackQuery.From.ID, t.getInboundUsages())
-	case "deplete_soon":
-		t.SendMsgToTgbot(callbackQuery.From.ID, t.getExhausted())
-	case "get_backup":
-		t.sendBackup(callbackQuery.From.ID)
-	case "client_traffic":
-		t.getClientUsage(callbackQuery.From.ID, callbackQuery.From.UserName)
-	case "client_commands":
-		t.SendMsgToTgbot(callbackQuery.From.ID, "To search for statistics, just use folowing command:\r\n \r\n<code>/usage [UID|Password]</code>\r\n \r\nUse UID for vmess/vless and Password for Trojan.")
-	case "commands":
-		t.SendMsgToTgbot(callbackQuery.From.ID, "Search for a client email:\r\n<code>/usage email</code>\r\n \r\nSearch for inbounds (with client stats):\r\n<code>/inbound [remark]</code>")
+// editReceipt replaces the caption of a receipt photo, or the text when the request had no photo
+func (t *Tgbot) editReceipt(message *tgbotapi.Message, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	if len(message.Photo) > 0 {
+		edit := tgbotapi.NewEditMessageCaption(message.Chat.ID, message.MessageID, text)
+		edit.ReplyMarkup = keyboard
+		tgSend(edit)
+	} else {
+		edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text)
+		edit.ReplyMarkup = keyboard
+		tgSend(edit)
+	}
+}
+
+// closeReceipt removes the buttons of a reviewed receipt and tells the other admins the result in their language
+func (t *Tgbot) closeReceipt(message *tgbotapi.Message, id int64, result string, reviewer string) {
+	caption := message.Caption
+	if caption == "" {
+		caption = message.Text
+	}
+	lang := t.telegramService.getChatLang(message.Chat.ID, adminLang)
+	t.editReceipt(message, caption+"\r\n\r\n"+Tr(result, lang, "Name=="+reviewer), nil)
+	for _, adminId := range adminIds {
+		if adminId != message.Chat.ID {
+			lang := t.telegramService.getChatLang(adminId, adminLang)
+			t.SendMsgToTgbot(adminId, Tr("msgRequestReviewed", lang, "Id=="+fmt.Sprint(id), "Result=="+Tr(result, lang, "Name=="+reviewer)))
+		}
 	}
 }
 
@@ -182,34 +568,26 @@ func checkAdmin(tgId int64) bool {
 	return false
 }
 
-func (t *Tgbot) SendAnswer(chatId int64, msg string, isAdmin bool) {
+// SendAnswer sends msg with the admin keyboard
+func (t *Tgbot) SendAnswer(chatId int64, msg string) {
+	lang := t.telegramService.getChatLang(chatId, adminLang)
 	var numericKeyboard = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Server Usage", "get_usage"),
-			tgbotapi.NewInlineKeyboardButtonData("Get DB Backup", "get_backup"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Get Inbounds", "inbounds"),
-			tgbotapi.NewInlineKeyboardButtonData("Deplete soon", "deplete_soon"),
+			tgbotapi.NewInlineKeyboardButtonData(Tr("serverUsage", lang), "get_usage"),
+			tgbotapi.NewInlineKeyboardButtonData(Tr("getBackup", lang), "get_backup"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Commands", "commands"),
+			tgbotapi.NewInlineKeyboardButtonData(Tr("getInbounds", lang), "inbounds"),
+			tgbotapi.NewInlineKeyboardButtonData(Tr("depleteSoon", lang), "deplete_soon"),
 		),
-	)
-	var numericKeyboardClient = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Get Usage", "client_traffic"),
-			tgbotapi.NewInlineKeyboardButtonData("Commands", "client_commands"),
+			tgbotapi.NewInlineKeyboardButtonData(Tr("commands", lang), "commands"),
 		),
 	)
 	msgConfig := tgbotapi.NewMessage(chatId, msg)
 	msgConfig.ParseMode = "HTML"
-	if isAdmin {
-		msgConfig.ReplyMarkup = numericKeyboard
-	} else {
-		msgConfig.ReplyMarkup = numericKeyboardClient
-	}
-	_, err := bot.Send(msgConfig)
+	msgConfig.ReplyMarkup = numericKeyboard
+	err := tgSend(msgConfig)
 	if err != nil {
 		logger.Warning("Error sending telegram message :", err)
 	}
@@ -236,11 +614,10 @@ func (t *Tgbot) SendMsgToTgbot(tgid int64, msg string) {
 	for _, message := range allMessages {
 		info := tgbotapi.NewMessage(tgid, message)
 		info.ParseMode = "HTML"
-		_, err := bot.Send(info)
+		err := tgSend(info)
 		if err != nil {
 			logger.Warning("Error sending telegram message :", err)
 		}
-		time.Sleep(500 * time.Millisecond)
 	}
 }
 
@@ -250,24 +627,31 @@ func (t *Tgbot) SendMsgToTgbotAdmins(msg string) {
 	}
 }
 
+// SendTrToTgbotAdmins sends every admin the message built by msg for the language of its chat
+func (t *Tgbot) SendTrToTgbotAdmins(msg func(lang string) string) {
+	for _, adminId := range adminIds {
+		t.SendMsgToTgbot(adminId, msg(t.telegramService.getChatLang(adminId, adminLang)))
+	}
+}
+
 func (t *Tgbot) SendReport() {
 	runTime, err := t.settingService.GetTgbotRuntime()
 	if err == nil && len(runTime) > 0 {
-		t.SendMsgToTgbotAdmins("🕰 Scheduled reports: " + runTime + "\r\nDate-Time: " + time.Now().Format("2006-01-02 15:04:05"))
+		t.SendTrToTgbotAdmins(func(lang string) string {
+			return Tr("msgScheduledReport", lang, "Runtime=="+runTime, "Time=="+time.Now().Format("2006-01-02 15:04:05"))
+		})
 	}
-	info := t.getServerUsage()
-	t.SendMsgToTgbotAdmins(info)
-	exhausted := t.getExhausted()
-	t.SendMsgToTgbotAdmins(exhausted)
+	t.SendTrToTgbotAdmins(t.getServerUsage)
+	t.SendTrToTgbotAdmins(t.getExhausted)
 	backupEnable, err := t.settingService.GetTgBotBackup()
 	if err == nil && backupEnable {
 		for _, adminId := range adminIds {
-			t.sendBackup(int64(adminId))
+			t.sendBackup(int64(adminId), t.telegramService.getChatLang(adminId, adminLang))
 		}
 	}
 }
 
-func (t *Tgbot) getServerUsage() string {
+func (t *Tgbot) getServerUsage(lang string) string {
 	var info string
 	//get hostname
 	name, err := os.Hostname()
@@ -275,15 +659,15 @@ func (t *Tgbot) getServerUsage() string {
 		logger.Error("get hostname error:", err)
 		name = ""
 	}
-	info = fmt.Sprintf("💻 Hostname: %s\r\n", name)
-	info += fmt.Sprintf("🚀X-UI Version: %s\r\n", config.GetVersion())
+	info = Tr("serverHostname", lang, "Hostname=="+name) + "\r\n"
+	info += Tr("serverVersion", lang, "Version=="+config.GetVersion()) + "\r\n"
 	//get ip address
 	var ip string
 	var ipv6 string
 	netInterfaces, err := net.Interfaces()
 	if err != nil {
 		logger.Error("net.Interfaces failed, err:", err.Error())
-		info += "🌐 IP: Unknown\r\n \r\n"
+		info += Tr("serverIpUnknown", lang) + "\r\n \r\n"
 	} else {
 		for i := 0; i < len(netInterfaces); i++ {
 			if (netInterfaces[i].Flags & net.FlagUp) != 0 {
@@ -300,18 +684,21 @@ func (t *Tgbot) getServerUsage() string {
 				}
 			}
 		}
-		info += fmt.Sprintf("🌐IP: %s\r\n🌐IPv6: %s\r\n", ip, ipv6)
+		info += Tr("serverIp", lang, "IP=="+ip, "IPv6=="+ipv6) + "\r\n"
 	}
 
 	// get latest status of server
 	t.lastStatus = t.serverService.GetStatus(t.lastStatus)
-	info += fmt.Sprintf("🔌Server Uptime: %d days\r\n", int(t.lastStatus.Uptime/86400))
-	info += fmt.Sprintf("📈Server Load: %.1f, %.1f, %.1f\r\n", t.lastStatus.Loads[0], t.lastStatus.Loads[1], t.lastStatus.Loads[2])
-	info += fmt.Sprintf("📋Server Memory: %s/%s\r\n", common.FormatTraffic(int64(t.lastStatus.Mem.Current)), common.FormatTraffic(int64(t.lastStatus.Mem.Total)))
-	info += fmt.Sprintf("🔹TcpCount: %d\r\n", t.lastStatus.TcpCount)
-	info += fmt.Sprintf("🔸UdpCount: %d\r\n", t.lastStatus.UdpCount)
-	info += fmt.Sprintf("🚦Traffic: %s (↑%s,↓%s)\r\n", common.FormatTraffic(int64(t.lastStatus.NetTraffic.Sent+t.lastStatus.NetTraffic.Recv)), common.FormatTraffic(int64(t.lastStatus.NetTraffic.Sent)), common.FormatTraffic(int64(t.lastStatus.NetTraffic.Recv)))
-	info += fmt.Sprintf("ℹXray status: %s", t.lastStatus.Xray.State)
+	info += Tr("serverUptime", lang, "Days=="+fmt.Sprint(int(t.lastStatus.Uptime/86400))) + "\r\n"
+	info += Tr("serverLoad", lang, "Load=="+fmt.Sprintf("%.1f, %.1f, %.1f", t.lastStatus.Loads[0], t.lastStatus.Loads[1], t.lastStatus.Loads[2])) + "\r\n"
+	info += Tr("serverMemory", lang, "Current=="+common.FormatTraffic(int64(t.lastStatus.Mem.Current)), "Total=="+common.FormatTraffic(int64(t.lastStatus.Mem.Total))) + "\r\n"
+	info += Tr("serverTcpCount", lang, "Count=="+fmt.Sprint(t.lastStatus.TcpCount)) + "\r\n"
+	info += Tr("serverUdpCount", lang, "Count=="+fmt.Sprint(t.lastStatus.UdpCount)) + "\r\n"
+	info += Tr("serverTraffic", lang,
+		"Total=="+common.FormatTraffic(int64(t.lastStatus.NetTraffic.Sent+t.lastStatus.NetTraffic.Recv)),
+		"Up=="+common.FormatTraffic(int64(t.lastStatus.NetTraffic.Sent)),
+		"Down=="+common.FormatTraffic(int64(t.lastStatus.NetTraffic.Recv))) + "\r\n"
+	info += Tr("serverXrayState", lang, "State=="+string(t.lastStatus.Xray.State))
 
 	return info
 }
@@ -321,196 +708,84 @@ func (t *Tgbot) UserLoginNotify(username string, ip string, time string, status
 		logger.Warning("UserLoginNotify failed,invalid info")
 		return
 	}
-	var msg string
 	// Get hostname
 	name, err := os.Hostname()
 	if err != nil {
 		logger.Warning("get hostname error:", err)
 		return
 	}
-	if status == LoginSuccess {
-		msg = fmt.Sprintf("✅ Successfully logged-in to the panel\r\nHostname:%s\r\n", name)
-	} else if status == LoginFail {
-		msg = fmt.Sprintf("❗ Login to the panel was unsuccessful\r\nHostname:%s\r\n", name)
+	key := "msgLoginSuccess"
+	if status == LoginFail {
+		key = "msgLoginFail"
 	}
-	msg += fmt.Sprintf("⏰ Time:%s\r\n", time)
-	msg += fmt.Sprintf("🆔 Username:%s\r\n", username)
-	msg += fmt.Sprintf("🌐 IP:%s\r\n", ip)
-	t.SendMsgToTgbotAdmins(msg)
+	t.SendTrToTgbotAdmins(func(lang string) string {
+		return Tr(key, lang, "Hostname=="+name, "Time=="+time, "Username=="+username, "IP=="+ip)
+	})
+}
+
+// inboundCard describes the traffic and the expiry of an inbound
+func inboundCard(inbound *model.Inbound, lang string) string {
+	info := Tr("inboundInfo", lang, "Remark=="+inbound.Remark, "Port=="+fmt.Sprint(inbound.Port)) + "\r\n"
+	info += Tr("inboundTraffic", lang,
+		"Total=="+common.FormatTraffic((inbound.Up+inbound.Down)),
+		"Up=="+common.FormatTraffic(inbound.Up),
+		"Down=="+common.FormatTraffic(inbound.Down)) + "\r\n"
+	expiryTime := Tr("unlimited", lang)
+	if inbound.ExpiryTime != 0 {
+		expiryTime = time.Unix((inbound.ExpiryTime / 1000), 0).Format("2006-01-02 15:04:05")
+	}
+	info += Tr("inboundExpiry", lang, "Expiry=="+expiryTime) + "\r\n \r\n"
+	return info
 }
 
-func (t *Tgbot) getInboundUsages() string {
+func (t *Tgbot) GetInboundUsages(lang string) string {
 	info := ""
 	// get traffic
 	inbouds, err := t.inboundService.GetAllInbounds()
 	if err != nil {
 		logger.Warning("GetAllInbounds run failed:", err)
-		info += "❌ Failed to get inbounds"
+		info += Tr("msgGetInboundsFailed", lang)
 	} else {
 		// NOTE:If there no any sessions here,need to notify here
 		// TODO:Sub-node push, automatic conversion format
 		for _, inbound := range inbouds {
-			info += fmt.Sprintf("📍Inbound:%s\r\nPort:%d\r\n", inbound.Remark, inbound.Port)
-			info += fmt.Sprintf("Traffic: %s (↑%s,↓%s)\r\n", common.FormatTraffic((inbound.Up + inbound.Down)), common.FormatTraffic(inbound.Up), common.FormatTraffic(inbound.Down))
-			if inbound.ExpiryTime == 0 {
-				info += "Expire date: ♾ Unlimited\r\n \r\n"
-			} else {
-				info += fmt.Sprintf("Expire date:%s\r\n \r\n", time.Unix((inbound.ExpiryTime/1000), 0).Format("2006-01-02 15:04:05"))
-			}
+			info += inboundCard(inbound, lang)
 		}
 	}
 	return info
 }
 
-func (t *Tgbot) getClientUsage(chatId int64, tgUserName string) {
-	if len(tgUserName) == 0 {
-		msg := "Your configuration is not found!\nYou should configure your telegram username and ask Admin to add it to your configuration."
-		t.SendMsgToTgbot(chatId, msg)
-		return
-	}
-	traffics, err := t.inboundService.GetClientTrafficTgBot(tgUserName)
-	if err != nil {
-		logger.Warning(err)
-		msg := "❌ Something went wrong!"
-		t.SendMsgToTgbot(chatId, msg)
-		return
-	}
-	if len(traffics) == 0 {
-		msg := "Your configuration is not found!\nPlease ask your Admin to use your telegram username in your configuration(s).\n\nYour username: <b>@" + tgUserName + "</b>"
-		t.SendMsgToTgbot(chatId, msg)
-		return
-	}
-	for _, traffic := range traffics {
-		expiryTime := ""
-		if traffic.ExpiryTime == 0 {
-			expiryTime = "♾Unlimited"
-		} else if traffic.ExpiryTime < 0 {
-			expiryTime = fmt.Sprintf("%d days", traffic.ExpiryTime/-86400000)
-		} else {
-			expiryTime = time.Unix((traffic.ExpiryTime / 1000), 0).Format("2006-01-02 15:04:05")
-		}
-		total := ""
-		if traffic.Total == 0 {
-			total = "♾Unlimited"
-		} else {
-			total = common.FormatTraffic((traffic.Total))
-		}
-		output := fmt.Sprintf("💡 Active: %t\r\n📧 Email: %s\r\n🔼 Upload↑: %s\r\n🔽 Download↓: %s\r\n🔄 Total: %s / %s\r\n📅 Expire in: %s\r\n",
-			traffic.Enable, traffic.Email, common.FormatTraffic(traffic.Up), common.FormatTraffic(traffic.Down), common.FormatTraffic((traffic.Up + traffic.Down)),
-			total, expiryTime)
-		t.SendMsgToTgbot(chatId, output)
-	}
-	t.SendAnswer(chatId, "Please choose:", false)
-}
-
-func (t *Tgbot) searchClient(chatId int64, email string) {
+func (t *Tgbot) searchClient(chatId int64, email string, lang string) {
 	traffic, err := t.inboundService.GetClientTrafficByEmail(email)
 	if err != nil {
 		logger.Warning(err)
-		msg := "❌ Something went wrong!"
-		t.SendMsgToTgbot(chatId, msg)
+		t.SendMsgToTgbot(chatId, Tr("msgSomethingWrong", lang))
 		return
 	}
 	if traffic == nil {
-		msg := "No result!"
-		t.SendMsgToTgbot(chatId, msg)
+		t.SendMsgToTgbot(chatId, Tr("msgNoResult", lang))
 		return
 	}
-	expiryTime := ""
-	if traffic.ExpiryTime == 0 {
-		expiryTime = "♾Unlimited"
-	} else if traffic.ExpiryTime < 0 {
-		expiryTime = fmt.Sprintf("%d days", traffic.ExpiryTime/-86400000)
-	} else {
-		expiryTime = time.Unix((traffic.ExpiryTime / 1000), 0).Format("2006-01-02 15:04:05")
-	}
-	total := ""
-	if traffic.Total == 0 {
-		total = "♾Unlimited"
-	} else {
-		total = common.FormatTraffic((traffic.Total))
-	}
-	output := fmt.Sprintf("💡 Active: %t\r\n📧 Email: %s\r\n🔼 Upload↑: %s\r\n🔽 Download↓: %s\r\n🔄 Total: %s / %s\r\n📅 Expire in: %s\r\n",
-		traffic.Enable, traffic.Email, common.FormatTraffic(traffic.Up), common.FormatTraffic(traffic.Down), common.FormatTraffic((traffic.Up + traffic.Down)),
-		total, expiryTime)
+	output := clientCard(traffic, lang)
 	t.SendMsgToTgbot(chatId, output)
 }
 
-func (t *Tgbot) searchInbound(chatId int64, remark string) {
+func (t *Tgbot) searchInbound(chatId int64, remark string, lang string) {
 	inbouds, err := t.inboundService.SearchInbounds(remark)
 	if err != nil {
 		logger.Warning(err)
-		msg := "❌ Something went wrong!"
-		t.SendMsgToTgbot(chatId, msg)
+		t.SendMsgToTgbot(chatId, Tr("msgSomethingWrong", lang))
 		return
 	}
 	for _, inbound := range inbouds {
-		info := ""
-		info += fmt.Sprintf("📍Inbound:%s\r\nPort:%d\r\n", inbound.Remark, inbound.Port)
-		info += fmt.Sprintf("Traffic: %s (↑%s,↓%s)\r\n", common.FormatTraffic((inbound.Up + inbound.Down)), common.FormatTraffic(inbound.Up), common.FormatTraffic(inbound.Down))
-		if inbound.ExpiryTime == 0 {
-			info += "Expire date: ♾ Unlimited\r\n \r\n"
-		} else {
-			info += fmt.Sprintf("Expire date:%s\r\n \r\n", time.Unix((inbound.ExpiryTime/1000), 0).Format("2006-01-02 15:04:05"))
-		}
-		t.SendMsgToTgbot(chatId, info)
-		for _, traffic := range inbound.ClientStats {
-			expiryTime := ""
-			if traffic.ExpiryTime == 0 {
-				expiryTime = "♾Unlimited"
-			} else if traffic.ExpiryTime < 0 {
-				expiryTime = fmt.Sprintf("%d days", traffic.ExpiryTime/-86400000)
-			} else {
-				expiryTime = time.Unix((traffic.ExpiryTime / 1000), 0).Format("2006-01-02 15:04:05")
-			}
-			total := ""
-			if traffic.Total == 0 {
-				total = "♾Unlimited"
-			} else {
-				total = common.FormatTraffic((traffic.Total))
-			}
-			output := fmt.Sprintf("💡 Active: %t\r\n📧 Email: %s\r\n🔼 Upload↑: %s\r\n🔽 Download↓: %s\r\n🔄 Total: %s / %s\r\n📅 Expire in: %s\r\n",
-				traffic.Enable, traffic.Email, common.FormatTraffic(traffic.Up), common.FormatTraffic(traffic.Down), common.FormatTraffic((traffic.Up + traffic.Down)),
-				total, expiryTime)
-			t.SendMsgToTgbot(chatId, output)
+		t.SendMsgToTgbot(chatId, inboundCard(inbound, lang))
+		for i := range inbound.ClientStats {
+			t.SendMsgToTgbot(chatId, clientCard(&inbound.ClientStats[i], lang))
 		}
 	}
 }
 
-func (t *Tgbot) searchForClient(chatId int64, query string) {
-	traffic, err := t.inboundService.SearchClientTraffic(query)
-	if err != nil {
-		logger.Warning(err)
-		msg := "❌ Something went wrong!"
-		t.SendMsgToTgbot(chatId, msg)
-		return
-	}
-	if traffic == nil {
-		msg := "No result!"
-		t.SendMsgToTgbot(chatId, msg)
-		return
-	}
-	expiryTime := ""
-	if traffic.ExpiryTime == 0 {
-		expiryTime = "♾Unlimited"
-	} else if traffic.ExpiryTime < 0 {
-		expiryTime = fmt.Sprintf("%d days", traffic.ExpiryTime/-86400000)
-	} else {
-		expiryTime = time.Unix((traffic.ExpiryTime / 1000), 0).Format("2006-01-02 15:04:05")
-	}
-	total := ""
-	if traffic.Total == 0 {
-		total = "♾Unlimited"
-	} else {
-		total = common.FormatTraffic((traffic.Total))
-	}
-	output := fmt.Sprintf("💡 Active: %t\r\n📧 Email: %s\r\n🔼 Upload↑: %s\r\n🔽 Download↓: %s\r\n🔄 Total: %s / %s\r\n📅 Expire in: %s\r\n",
-		traffic.Enable, traffic.Email, common.FormatTraffic(traffic.Up), common.FormatTraffic(traffic.Down), common.FormatTraffic((traffic.Up + traffic.Down)),
-		total, expiryTime)
-	t.SendMsgToTgbot(chatId, output)
-}
-
-func (t *Tgbot) getExhausted() string {
+func (t *Tgbot) getExhausted(lang string) string {
 	trDiff := int64(0)
 	exDiff := int64(0)
 	now := time.Now().Unix() * 1000
@@ -553,57 +828,36 @@ func (t *Tgbot) getExhausted() string {
 			disabledInbounds = append(disabledInbounds, *inbound)
 		}
 	}
-	output += fmt.Sprintf("Exhausted Inbounds count:\r\n🛑 Disabled: %d\r\n🔜 Deplete soon: %d\r\n \r\n", len(disabledInbounds), len(exhaustedInbounds))
+	output += Tr("exhaustedInboundsCount", lang, "Disabled=="+fmt.Sprint(len(disabledInbounds)), "Soon=="+fmt.Sprint(len(exhaustedInbounds))) + "\r\n \r\n"
 	if len(exhaustedInbounds) > 0 {
-		output += "Exhausted Inbounds:\r\n"
-		for _, inbound := range exhaustedInbounds {
-			output += fmt.Sprintf("📍Inbound:%s\r\nPort:%d\r\nTraffic: %s (↑%s,↓%s)\r\n", inbound.Remark, inbound.Port, common.FormatTraffic((inbound.Up + inbound.Down)), common.FormatTraffic(inbound.Up), common.FormatTraffic(inbound.Down))
-			if inbound.ExpiryTime == 0 {
-				output += "Expire date: ♾Unlimited\r\n \r\n"
-			} else {
-				output += fmt.Sprintf("Expire date:%s\r\n \r\n", time.Unix((inbound.ExpiryTime/1000), 0).Format("2006-01-02 15:04:05"))
-			}
+		output += Tr("exhaustedInbounds", lang) + "\r\n"
+		for i := range exhaustedInbounds {
+			output += inboundCard(&exhaustedInbounds[i], lang)
 		}
 	}
-	output += fmt.Sprintf("Exhausted Clients count:\r\n🛑 Exhausted: %d\r\n🔜 Deplete soon: %d\r\n \r\n", len(disabledClients), len(exhaustedClients))
+	output += Tr("exhaustedClientsCount", lang, "Exhausted=="+fmt.Sprint(len(disabledClients)), "Soon=="+fmt.Sprint(len(exhaustedClients))) + "\r\n \r\n"
 	if len(exhaustedClients) > 0 {
-		output += "Exhausted Clients:\r\n"
-		for _, traffic := range exhaustedClients {
-			expiryTime := ""
-			if traffic.ExpiryTime == 0 {
-				expiryTime = "♾Unlimited"
-			} else if traffic.ExpiryTime < 0 {
-				expiryTime += fmt.Sprintf("%d days", traffic.ExpiryTime/-86400000)
-			} else {
-				expiryTime = time.Unix((traffic.ExpiryTime / 1000), 0).Format("2006-01-02 15:04:05")
-			}
-			total := ""
-			if traffic.Total == 0 {
-				total = "♾Unlimited"
-			} else {
-				total = common.FormatTraffic((traffic.Total))
-			}
-			output += fmt.Sprintf("💡 Active: %t\r\n📧 Email: %s\r\n🔼 Upload↑: %s\r\n🔽 Download↓: %s\r\n🔄 Total: %s / %s\r\n📅 Expire date: %s\r\n \r\n",
-				traffic.Enable, traffic.Email, common.FormatTraffic(traffic.Up), common.FormatTraffic(traffic.Down), common.FormatTraffic((traffic.Up + traffic.Down)),
-				total, expiryTime)
+		output += Tr("exhaustedClients", lang) + "\r\n"
+		for i := range exhaustedClients {
+			output += clientCard(&exhaustedClients[i], lang) + " \r\n"
 		}
 	}
 
 	return output
 }
 
-func (t *Tgbot) sendBackup(chatId int64) {
+func (t *Tgbot) sendBackup(chatId int64, lang string) {
 	sendingTime := time.Now().Format("2006-01-02 15:04:05")
-	t.SendMsgToTgbot(chatId, "Backup time: "+sendingTime)
+	t.SendMsgToTgbot(chatId, Tr("msgBackupTime", lang, "Time=="+sendingTime))
 	file := tgbotapi.FilePath(config.GetDBPath())
 	msg := tgbotapi.NewDocument(chatId, file)
-	_, err := bot.Send(msg)
+	err := tgSend(msg)
 	if err != nil {
 		logger.Warning("Error in uploading backup: ", err)
 	}
 	file = tgbotapi.FilePath(xray.GetConfigPath())
 	msg = tgbotapi.NewDocument(chatId, file)
-	_, err = bot.Send(msg)
+	err = tgSend(msg)
 	if err != nil {
 		logger.Warning("Error in uploading config.json: ", err)
 	}
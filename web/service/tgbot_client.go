@@ -0,0 +1,363 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// callback data of the client buttons is client:<action>:<value>:<client traffic id>, the confirmed action
+// is sent back as client_ok:<action>:<value>:<client traffic id>, or client_ok:create:<plan id>:<tgId>:<email>
+const (
+	clientActionPrefix  = string("client:")
+	clientConfirmPrefix = string("client_ok:")
+)
+
+func adminName(user *tgbotapi.User) string {
+	if user == nil {
+		return ""
+	}
+	if user.UserName != "" {
+		return "@" + user.UserName
+	}
+	return user.FirstName
+}
+
+func (t *Tgbot) registerClientCommands(r *tgRouter) {
+	r.command("client", (*Tgbot).commandClient, r.adminOnly)
+	r.command("create", (*Tgbot).commandCreate, r.adminOnly)
+	for command, action := range map[string]string{
+		"addtraffic":   "traffic",
+		"extend":       "extend",
+		"enable":       "enable",
+		"disable":      "disable",
+		"resettraffic": "reset",
+		"resetips":     "ips",
+		"delclient":    "delete",
+	} {
+		action := action
+		r.command(command, func(t *Tgbot, ctx *tgContext) { t.commandClientAction(ctx, action) }, r.adminOnly)
+	}
+	r.callbackPrefix(clientActionPrefix, (*Tgbot).handleClientAction, r.adminOnly)
+	r.callbackPrefix(clientConfirmPrefix, (*Tgbot).handleClientConfirm, r.adminOnly)
+}
+
+func (t *Tgbot) getClientTraffic(id int) (*xray.ClientTraffic, error) {
+	db := database.GetDB()
+	traffic := &xray.ClientTraffic{}
+	err := db.Model(xray.ClientTraffic{}).First(traffic, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return traffic, nil
+}
+
+func clientCard(traffic *xray.ClientTraffic, lang string) string {
+	expiryTime := ""
+	if traffic.ExpiryTime == 0 {
+		expiryTime = Tr("unlimited", lang)
+	} else if traffic.ExpiryTime < 0 {
+		expiryTime = Tr("days", lang, "Days=="+fmt.Sprint(traffic.ExpiryTime/-86400000))
+	} else {
+		expiryTime = time.Unix((traffic.ExpiryTime / 1000), 0).Format("2006-01-02 15:04:05")
+	}
+	total := ""
+	if traffic.Total == 0 {
+		total = Tr("unlimited", lang)
+	} else {
+		total = common.FormatTraffic((traffic.Total))
+	}
+	active := Tr("no", lang)
+	if traffic.Enable {
+		active = Tr("yes", lang)
+	}
+	return Tr("clientCard", lang,
+		"Active=="+active,
+		"Email=="+traffic.Email,
+		"Up=="+common.FormatTraffic(traffic.Up),
+		"Down=="+common.FormatTraffic(traffic.Down),
+		"Used=="+common.FormatTraffic((traffic.Up+traffic.Down)),
+		"Total=="+total,
+		"Expiry=="+expiryTime) + "\r\n"
+}
+
+func clientKeyboard(traffic *xray.ClientTraffic, lang string) tgbotapi.InlineKeyboardMarkup {
+	data := func(action string, value int) string {
+		return fmt.Sprintf("%s%s:%d:%d", clientActionPrefix, action, value, traffic.Id)
+	}
+	toggle := tgbotapi.NewInlineKeyboardButtonData(Tr("clientDisable", lang), data("disable", 0))
+	if !traffic.Enable {
+		toggle = tgbotapi.NewInlineKeyboardButtonData(Tr("clientEnable", lang), data("enable", 0))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(Tr("clientAddTraffic", lang, "GB==10"), data("traffic", 10)),
+			tgbotapi.NewInlineKeyboardButtonData(Tr("clientAddTraffic", lang, "GB==50"), data("traffic", 50)),
+			tgbotapi.NewInlineKeyboardButtonData(Tr("clientExtend", lang, "Days==30"), data("extend", 30)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			toggle,
+			tgbotapi.NewInlineKeyboardButtonData(Tr("clientResetTraffic", lang), data("reset", 0)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(Tr("clientResetIps", lang), data("ips", 0)),
+			tgbotapi.NewInlineKeyboardButtonData(Tr("clientDelete", lang), data("delete", 0)),
+		),
+	)
+}
+
+// describeClientAction names an action on a client with the key action<Action> of the translations
+func describeClientAction(action string, value int, email string, lang string) string {
+	return Tr("action"+strings.ToUpper(action[:1])+action[1:], lang, "Email=="+email, "Value=="+fmt.Sprint(value))
+}
+
+func confirmKeyboard(confirm string, cancel string, lang string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(Tr("confirm", lang), confirm),
+		tgbotapi.NewInlineKeyboardButtonData(Tr("cancel", lang), cancel),
+	))
+}
+
+// commandClient shows a client with the buttons to manage it
+func (t *Tgbot) commandClient(ctx *tgContext) {
+	email := strings.TrimSpace(ctx.update.Message.CommandArguments())
+	traffic, err := t.inboundService.GetClientTrafficByEmail(email)
+	if err != nil || traffic == nil {
+		t.SendMsgToTgbot(ctx.chatId, Tr("msgClientUsage", ctx.lang))
+		return
+	}
+	msg := tgbotapi.NewMessage(ctx.chatId, clientCard(traffic, ctx.lang))
+	msg.ReplyMarkup = clientKeyboard(traffic, ctx.lang)
+	tgSend(msg)
+}
+
+// commandClientAction asks to confirm an action given as /command email [value]
+func (t *Tgbot) commandClientAction(ctx *tgContext, action string) {
+	args := strings.Fields(ctx.update.Message.CommandArguments())
+	value := 0
+	if action == "traffic" || action == "extend" {
+		if len(args) == 2 {
+			value, _ = strconv.Atoi(args[1])
+		}
+		if value <= 0 {
+			t.SendMsgToTgbot(ctx.chatId, Tr("msgClientActionUsage", ctx.lang, "Command=="+ctx.update.Message.Command()+" email amount"))
+			return
+		}
+	}
+	if len(args) == 0 {
+		t.SendMsgToTgbot(ctx.chatId, Tr("msgClientActionUsage", ctx.lang, "Command=="+ctx.update.Message.Command()+" email"))
+		return
+	}
+	traffic, err := t.inboundService.GetClientTrafficByEmail(args[0])
+	if err != nil || traffic == nil {
+		t.SendMsgToTgbot(ctx.chatId, Tr("msgClientNotFound", ctx.lang, "Email=="+args[0]))
+		return
+	}
+	msg := tgbotapi.NewMessage(ctx.chatId, Tr("msgConfirmAction", ctx.lang, "Action=="+describeClientAction(action, value, traffic.Email, ctx.lang)))
+	msg.ReplyMarkup = confirmKeyboard(
+		fmt.Sprintf("%s%s:%d:%d", clientConfirmPrefix, action, value, traffic.Id),
+		fmt.Sprintf("%scard:0:%d", clientActionPrefix, traffic.Id), ctx.lang)
+	tgSend(msg)
+}
+
+// commandCreate lists the plans, or asks to confirm the creation of a client given as /create plan email [tgId]
+func (t *Tgbot) commandCreate(ctx *tgContext) {
+	args := strings.Fields(ctx.update.Message.CommandArguments())
+	if len(args) < 2 {
+		plans, err := t.clientPlanService.GetEnabledPlans()
+		if err != nil {
+			t.SendMsgToTgbot(ctx.chatId, Tr("msgSomethingWrong", ctx.lang))
+			return
+		}
+		msg := Tr("msgCreateUsage", ctx.lang) + "\r\n"
+		for _, plan := range plans {
+			msg += fmt.Sprintf("\r\n%d. %s - %d", plan.Id, plan.Name, plan.Price)
+		}
+		t.SendMsgToTgbot(ctx.chatId, msg)
+		return
+	}
+	planId, err := strconv.Atoi(args[0])
+	if err != nil {
+		t.SendMsgToTgbot(ctx.chatId, Tr("msgIncorrectPlan", ctx.lang, "Plan=="+args[0]))
+		return
+	}
+	plan, err := t.clientPlanService.GetPlan(planId)
+	if err != nil || !plan.Enable {
+		t.SendMsgToTgbot(ctx.chatId, Tr("msgIncorrectPlan", ctx.lang, "Plan=="+args[0]))
+		return
+	}
+	tgId := ""
+	if len(args) > 2 {
+		tgId = args[2]
+	}
+	confirm := fmt.Sprintf("%screate:%d:%s:%s", clientConfirmPrefix, plan.Id, tgId, args[1])
+	if len(confirm) > 64 {
+		t.SendMsgToTgbot(ctx.chatId, Tr("msgCreateTooLong", ctx.lang))
+		return
+	}
+	msg := tgbotapi.NewMessage(ctx.chatId, Tr("msgConfirmCreate", ctx.lang, "Email=="+args[1], "Plan=="+plan.Name))
+	msg.ReplyMarkup = confirmKeyboard(confirm, clientActionPrefix+"cancel:0:0", ctx.lang)
+	tgSend(msg)
+}
+
+func parseClientData(data string, prefix string) (action string, value int, rest string, ok bool) {
+	fields := strings.SplitN(strings.TrimPrefix(data, prefix), ":", 3)
+	if len(fields) != 3 {
+		return "", 0, "", false
+	}
+	value, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, "", false
+	}
+	return fields[0], value, fields[2], true
+}
+
+// handleClientAction shows a client again or replaces its buttons with a confirmation of the action
+func (t *Tgbot) handleClientAction(ctx *tgContext) {
+	message := ctx.update.CallbackQuery.Message
+	action, value, rest, ok := parseClientData(ctx.update.CallbackQuery.Data, clientActionPrefix)
+	if !ok {
+		return
+	}
+	if action == "cancel" {
+		tgSend(tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, Tr("cancelled", ctx.lang)))
+		return
+	}
+	id, _ := strconv.Atoi(rest)
+	traffic, err := t.getClientTraffic(id)
+	if err != nil {
+		tgSend(tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, Tr("msgClientNotFound", ctx.lang, "Email==")))
+		return
+	}
+
+	var edit tgbotapi.EditMessageTextConfig
+	if action == "card" {
+		edit = tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, clientCard(traffic, ctx.lang))
+		keyboard := clientKeyboard(traffic, ctx.lang)
+		edit.ReplyMarkup = &keyboard
+	} else {
+		edit = tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID,
+			Tr("msgConfirmAction", ctx.lang, "Action=="+describeClientAction(action, value, traffic.Email, ctx.lang)))
+		keyboard := confirmKeyboard(
+			fmt.Sprintf("%s%s:%d:%d", clientConfirmPrefix, action, value, traffic.Id),
+			fmt.Sprintf("%scard:0:%d", clientActionPrefix, traffic.Id), ctx.lang)
+		edit.ReplyMarkup = &keyboard
+	}
+	tgSend(edit)
+}
+
+// handleClientConfirm runs a confirmed action, records it and shows the result
+func (t *Tgbot) handleClientConfirm(ctx *tgContext) {
+	query := ctx.update.CallbackQuery
+	message := query.Message
+	action, value, rest, ok := parseClientData(query.Data, clientConfirmPrefix)
+	if !ok {
+		return
+	}
+
+	email := ""
+	var err error
+	var traffic *xray.ClientTraffic
+	if action == "create" {
+		tgId, newEmail, _ := strings.Cut(rest, ":")
+		email = newEmail
+		_, err = t.clientPlanService.CreateClientFromPlan(value, email, tgId)
+		if err == nil {
+			traffic, err = t.inboundService.GetClientTrafficByEmail(email)
+		}
+	} else {
+		id, _ := strconv.Atoi(rest)
+		traffic, err = t.getClientTraffic(id)
+		if err != nil {
+			tgSend(tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, Tr("msgClientNotFound", ctx.lang, "Email==")))
+			return
+		}
+		email = traffic.Email
+		err = t.runClientAction(action, value, traffic)
+		if err == nil && action != "delete" {
+			traffic, err = t.getClientTraffic(id)
+		}
+	}
+	t.recordAdminAction(ctx.chatId, adminName(query.From), action, email, value, err)
+	if err != nil {
+		tgSend(tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID,
+			Tr("msgActionFailed", ctx.lang, "Action=="+describeClientAction(action, value, email, ctx.lang), "Error=="+err.Error())))
+		return
+	}
+	t.xrayService.SetToNeedRestart()
+
+	text := Tr("msgActionDone", ctx.lang, "Action=="+describeClientAction(action, value, email, ctx.lang), "Name=="+adminName(query.From))
+	if action == "delete" || traffic == nil {
+		tgSend(tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text))
+		return
+	}
+	edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text+"\r\n\r\n"+clientCard(traffic, ctx.lang))
+	keyboard := clientKeyboard(traffic, ctx.lang)
+	edit.ReplyMarkup = &keyboard
+	tgSend(edit)
+}
+
+func (t *Tgbot) runClientAction(action string, value int, traffic *xray.ClientTraffic) error {
+	filter := ClientFilter{Emails: []string{traffic.Email}}
+	switch action {
+	case "traffic":
+		_, err := t.clientBulkService.ExtendClients(&BulkClientRequest{ClientFilter: filter, Traffic: int64(value) << 30})
+		return err
+	case "extend":
+		_, err := t.clientBulkService.ExtendClients(&BulkClientRequest{ClientFilter: filter, Days: value})
+		return err
+	case "enable", "disable":
+		_, err := t.clientBulkService.SetClientsEnable(&BulkClientRequest{ClientFilter: filter, Enable: action == "enable"})
+		return err
+	case "reset":
+		return t.inboundService.ResetClientTraffic(traffic.InboundId, traffic.Email)
+	case "ips":
+		return t.inboundService.ClearClientIps(traffic.Email)
+	case "delete":
+		inbound, err := t.inboundService.GetInbound(traffic.InboundId)
+		if err != nil {
+			return err
+		}
+		clients, err := t.inboundService.getClients(inbound)
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			if client.Email != traffic.Email {
+				continue
+			}
+			clientId := client.ID
+			if inbound.Protocol == model.Trojan {
+				clientId = client.Password
+			}
+			return t.inboundService.DelInboundClient(inbound.Id, clientId)
+		}
+		return common.NewError("client not found:", traffic.Email)
+	}
+	return common.NewError("unknown client action:", action)
+}
+
+func (t *Tgbot) recordAdminAction(adminId int64, admin string, action string, email string, value int, cause error) {
+	record := &model.TgAdminAction{
+		AdminId: adminId,
+		Admin:   admin,
+		Action:  action,
+		Email:   email,
+		Value:   strconv.Itoa(value),
+	}
+	if cause != nil {
+		record.Error = cause.Error()
+	}
+	db := database.GetDB()
+	if err := db.Create(record).Error; err != nil {
+		logger.Warning("record telegram admin action failed:", err)
+	}
+}
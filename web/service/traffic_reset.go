@@ -0,0 +1,276 @@
+package service
+
+import (
+	"time"
+	"x-ui/database"
+	"x-ui/database/model"
+	"x-ui/logger"
+	"x-ui/util/common"
+	"x-ui/xray"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	ResetDaily   = "daily"
+	ResetWeekly  = "weekly"
+	ResetMonthly = "monthly"
+	ResetCron    = "cron"
+
+	AnchorCreation = "creation"
+	AnchorCalendar = "calendar"
+)
+
+// the same format as the cron of the panel, with seconds
+var resetCronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+type TrafficResetService struct {
+	settingService SettingService
+}
+
+func (s *TrafficResetService) GetPolicies() ([]*model.TrafficResetPolicy, error) {
+	db := database.GetDB()
+	var policies []*model.TrafficResetPolicy
+	err := db.Model(model.TrafficResetPolicy{}).Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (s *TrafficResetService) checkPolicy(policy *model.TrafficResetPolicy) error {
+	if policy.InboundId == 0 && policy.Email == "" {
+		return common.NewError("traffic reset policy needs an inbound or a client")
+	}
+	switch policy.Period {
+	case ResetDaily, ResetWeekly, ResetMonthly:
+	case ResetCron:
+		_, err := resetCronParser.Parse(policy.Cron)
+		if err != nil {
+			return common.NewError("invalid traffic reset cron:", policy.Cron, err)
+		}
+	default:
+		return common.NewError("unknown traffic reset period:", policy.Period)
+	}
+	switch policy.Anchor {
+	case AnchorCreation, AnchorCalendar:
+	case "":
+		policy.Anchor = AnchorCalendar
+	default:
+		return common.NewError("unknown traffic reset anchor:", policy.Anchor)
+	}
+	return nil
+}
+
+func (s *TrafficResetService) AddPolicy(policy *model.TrafficResetPolicy) error {
+	err := s.checkPolicy(policy)
+	if err != nil {
+		return err
+	}
+	policy.Id = 0
+	db := database.GetDB()
+	return db.Create(policy).Error
+}
+
+func (s *TrafficResetService) UpdatePolicy(policy *model.TrafficResetPolicy) error {
+	db := database.GetDB()
+	oldPolicy := &model.TrafficResetPolicy{}
+	err := db.Model(model.TrafficResetPolicy{}).First(oldPolicy, policy.Id).Error
+	if err != nil {
+		return err
+	}
+	policy.InboundId = oldPolicy.InboundId
+	policy.Email = oldPolicy.Email
+	err = s.checkPolicy(policy)
+	if err != nil {
+		return err
+	}
+	return db.Save(policy).Error
+}
+
+func (s *TrafficResetService) DelPolicy(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.TrafficResetPolicy{}, id).Error
+}
+
+func (s *TrafficResetService) GetTrafficPeriods(email string) ([]*model.TrafficPeriod, error) {
+	db := database.GetDB()
+	var periods []*model.TrafficPeriod
+	err := db.Model(model.TrafficPeriod{}).Where("email = ?", email).Order("start desc").Find(&periods).Error
+	if err != nil {
+		return nil, err
+	}
+	return periods, nil
+}
+
+// getResetPolicy picks the enabled client policy, then the inbound policy, nil when the client has none
+func getResetPolicy(policies []*model.TrafficResetPolicy, inboundId int, email string) *model.TrafficResetPolicy {
+	var inboundPolicy *model.TrafficResetPolicy
+	for _, policy := range policies {
+		if !policy.Enable {
+			continue
+		}
+		if policy.Email != "" && policy.Email == email {
+			return policy
+		}
+		if policy.Email == "" && policy.InboundId == inboundId {
+			inboundPolicy = policy
+		}
+	}
+	return inboundPolicy
+}
+
+// addMonth moves t one month ahead, the day is clamped to the end of shorter months
+func addMonth(t time.Time) time.Time {
+	year, month, day := t.Date()
+	lastDay := time.Date(year, month+2, 0, 0, 0, 0, 0, t.Location()).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(year, month+1, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// nextReset returns the end of the period that started at start
+func nextReset(policy *model.TrafficResetPolicy, start time.Time) (time.Time, error) {
+	year, month, day := start.Date()
+	calendar := policy.Anchor != AnchorCreation
+	switch policy.Period {
+	case ResetDaily:
+		if calendar {
+			return time.Date(year, month, day+1, 0, 0, 0, 0, start.Location()), nil
+		}
+		return start.AddDate(0, 0, 1), nil
+	case ResetWeekly:
+		if calendar {
+			// weeks start on monday
+			days := (8 - int(start.Weekday())) % 7
+			if days == 0 {
+				days = 7
+			}
+			return time.Date(year, month, day+days, 0, 0, 0, 0, start.Location()), nil
+		}
+		return start.AddDate(0, 0, 7), nil
+	case ResetMonthly:
+		if calendar {
+			return time.Date(year, month+1, 1, 0, 0, 0, 0, start.Location()), nil
+		}
+		return addMonth(start), nil
+	case ResetCron:
+		schedule, err := resetCronParser.Parse(policy.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return schedule.Next(start), nil
+	}
+	return time.Time{}, common.NewError("unknown traffic reset period:", policy.Period)
+}
+
+// currentPeriodStart returns the start of the period containing now, periods follow each other from first
+func currentPeriodStart(policy *model.TrafficResetPolicy, first time.Time, now time.Time) time.Time {
+	start := first
+	for {
+		next, err := nextReset(policy, start)
+		if err != nil || next.After(now) {
+			return start
+		}
+		start = next
+	}
+}
+
+// ResetTraffics resets the clients whose period is over, records the usage of the period
+// and re-enables the clients disabled by their quota that have not expired, it returns the number of reset clients
+func (s *TrafficResetService) ResetTraffics() (count int, err error) {
+	policies, err := s.GetPolicies()
+	if err != nil || len(policies) == 0 {
+		return 0, err
+	}
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return 0, err
+	}
+
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).Find(&traffics).Error
+	if err != nil {
+		return 0, err
+	}
+
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	now := time.Now().In(loc)
+	for _, traffic := range traffics {
+		policy := getResetPolicy(policies, traffic.InboundId, traffic.Email)
+		if policy == nil {
+			continue
+		}
+		if traffic.PeriodStart == 0 {
+			// the first period starts when the client is first seen with a policy, with the creation anchor it is
+			// the current one of the periods counted from the creation of the client, clients created before the
+			// creation time was recorded start when they are first seen as well
+			start := now
+			if policy.Anchor == AnchorCreation && policy.Period != ResetCron && traffic.CreatedAt > 0 {
+				start = currentPeriodStart(policy, time.UnixMilli(traffic.CreatedAt).In(loc), now)
+			}
+			err = tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).Update("period_start", start.UnixMilli()).Error
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		end, err1 := nextReset(policy, time.UnixMilli(traffic.PeriodStart).In(loc))
+		if err1 != nil {
+			logger.Warning("traffic reset of", traffic.Email, "failed:", err1)
+			continue
+		}
+		if end.After(now) {
+			continue
+		}
+		// skip the periods that passed while the panel was down
+		for {
+			next, _ := nextReset(policy, end)
+			if next.After(now) {
+				break
+			}
+			end = next
+		}
+
+		err = tx.Create(&model.TrafficPeriod{
+			InboundId: traffic.InboundId,
+			Email:     traffic.Email,
+			Start:     traffic.PeriodStart,
+			End:       end.UnixMilli(),
+			Up:        traffic.Up,
+			Down:      traffic.Down,
+			Total:     traffic.Total,
+		}).Error
+		if err != nil {
+			return 0, err
+		}
+		// traffic the xray traffic job added since the read is kept for the next period
+		updates := map[string]interface{}{
+			"up":           gorm.Expr("up - ?", traffic.Up),
+			"down":         gorm.Expr("down - ?", traffic.Down),
+			"period_start": end.UnixMilli(),
+		}
+		// only clients disabled by their quota come back, DisableInvalidClients takes care of the rest
+		if !traffic.Enable && traffic.IsDepleted() && (traffic.ExpiryTime <= 0 || traffic.ExpiryTime > now.UnixMilli()) {
+			updates["enable"] = true
+		}
+		err = tx.Model(xray.ClientTraffic{}).Where("id = ?", traffic.Id).Updates(updates).Error
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
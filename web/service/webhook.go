@@ -0,0 +1,62 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+	"x-ui/logger"
+	"x-ui/util/common"
+)
+
+// webhooks waiting to be sent, once it is full new ones are dropped
+const webhookQueueSize = 100
+
+type webhookRequest struct {
+	url  string
+	data []byte
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+var webhookQueue = make(chan *webhookRequest, webhookQueueSize)
+var webhookOnce sync.Once
+
+// sendWebhook queues a JSON POST of payload to url, the webhooks are sent one by one in the background
+// so a slow endpoint can not stall the jobs calling it
+func sendWebhook(url string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logger.Warning("marshal webhook failed:", err)
+		return
+	}
+	webhookOnce.Do(func() {
+		go runWebhooks()
+	})
+	select {
+	case webhookQueue <- &webhookRequest{url: url, data: data}:
+	default:
+		logger.Warning("webhook queue is full, drop the webhook to", url)
+	}
+}
+
+func runWebhooks() {
+	for request := range webhookQueue {
+		err := postWebhook(request.url, request.data)
+		if err != nil {
+			logger.Warning("send webhook to", request.url, "failed:", err)
+		}
+	}
+}
+
+func postWebhook(url string, data []byte) error {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return common.NewError(resp.Status)
+	}
+	return nil
+}
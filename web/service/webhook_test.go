@@ -0,0 +1,39 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendWebhookDoesNotBlock(t *testing.T) {
+	received := make(chan string, 2)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		<-release
+		received <- string(body)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	sendWebhook(server.URL, map[string]int{"n": 1})
+	sendWebhook(server.URL, map[string]int{"n": 2})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sendWebhook blocked for %v on a slow endpoint", elapsed)
+	}
+
+	close(release)
+	for _, want := range []string{`{"n":1}`, `{"n":2}`} {
+		select {
+		case body := <-received:
+			if body != want {
+				t.Errorf("webhook body %v, want %v", body, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook was not sent")
+		}
+	}
+}
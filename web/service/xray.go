@@ -16,8 +16,9 @@ var isNeedXrayRestart atomic.Bool
 var result string
 
 type XrayService struct {
-	inboundService InboundService
-	settingService SettingService
+	inboundService     InboundService
+	settingService     SettingService
+	policyLevelService PolicyLevelService
 }
 
 func (s *XrayService) IsXrayRunning() bool {
@@ -115,7 +116,7 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 					}
 				}
 				for key := range c {
-					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "alterId" {
+					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "alterId" && key != "level" {
 						delete(c, key)
 					}
 					if c["flow"] == "xtls-rprx-vision-udp443" {
@@ -136,9 +137,39 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		inboundConfig := inbound.GenXrayInboundConfig()
 		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *inboundConfig)
 	}
+
+	xrayConfig.Policy, err = s.policyLevelService.ApplyLevels(xrayConfig.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if xrayConfig.HasObservatory() {
+		xrayConfig.API, err = s.enableApiService(xrayConfig.API, "ObservatoryService")
+		if err != nil {
+			return nil, err
+		}
+	}
 	return xrayConfig, nil
 }
 
+func (s *XrayService) enableApiService(apiConfig []byte, name string) ([]byte, error) {
+	api := map[string]interface{}{}
+	if len(apiConfig) > 0 {
+		err := json.Unmarshal(apiConfig, &api)
+		if err != nil {
+			return nil, err
+		}
+	}
+	services, _ := api["services"].([]interface{})
+	for _, service := range services {
+		if service == name {
+			return apiConfig, nil
+		}
+	}
+	api["services"] = append(services, name)
+	return json.Marshal(api)
+}
+
 func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic, error) {
 	if !s.IsXrayRunning() {
 		return nil, nil, errors.New("xray is not running")
@@ -146,6 +177,23 @@ func (s *XrayService) GetXrayTraffic() ([]*xray.Traffic, []*xray.ClientTraffic,
 	return p.GetTraffic(true)
 }
 
+func (s *XrayService) GetOutboundStatus() ([]*xray.OutboundStatus, error) {
+	if !s.IsXrayRunning() {
+		return nil, errors.New("xray is not running")
+	}
+	if !p.GetConfig().HasObservatory() {
+		return nil, nil
+	}
+	return p.GetOutboundStatus()
+}
+
+func (s *XrayService) GetAccessLogPath() string {
+	if p == nil {
+		return ""
+	}
+	return p.GetConfig().GetAccessLogPath()
+}
+
 func (s *XrayService) RestartXray(isForce bool) error {
 	lock.Lock()
 	defer lock.Unlock()
@@ -18,6 +18,7 @@ import (
 	"x-ui/util/common"
 	"x-ui/web/controller"
 	"x-ui/web/job"
+	"x-ui/web/locale"
 	"x-ui/web/network"
 	"x-ui/web/service"
 
@@ -25,9 +26,7 @@ import (
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
-	"github.com/pelletier/go-toml/v2"
 	"github.com/robfig/cron/v3"
-	"golang.org/x/text/language"
 )
 
 //go:embed assets/*
@@ -86,6 +85,7 @@ type Server struct {
 	xui    *controller.XUIController
 	api    *controller.APIController
 	sub    *controller.SUBController
+	pay    *controller.PaymentController
 
 	xrayService    service.XrayService
 	settingService service.SettingService
@@ -210,30 +210,17 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	s.xui = controller.NewXUIController(g)
 	s.api = controller.NewAPIController(g)
 	s.sub = controller.NewSUBController(g)
+	s.pay = controller.NewPaymentController(g)
 
 	return engine, nil
 }
 
 func (s *Server) initI18n(engine *gin.Engine) error {
-	bundle := i18n.NewBundle(language.SimplifiedChinese)
-	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
-	err := fs.WalkDir(i18nFS, "translation", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			return nil
-		}
-		data, err := i18nFS.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		_, err = bundle.ParseMessageFileBytes(data, path)
-		return err
-	})
+	err := locale.InitLocalizer(i18nFS, "translation")
 	if err != nil {
 		return err
 	}
+	bundle := locale.GetBundle()
 
 	findI18nParamNames := func(key string) []string {
 		names := make([]string, 0)
@@ -296,6 +283,10 @@ func (s *Server) initI18n(engine *gin.Engine) error {
 }
 
 func (s *Server) startTask() {
+	// write the persisted ip limit bans to the blocked ips of xray before it starts
+	checkClientIpJob := job.NewCheckClientIpJob()
+	checkClientIpJob.Run()
+
 	err := s.xrayService.RestartXray(true)
 	if err != nil {
 		logger.Warning("start xray failed:", err)
@@ -312,8 +303,38 @@ func (s *Server) startTask() {
 	// Check the inbound traffic every 30 seconds that the traffic exceeds and expires
 	s.cron.AddJob("@every 30s", job.NewCheckInboundJob())
 
-	// check client ips from log file every 10 sec
-	s.cron.AddJob("@every 10s", job.NewCheckClientIpJob())
+	// tail the access log every 10 sec and pass the records to its consumers
+	accessLogJob := job.NewAccessLogJob()
+	accessLogJob.Subscribe(checkClientIpJob)
+	accessLogJob.Subscribe(&service.AccessLogService{})
+	s.cron.AddJob("@every 10s", accessLogJob)
+
+	// check client ips collected from the access log every 10 sec
+	s.cron.AddJob("@every 10s", checkClientIpJob)
+
+	// Check outbound liveness reported by observatory and alarm to TgBot on changes
+	s.cron.AddJob("@every 30s", job.NewCheckOutboundJob())
+
+	// Reset client traffics whose period is over every minute
+	s.cron.AddJob("@every 1m", job.NewTrafficResetJob())
+
+	// Evaluate the notification rules every 10 sec, alerts are deduplicated by their cooldown
+	s.cron.AddJob("@every 10s", job.NewNotifyRuleJob())
+
+	// Start the scheduled telegram broadcasts every 10 sec
+	s.cron.AddJob("@every 10s", job.NewBroadcastJob())
+
+	// Drop access statistics older than the retention period
+	s.cron.AddJob("@daily", job.NewClearAccessStatJob())
+
+	// Update geoip/geosite files on schedule
+	geoRuntime, err := s.settingService.GetGeoUpdateRuntime()
+	if err == nil && geoRuntime != "" {
+		_, err = s.cron.AddJob(geoRuntime, job.NewUpdateGeoJob())
+		if err != nil {
+			logger.Warning("Add NewUpdateGeoJob error", err)
+		}
+	}
 
 	// Make a traffic condition every day, 8:30
 	var entry cron.EntryID
@@ -331,12 +352,6 @@ func (s *Server) startTask() {
 			return
 		}
 
-		// Check CPU load and alarm to TgBot if threshold passes
-		cpuThreshold, err := s.settingService.GetTgCpu()
-		if (err == nil) && (cpuThreshold > 0) {
-			s.cron.AddJob("@every 10s", job.NewCheckCpuJob())
-		}
-
 	} else {
 		s.cron.Remove(entry)
 	}
@@ -0,0 +1,78 @@
+package xray
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"x-ui/util/common"
+)
+
+var routeRegex = regexp.MustCompile(` (?:>>|->) `)
+
+type AccessLog struct {
+	Time        int64  `json:"time"`
+	SourceIP    string `json:"sourceIp"`
+	SourcePort  int    `json:"sourcePort"`
+	Accepted    bool   `json:"accepted"`
+	Network     string `json:"network"`
+	Destination string `json:"destination"`
+	Port        int    `json:"port"`
+	Inbound     string `json:"inbound"`
+	Outbound    string `json:"outbound"`
+	Email       string `json:"email"`
+}
+
+// splitNetworkAddress splits addresses like tcp:example.com:443, [2001:db8::1]:443 or 1.2.3.4:5678
+func splitNetworkAddress(address string) (network string, host string, port int) {
+	for _, prefix := range []string{"tcp:", "udp:"} {
+		if strings.HasPrefix(address, prefix) {
+			network = strings.TrimSuffix(prefix, ":")
+			address = strings.TrimPrefix(address, prefix)
+			break
+		}
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return network, strings.Trim(address, "[]"), 0
+	}
+	port, _ = strconv.Atoi(portStr)
+	return network, host, port
+}
+
+// ParseAccessLog parses one line of the xray access log, it returns nil for lines that are not connection records
+func ParseAccessLog(line string) *AccessLog {
+	rest := line
+	accessLog := &AccessLog{}
+	if matches := logTimeRegex.FindStringSubmatch(line); matches != nil {
+		t, err := time.ParseInLocation("2006/01/02 15:04:05", matches[1], time.Local)
+		if err == nil {
+			accessLog.Time = t.UnixMilli()
+		}
+		rest = matches[3]
+	}
+
+	matches := accessLogRegex.FindStringSubmatch(rest)
+	if matches == nil {
+		return nil
+	}
+	if accessLog.Time == 0 {
+		accessLog.Time = time.Now().UnixMilli()
+	}
+
+	_, sourceIp, sourcePort := splitNetworkAddress(matches[1])
+	accessLog.SourceIP = common.NormalizeIP(sourceIp)
+	accessLog.SourcePort = sourcePort
+	if accessLog.SourceIP == "" {
+		return nil
+	}
+	accessLog.Accepted = matches[2] == "accepted"
+	accessLog.Network, accessLog.Destination, accessLog.Port = splitNetworkAddress(matches[3])
+	accessLog.Email = matches[5]
+	if route := routeRegex.Split(matches[4], 2); len(route) == 2 {
+		accessLog.Inbound = route[0]
+		accessLog.Outbound = route[1]
+	}
+	return accessLog
+}
@@ -0,0 +1,179 @@
+package xray
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	accessLogBatchSize = 1000
+	// once the tailer has read this much it copies the access log to <path>.1 and truncates it
+	accessLogMaxSize = 10 * 1024 * 1024
+)
+
+// AccessLogConsumer receives the parsed access log records in the order xray wrote them
+type AccessLogConsumer interface {
+	ConsumeAccessLogs(accessLogs []*AccessLog)
+}
+
+// AccessLogTailer follows the xray access log by offset, coping with truncation and rotation.
+// It keeps the log bounded by rotating it itself once it grows past accessLogMaxSize.
+type AccessLogTailer struct {
+	lock      sync.Mutex
+	path      string
+	file      *os.File
+	offset    int64
+	skipLine  bool
+	consumers []AccessLogConsumer
+}
+
+func NewAccessLogTailer() *AccessLogTailer {
+	return new(AccessLogTailer)
+}
+
+func (t *AccessLogTailer) Subscribe(consumer AccessLogConsumer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.consumers = append(t.consumers, consumer)
+}
+
+func (t *AccessLogTailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+	t.offset = 0
+	t.skipLine = false
+}
+
+// open follows path, a file seen for the first time is read from its end so old records are not counted twice
+func (t *AccessLogTailer) open(path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.close()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if t.file != nil && t.path == path {
+		current, err := t.file.Stat()
+		if err == nil && os.SameFile(current, stat) {
+			if stat.Size() < t.offset {
+				// truncated by someone else
+				t.offset = 0
+				t.skipLine = false
+			}
+			return nil
+		}
+	}
+
+	fromEnd := t.path != path
+	t.close()
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	t.file = file
+	t.path = path
+	if fromEnd {
+		t.offset = stat.Size()
+	}
+	return nil
+}
+
+// Poll reads the records appended since the last call and passes them to the consumers in batches
+func (t *AccessLogTailer) Poll(path string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if path == "" || path == "none" {
+		t.close()
+		t.path = ""
+		return nil
+	}
+	err := t.open(path)
+	if err != nil || t.file == nil {
+		return err
+	}
+
+	_, err = t.file.Seek(t.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(t.file, 32*1024)
+	batch := make([]*AccessLog, 0, accessLogBatchSize)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull || t.skipLine {
+			// drop an oversized line, the rest of it is dropped by the next poll if xray is still writing it
+			t.offset += int64(len(line))
+			t.skipLine = err != nil
+			if err != nil && err != bufio.ErrBufferFull {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			// a partial line is read again once xray finishes writing it
+			break
+		}
+		t.offset += int64(len(line))
+		accessLog := ParseAccessLog(string(line[:len(line)-1]))
+		if accessLog == nil {
+			continue
+		}
+		batch = append(batch, accessLog)
+		if len(batch) == accessLogBatchSize {
+			t.dispatch(batch)
+			batch = make([]*AccessLog, 0, accessLogBatchSize)
+		}
+	}
+	t.dispatch(batch)
+
+	if t.offset >= accessLogMaxSize {
+		return t.rotate()
+	}
+	return nil
+}
+
+// rotate copies the access log to <path>.1 and truncates it, xray opens the log in append mode so it
+// continues from the start. Records appended after the last read would be lost, so it waits for the
+// next poll unless everything was read.
+func (t *AccessLogTailer) rotate() error {
+	stat, err := t.file.Stat()
+	if err != nil || stat.Size() != t.offset {
+		return err
+	}
+	rotated, err := os.Create(t.path + ".1")
+	if err != nil {
+		return err
+	}
+	_, err = t.file.Seek(0, io.SeekStart)
+	if err == nil {
+		_, err = io.Copy(rotated, io.LimitReader(t.file, t.offset))
+	}
+	rotated.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Truncate(t.path, 0)
+	if err != nil {
+		return err
+	}
+	t.offset = 0
+	t.skipLine = false
+	return nil
+}
+
+func (t *AccessLogTailer) dispatch(batch []*AccessLog) {
+	if len(batch) == 0 {
+		return
+	}
+	for _, consumer := range t.consumers {
+		consumer.ConsumeAccessLogs(batch)
+	}
+}
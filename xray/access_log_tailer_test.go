@@ -0,0 +1,104 @@
+package xray
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type accessLogRecorder struct {
+	emails []string
+}
+
+func (r *accessLogRecorder) ConsumeAccessLogs(accessLogs []*AccessLog) {
+	for _, accessLog := range accessLogs {
+		r.emails = append(r.emails, accessLog.Email)
+	}
+}
+
+func accessLogLine(email string) string {
+	return "2024/01/02 03:04:05 1.2.3.4:51234 accepted tcp:example.com:443 [in-1 >> direct] email: " + email + "\n"
+}
+
+func appendAccessLog(t *testing.T, path string, data string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = file.WriteString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestTailer(t *testing.T) (*AccessLogTailer, *accessLogRecorder, string) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	err := os.WriteFile(path, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &accessLogRecorder{}
+	tailer := NewAccessLogTailer()
+	tailer.Subscribe(recorder)
+	err = tailer.Poll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tailer, recorder, path
+}
+
+func TestAccessLogTailerOversizedLine(t *testing.T) {
+	tailer, recorder, path := newTestTailer(t)
+
+	// an oversized line that xray is still writing when the tailer reaches the end of the file
+	appendAccessLog(t, path, accessLogLine("user1")+strings.Repeat("x", 40*1024))
+	err := tailer.Poll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAccessLog(t, path, accessLogLine("garbage")+accessLogLine("user2"))
+	err = tailer.Poll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(recorder.emails, ",") != "user1,user2" {
+		t.Errorf("consumed %v, want [user1 user2]", recorder.emails)
+	}
+}
+
+func TestAccessLogTailerRotate(t *testing.T) {
+	tailer, recorder, path := newTestTailer(t)
+
+	line := accessLogLine("user1")
+	appendAccessLog(t, path, strings.Repeat(line, accessLogMaxSize/len(line)+1))
+	err := tailer.Poll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size() != 0 {
+		t.Errorf("access log is %v bytes after rotation, want 0", stat.Size())
+	}
+	rotated, err := os.Stat(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Size() < 10<<20 {
+		t.Errorf("rotated access log is %v bytes, want at least %v", rotated.Size(), 10<<20)
+	}
+
+	appendAccessLog(t, path, accessLogLine("user2"))
+	err = tailer.Poll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := recorder.emails[len(recorder.emails)-1]; last != "user2" {
+		t.Errorf("last consumed %v after rotation, want user2", last)
+	}
+}
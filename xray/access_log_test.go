@@ -0,0 +1,61 @@
+package xray
+
+import "testing"
+
+func TestParseAccessLog(t *testing.T) {
+	tests := []struct {
+		line   string
+		ip     string
+		port   int
+		email  string
+		accept bool
+	}{
+		{
+			"2024/01/02 03:04:05 1.2.3.4:51234 accepted tcp:example.com:443 [in-1 >> direct] email: user1",
+			"1.2.3.4", 51234, "user1", true,
+		},
+		{
+			"2024/01/02 03:04:05.123456 from 1.2.3.4:51234 accepted udp:8.8.8.8:53 [in-1 -> direct] email: user1",
+			"1.2.3.4", 51234, "user1", true,
+		},
+		{
+			"2024/01/02 03:04:05 [2001:db8::1]:51234 accepted tcp:example.com:443 [in-1 >> direct] email: user2",
+			"2001:db8::1", 51234, "user2", true,
+		},
+		{
+			"2024/01/02 03:04:05 [2001:DB8:0::1]:443 rejected tcp:example.com:443 [in-1 >> blocked] email: user2",
+			"2001:db8::1", 443, "user2", false,
+		},
+		{
+			"2024/01/02 03:04:05 [::ffff:1.2.3.4]:51234 accepted tcp:example.com:443 [in-1 >> direct] email: user3",
+			"1.2.3.4", 51234, "user3", true,
+		},
+		{
+			"2024/01/02 03:04:05 tcp:[::ffff:10.0.0.1]:51234 accepted tcp:example.com:443 [in-1 >> direct]",
+			"10.0.0.1", 51234, "", true,
+		},
+	}
+	for _, test := range tests {
+		accessLog := ParseAccessLog(test.line)
+		if accessLog == nil {
+			t.Errorf("ParseAccessLog(%q) = nil", test.line)
+			continue
+		}
+		if accessLog.SourceIP != test.ip || accessLog.SourcePort != test.port ||
+			accessLog.Email != test.email || accessLog.Accepted != test.accept {
+			t.Errorf("ParseAccessLog(%q) = %v %v %q %v, want %v %v %q %v", test.line,
+				accessLog.SourceIP, accessLog.SourcePort, accessLog.Email, accessLog.Accepted,
+				test.ip, test.port, test.email, test.accept)
+		}
+	}
+
+	for _, line := range []string{
+		"",
+		"2024/01/02 03:04:05 [Info] app/proxyman/inbound: connection ends",
+		"2024/01/02 03:04:05 example.com:51234 accepted tcp:example.com:443 [in-1 >> direct]",
+	} {
+		if accessLog := ParseAccessLog(line); accessLog != nil {
+			t.Errorf("ParseAccessLog(%q) = %+v, want nil", line, accessLog)
+		}
+	}
+}
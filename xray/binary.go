@@ -0,0 +1,47 @@
+package xray
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"x-ui/config"
+	"x-ui/util/common"
+)
+
+func GetVersionsFolderPath() string {
+	return config.GetBinFolderPath() + "/xray-versions"
+}
+
+func GetVersionFolderPath(version string) string {
+	return GetVersionsFolderPath() + "/" + version
+}
+
+func GetVersionBinaryPath(version string) string {
+	return GetVersionFolderPath(version) + "/" + GetBinaryName()
+}
+
+func GetBinaryVersion(binaryPath string) string {
+	cmd := exec.Command(binaryPath, "-version")
+	data, err := cmd.Output()
+	if err != nil {
+		return "Unknown"
+	}
+	datas := bytes.Split(data, []byte(" "))
+	if len(datas) <= 1 {
+		return "Unknown"
+	}
+	return string(datas[1])
+}
+
+// TestBinary runs the binary in test mode against the given config without starting it
+func TestBinary(binaryPath string, configPath string) error {
+	cmd := exec.Command(binaryPath, "-test", "-c", configPath)
+	// the config refers to geosite/geoip files next to the running binary, not next to the tested one
+	cmd.Env = append(os.Environ(), "XRAY_LOCATION_ASSET="+config.GetBinFolderPath())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return common.NewErrorf("xray config test failed: %v %v", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
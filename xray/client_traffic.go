@@ -1,12 +1,24 @@
 package xray
 
 type ClientTraffic struct {
-	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
-	InboundId  int    `json:"inboundId" form:"inboundId"`
-	Enable     bool   `json:"enable" form:"enable"`
-	Email      string `json:"email" form:"email" gorm:"unique"`
-	Up         int64  `json:"up" form:"up"`
-	Down       int64  `json:"down" form:"down"`
-	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
-	Total      int64  `json:"total" form:"total"`
+	Id          int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId   int    `json:"inboundId" form:"inboundId"`
+	Enable      bool   `json:"enable" form:"enable"`
+	Email       string `json:"email" form:"email" gorm:"unique"`
+	Up          int64  `json:"up" form:"up"`
+	Down        int64  `json:"down" form:"down"`
+	ExpiryTime  int64  `json:"expiryTime" form:"expiryTime"`
+	Total       int64  `json:"total" form:"total"`
+	UpLimit     int64  `json:"upLimit" form:"upLimit"`
+	DownLimit   int64  `json:"downLimit" form:"downLimit"`
+	CustomerId  int    `json:"customerId" form:"customerId" gorm:"index"`
+	PeriodStart int64  `json:"periodStart" form:"periodStart"`
+	CreatedAt   int64  `json:"createdAt" gorm:"autoCreateTime:milli"`
+}
+
+// IsDepleted reports whether the client used up its total, upload or download quota
+func (t *ClientTraffic) IsDepleted() bool {
+	return (t.Total > 0 && t.Up+t.Down >= t.Total) ||
+		(t.UpLimit > 0 && t.Up >= t.UpLimit) ||
+		(t.DownLimit > 0 && t.Down >= t.DownLimit)
 }
@@ -2,21 +2,24 @@ package xray
 
 import (
 	"bytes"
+	"encoding/json"
 	"x-ui/util/json_util"
 )
 
 type Config struct {
-	LogConfig       json_util.RawMessage `json:"log"`
-	RouterConfig    json_util.RawMessage `json:"routing"`
-	DNSConfig       json_util.RawMessage `json:"dns"`
-	InboundConfigs  []InboundConfig      `json:"inbounds"`
-	OutboundConfigs json_util.RawMessage `json:"outbounds"`
-	Transport       json_util.RawMessage `json:"transport"`
-	Policy          json_util.RawMessage `json:"policy"`
-	API             json_util.RawMessage `json:"api"`
-	Stats           json_util.RawMessage `json:"stats"`
-	Reverse         json_util.RawMessage `json:"reverse"`
-	FakeDNS         json_util.RawMessage `json:"fakeDns"`
+	LogConfig        json_util.RawMessage `json:"log"`
+	RouterConfig     json_util.RawMessage `json:"routing"`
+	DNSConfig        json_util.RawMessage `json:"dns"`
+	InboundConfigs   []InboundConfig      `json:"inbounds"`
+	OutboundConfigs  json_util.RawMessage `json:"outbounds"`
+	Transport        json_util.RawMessage `json:"transport"`
+	Policy           json_util.RawMessage `json:"policy"`
+	API              json_util.RawMessage `json:"api"`
+	Stats            json_util.RawMessage `json:"stats"`
+	Reverse          json_util.RawMessage `json:"reverse"`
+	FakeDNS          json_util.RawMessage `json:"fakeDns"`
+	Observatory      json_util.RawMessage `json:"observatory"`
+	BurstObservatory json_util.RawMessage `json:"burstObservatory"`
 }
 
 func (c *Config) Equals(other *Config) bool {
@@ -58,5 +61,32 @@ func (c *Config) Equals(other *Config) bool {
 	if !bytes.Equal(c.FakeDNS, other.FakeDNS) {
 		return false
 	}
+	if !bytes.Equal(c.Observatory, other.Observatory) {
+		return false
+	}
+	if !bytes.Equal(c.BurstObservatory, other.BurstObservatory) {
+		return false
+	}
 	return true
 }
+
+func (c *Config) HasObservatory() bool {
+	return !isEmptyRaw(c.Observatory) || !isEmptyRaw(c.BurstObservatory)
+}
+
+func (c *Config) GetAccessLogPath() string {
+	logConfig := struct {
+		Access string `json:"access"`
+	}{}
+	if isEmptyRaw(c.LogConfig) || json.Unmarshal(c.LogConfig, &logConfig) != nil {
+		return ""
+	}
+	if logConfig.Access == "none" {
+		return ""
+	}
+	return logConfig.Access
+}
+
+func isEmptyRaw(m json_util.RawMessage) bool {
+	return len(m) == 0 || string(m) == "null"
+}
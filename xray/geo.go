@@ -0,0 +1,145 @@
+package xray
+
+import (
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"x-ui/util/common"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	GeoIP   = "geoip"
+	GeoSite = "geosite"
+)
+
+// ValidateGeoFile makes sure the file is a non empty geoip/geosite list xray can load
+func ValidateGeoFile(path string, geoType string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return common.NewError("geo file is empty:", path)
+	}
+
+	switch geoType {
+	case GeoIP:
+		list := &router.GeoIPList{}
+		err = proto.Unmarshal(data, list)
+		if err != nil {
+			return common.NewErrorf("invalid geoip file %v: %v", path, err)
+		}
+		if len(list.Entry) == 0 {
+			return common.NewError("geoip file has no entry:", path)
+		}
+		for _, entry := range list.Entry {
+			for _, cidr := range entry.Cidr {
+				if len(cidr.Ip) != 4 && len(cidr.Ip) != 16 {
+					return common.NewErrorf("invalid geoip file %v: bad cidr in %v", path, entry.CountryCode)
+				}
+			}
+		}
+	case GeoSite:
+		list := &router.GeoSiteList{}
+		err = proto.Unmarshal(data, list)
+		if err != nil {
+			return common.NewErrorf("invalid geosite file %v: %v", path, err)
+		}
+		if len(list.Entry) == 0 {
+			return common.NewError("geosite file has no entry:", path)
+		}
+		for _, entry := range list.Entry {
+			for _, domain := range entry.Domain {
+				if domain.Value == "" {
+					return common.NewErrorf("invalid geosite file %v: empty domain in %v", path, entry.CountryCode)
+				}
+			}
+		}
+	default:
+		return common.NewError("unknown geo file type:", geoType)
+	}
+	return nil
+}
+
+type geoIPRange struct {
+	start   netip.Addr
+	end     netip.Addr
+	country string
+}
+
+// GeoIPLookup resolves addresses to the country codes of a geoip file
+type GeoIPLookup struct {
+	ranges []geoIPRange
+}
+
+// LoadGeoIPLookup reads the country entries of a geoip file, tags like private or telegram are skipped
+func LoadGeoIPLookup(path string) (*GeoIPLookup, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list := &router.GeoIPList{}
+	err = proto.Unmarshal(data, list)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := &GeoIPLookup{}
+	for _, entry := range list.Entry {
+		if len(entry.CountryCode) != 2 || entry.ReverseMatch {
+			continue
+		}
+		country := strings.ToUpper(entry.CountryCode)
+		for _, cidr := range entry.Cidr {
+			addr, ok := netip.AddrFromSlice(cidr.Ip)
+			if !ok {
+				continue
+			}
+			prefix, err := addr.Prefix(int(cidr.Prefix))
+			if err != nil {
+				continue
+			}
+			lookup.ranges = append(lookup.ranges, geoIPRange{
+				start:   prefix.Addr(),
+				end:     lastAddr(prefix),
+				country: country,
+			})
+		}
+	}
+	sort.Slice(lookup.ranges, func(i, j int) bool {
+		return lookup.ranges[i].start.Less(lookup.ranges[j].start)
+	})
+	return lookup, nil
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+func (l *GeoIPLookup) Country(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	i := sort.Search(len(l.ranges), func(i int) bool {
+		return addr.Less(l.ranges[i].start)
+	})
+	// nested ranges are rare, look back a few entries for one that covers addr
+	for j := i - 1; j >= 0 && j >= i-8; j-- {
+		r := l.ranges[j]
+		if r.start.BitLen() == addr.BitLen() && !r.end.Less(addr) {
+			return r.country
+		}
+	}
+	return ""
+}
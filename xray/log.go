@@ -0,0 +1,51 @@
+package xray
+
+import (
+	"regexp"
+	"time"
+	"x-ui/logger"
+)
+
+var logTimeRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})(\.\d+)? (.*)$`)
+var logLevelRegex = regexp.MustCompile(`^\[(Debug|Info|Warning|Error)\] (?:\[\d+\] )?(.*)$`)
+var accessLogRegex = regexp.MustCompile(`^(?:from )?(\S+) (accepted|rejected) (\S+)(?: \[([^\]]*)\])?(?: email: (\S+))?`)
+
+// ParseLogLine splits an xray log line into its timestamp, level and access log fields
+func ParseLogLine(line string) *logger.Entry {
+	entry := &logger.Entry{
+		Level:   "info",
+		Source:  logger.SourceXray,
+		Message: line,
+	}
+
+	rest := line
+	if matches := logTimeRegex.FindStringSubmatch(line); matches != nil {
+		t, err := time.ParseInLocation("2006/01/02 15:04:05", matches[1], time.Local)
+		if err == nil {
+			entry.Time = t.UnixMilli()
+		}
+		rest = matches[3]
+		entry.Message = rest
+	}
+
+	if matches := logLevelRegex.FindStringSubmatch(rest); matches != nil {
+		entry.Level = matches[1]
+		entry.Message = matches[2]
+		return entry
+	}
+
+	if matches := accessLogRegex.FindStringSubmatch(rest); matches != nil {
+		entry.Email = matches[5]
+		entry.Fields = map[string]string{
+			"type":        "access",
+			"from":        matches[1],
+			"status":      matches[2],
+			"destination": matches[3],
+		}
+		if route := routeRegex.Split(matches[4], 2); len(route) == 2 {
+			entry.Fields["inbound"] = route[0]
+			entry.Fields["outbound"] = route[1]
+		}
+	}
+	return entry
+}
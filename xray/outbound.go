@@ -0,0 +1,10 @@
+package xray
+
+type OutboundStatus struct {
+	Tag          string `json:"tag"`
+	Alive        bool   `json:"alive"`
+	Delay        int64  `json:"delay"`
+	LastError    string `json:"lastError"`
+	LastSeenTime int64  `json:"lastSeenTime"`
+	LastTryTime  int64  `json:"lastTryTime"`
+}
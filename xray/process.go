@@ -2,12 +2,13 @@ package xray
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
@@ -15,13 +16,18 @@ import (
 	"strings"
 	"time"
 	"x-ui/config"
+	"x-ui/logger"
 	"x-ui/util/common"
 
 	"github.com/Workiva/go-datastructures/queue"
+	observatoryservice "github.com/xtls/xray-core/app/observatory/command"
 	statsservice "github.com/xtls/xray-core/app/stats/command"
 	"google.golang.org/grpc"
 )
 
+// readyGrace is how long a process without an api inbound must keep running to count as started
+const readyGrace = 2 * time.Second
+
 var trafficRegex = regexp.MustCompile("(inbound|outbound)>>>([^>]+)>>>traffic>>>(downlink|uplink)")
 var ClientTrafficRegex = regexp.MustCompile("(user)>>>([^>]+)>>>traffic>>>(downlink|uplink)")
 
@@ -38,15 +44,19 @@ func GetConfigPath() string {
 }
 
 func GetGeositePath() string {
-	return config.GetBinFolderPath() + "/geosite.dat"
+	return GetGeoAssetPath("geosite.dat")
 }
 
 func GetGeoipPath() string {
-	return config.GetBinFolderPath() + "/geoip.dat"
+	return GetGeoAssetPath("geoip.dat")
 }
 
 func GetIranPath() string {
-	return config.GetBinFolderPath() + "/iran.dat"
+	return GetGeoAssetPath("iran.dat")
+}
+
+func GetGeoAssetPath(name string) string {
+	return config.GetBinFolderPath() + "/" + name
 }
 
 func GetBlockedIPsPath() string {
@@ -114,6 +124,34 @@ func (p *process) GetResult() string {
 	return strings.Join(lines, "\n")
 }
 
+// WaitReady waits until the api inbound accepts connections, it fails as soon as the process exits.
+// Without an api inbound the process only has to stay up for readyGrace.
+func (p *process) WaitReady(timeout time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		if !p.IsRunning() {
+			if p.exitErr != nil {
+				return p.exitErr
+			}
+			return errors.New("xray is not running")
+		}
+		if p.apiPort > 0 {
+			conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", p.apiPort), time.Second)
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+		} else if time.Since(start) >= readyGrace {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("xray api did not come up in time")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
 func (p *process) GetVersion() string {
 	return p.version
 }
@@ -136,18 +174,7 @@ func (p *process) refreshAPIPort() {
 }
 
 func (p *process) refreshVersion() {
-	cmd := exec.Command(GetBinaryPath(), "-version")
-	data, err := cmd.Output()
-	if err != nil {
-		p.version = "Unknown"
-	} else {
-		datas := bytes.Split(data, []byte(" "))
-		if len(datas) <= 1 {
-			p.version = "Unknown"
-		} else {
-			p.version = string(datas[1])
-		}
-	}
+	p.version = GetBinaryVersion(GetBinaryPath())
 }
 
 func (p *process) Start() (err error) {
@@ -183,41 +210,8 @@ func (p *process) Start() (err error) {
 		return err
 	}
 
-	go func() {
-		defer func() {
-			common.Recover("")
-			stdReader.Close()
-		}()
-		reader := bufio.NewReaderSize(stdReader, 8192)
-		for {
-			line, _, err := reader.ReadLine()
-			if err != nil {
-				return
-			}
-			if p.lines.Len() >= 100 {
-				p.lines.Get(1)
-			}
-			p.lines.Put(string(line))
-		}
-	}()
-
-	go func() {
-		defer func() {
-			common.Recover("")
-			errReader.Close()
-		}()
-		reader := bufio.NewReaderSize(errReader, 8192)
-		for {
-			line, _, err := reader.ReadLine()
-			if err != nil {
-				return
-			}
-			if p.lines.Len() >= 100 {
-				p.lines.Get(1)
-			}
-			p.lines.Put(string(line))
-		}
-	}()
+	go p.readLines(stdReader)
+	go p.readLines(errReader)
 
 	go func() {
 		err := cmd.Run()
@@ -232,6 +226,26 @@ func (p *process) Start() (err error) {
 	return nil
 }
 
+// readLines keeps the last lines for GetResult and sends every line to the log store
+func (p *process) readLines(r io.ReadCloser) {
+	defer func() {
+		common.Recover("")
+		r.Close()
+	}()
+	reader := bufio.NewReaderSize(r, 8192)
+	for {
+		line, _, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		if p.lines.Len() >= 100 {
+			p.lines.Get(1)
+		}
+		p.lines.Put(string(line))
+		logger.AddEntry(ParseLogLine(string(line)))
+	}
+}
+
 func (p *process) Stop() error {
 	if !p.IsRunning() {
 		return errors.New("xray is not running")
@@ -320,3 +334,35 @@ func (p *process) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
 
 	return traffics, clientTraffics, nil
 }
+
+func (p *process) GetOutboundStatus() ([]*OutboundStatus, error) {
+	if p.apiPort == 0 {
+		return nil, common.NewError("xray api port wrong:", p.apiPort)
+	}
+	conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%v", p.apiPort), grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	client := observatoryservice.NewObservatoryServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	resp, err := client.GetOutboundStatus(ctx, &observatoryservice.GetOutboundStatusRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*OutboundStatus, 0)
+	for _, status := range resp.GetStatus().GetStatus() {
+		statuses = append(statuses, &OutboundStatus{
+			Tag:          status.OutboundTag,
+			Alive:        status.Alive,
+			Delay:        status.Delay,
+			LastError:    status.LastErrorReason,
+			LastSeenTime: status.LastSeenTime,
+			LastTryTime:  status.LastTryTime,
+		})
+	}
+	return statuses, nil
+}